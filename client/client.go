@@ -0,0 +1,219 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	ptm_http "github.com/mitre/ptmatch/http"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// Client submits record sets, FHIR resources and answer keys to a patient
+// matching test harness. The test harness also serves as the FHIR server
+// that holds the records.
+type Client struct {
+	BaseURL string
+}
+
+// New returns a Client for the test harness at the given URL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// CreateRecordSet posts the record set to the test harness and returns the
+// record set as stored by the server, including its assigned identifier.
+// The server's dry run of the search expression is skipped, since the
+// records are expected to be uploaded after the record set is created.
+func (c *Client) CreateRecordSet(recSet *ptm_models.RecordSet) (*ptm_models.RecordSet, error) {
+	body, err := json.Marshal(recSet)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ptm_http.Post(c.BaseURL+"/RecordSet?validate=false", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, unexpectedStatus("creating a record set", resp)
+	}
+
+	created := &ptm_models.RecordSet{}
+	if err = json.NewDecoder(resp.Body).Decode(created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// CreateResource posts a FHIR resource of the given type and returns the URL
+// of the new resource.
+func (c *Client) CreateResource(resourceType string, resource interface{}) (string, error) {
+	body, err := json.Marshal(resource)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := ptm_http.Post(c.BaseURL+"/"+resourceType, "application/json+fhir", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", unexpectedStatus("creating a "+resourceType, resp)
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		// fall back to the identifier in the returned resource
+		var created struct {
+			ID string `json:"id"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&created); err != nil || created.ID == "" {
+			return "", errors.New("Unable to determine the location of the new " + resourceType)
+		}
+		location = c.BaseURL + "/" + resourceType + "/" + created.ID
+	}
+	// drop any version suffix (e.g., /_history/1)
+	if idx := strings.Index(location, "/_history/"); idx >= 0 {
+		location = location[:idx]
+	}
+	return location, nil
+}
+
+// PutResource puts a FHIR resource of the given type with a client-assigned
+// identifier, which must look like a BSON object id, and returns the URL of the
+// resource. The resource's id is set to the identifier, replacing any id it
+// had in its source (e.g., a Synthea uuid). Unlike CreateResource, repeating
+// the request (e.g., after a timeout) doesn't create another copy of the
+// resource.
+func (c *Client) PutResource(resourceType, id string, resource map[string]interface{}) (string, error) {
+	resource["id"] = id
+	body, err := json.Marshal(resource)
+	if err != nil {
+		return "", err
+	}
+
+	location := c.BaseURL + "/" + resourceType + "/" + id
+	resp, err := ptm_http.Put(location, "application/json+fhir", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", unexpectedStatus("putting a "+resourceType, resp)
+	}
+	return location, nil
+}
+
+// SetAnswerKey associates the answer key with the identified record set.
+func (c *Client) SetAnswerKey(recSet *ptm_models.RecordSet, answerKey *fhir_models.Bundle) error {
+	key, err := json.Marshal(answerKey)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err = form.WriteField("recordSetId", recSet.ID.Hex()); err != nil {
+		return err
+	}
+	part, err := form.CreateFormFile("answerKey", "answerKey.json")
+	if err != nil {
+		return err
+	}
+	if _, err = part.Write(key); err != nil {
+		return err
+	}
+	if err = form.Close(); err != nil {
+		return err
+	}
+
+	resp, err := ptm_http.Post(c.BaseURL+"/AnswerKey", form.FormDataContentType(), &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return unexpectedStatus("setting the answer key", resp)
+	}
+	return nil
+}
+
+// GetRecordSet retrieves the identified record set from the test harness.
+func (c *Client) GetRecordSet(id string) (*ptm_models.RecordSet, error) {
+	resp, err := ptm_http.Get(c.BaseURL + "/RecordSet/" + id)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, unexpectedStatus("retrieving a record set", resp)
+	}
+
+	recSet := &ptm_models.RecordSet{}
+	if err = json.NewDecoder(resp.Body).Decode(recSet); err != nil {
+		return nil, err
+	}
+	return recSet, nil
+}
+
+// StatusError is returned when the server responds with an unexpected
+// HTTP status code.
+type StatusError struct {
+	Action     string
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Unexpected status code when %s: %d %s", e.Action, e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the request may succeed if it is retried.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// IsTemporary reports whether a request that failed with err may succeed if
+// it is retried. Errors other than a StatusError (e.g., network errors) are
+// considered temporary.
+func IsTemporary(err error) bool {
+	if statusErr, ok := err.(*StatusError); ok {
+		return statusErr.Temporary()
+	}
+	return true
+}
+
+func unexpectedStatus(action string, resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return &StatusError{Action: action, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+)
+
+const mrnSystem = "http://mitre.org/ptmatch/generator/mrn"
+
+// Patients are born in the years from minBirthYear to maxBirthYear.
+const (
+	minBirthYear = 1920
+	maxBirthYear = 2014
+)
+
+var maleNames = []string{"James", "John", "Robert", "Michael", "William",
+	"David", "Richard", "Joseph", "Thomas", "Charles", "Christopher", "Daniel",
+	"Matthew", "Anthony", "Donald", "Steven", "Andrew", "Joshua", "Kenneth", "Edward"}
+
+var femaleNames = []string{"Mary", "Patricia", "Jennifer", "Elizabeth", "Linda",
+	"Barbara", "Susan", "Jessica", "Margaret", "Sarah", "Karen", "Nancy",
+	"Deborah", "Rebecca", "Katherine", "Christine", "Samantha", "Victoria", "Abigail", "Theresa"}
+
+var familyNames = []string{"Smith", "Johnson", "Williams", "Brown", "Jones",
+	"Garcia", "Miller", "Davis", "Rodriguez", "Martinez", "Hernandez", "Lopez",
+	"Gonzalez", "Wilson", "Anderson", "Thomas", "Taylor", "Moore", "Jackson",
+	"Martin", "Lee", "Perez", "Thompson", "White", "Harris", "Sanchez", "Clark",
+	"Ramirez", "Lewis", "Robinson"}
+
+var nicknames = map[string][]string{
+	"James": {"Jim", "Jimmy"}, "John": {"Jack", "Johnny"}, "Robert": {"Bob", "Rob", "Bobby"},
+	"Michael": {"Mike", "Mickey"}, "William": {"Bill", "Will", "Billy"}, "David": {"Dave"},
+	"Richard": {"Rick", "Dick", "Rich"}, "Joseph": {"Joe", "Joey"}, "Thomas": {"Tom", "Tommy"},
+	"Charles": {"Charlie", "Chuck"}, "Christopher": {"Chris"}, "Daniel": {"Dan", "Danny"},
+	"Matthew": {"Matt"}, "Anthony": {"Tony"}, "Donald": {"Don"}, "Steven": {"Steve"},
+	"Andrew": {"Andy", "Drew"}, "Joshua": {"Josh"}, "Kenneth": {"Ken", "Kenny"},
+	"Edward": {"Ed", "Eddie", "Ted"}, "Mary": {"Molly", "Polly"}, "Patricia": {"Pat", "Patty", "Trish"},
+	"Jennifer": {"Jen", "Jenny"}, "Elizabeth": {"Liz", "Beth", "Betty"}, "Linda": {"Lindy"},
+	"Barbara": {"Barb"}, "Susan": {"Sue", "Susie"}, "Jessica": {"Jess"}, "Margaret": {"Maggie", "Peggy"},
+	"Sarah": {"Sally"}, "Nancy": {"Nan"}, "Deborah": {"Deb", "Debbie"}, "Rebecca": {"Becky"},
+	"Katherine": {"Kate", "Kathy", "Katie"}, "Christine": {"Chris", "Tina"}, "Samantha": {"Sam"},
+	"Victoria": {"Vicky", "Tori"}, "Abigail": {"Abby"}, "Theresa": {"Terry", "Tess"},
+}
+
+var streetNames = []string{"Main St", "Oak Ave", "Maple Dr", "Cedar Ln", "Elm St",
+	"Washington Blvd", "Lake Rd", "Hill St", "Park Ave", "Pine St", "River Rd", "Church St"}
+
+var cities = []struct{ city, state, zip string }{
+	{"Bedford", "MA", "01730"}, {"Burlington", "MA", "01803"}, {"McLean", "VA", "22102"},
+	{"Arlington", "VA", "22201"}, {"Boston", "MA", "02110"}, {"Reston", "VA", "20190"},
+	{"Nashua", "NH", "03060"}, {"Baltimore", "MD", "21201"}}
+
+// corruptionRates holds the probability that each kind of corruption is
+// applied to a duplicate record.
+type corruptionRates struct {
+	Typo     float64
+	Nickname float64
+	DateSwap float64
+	Missing  float64
+	Move     float64
+}
+
+// generator creates synthetic patients and corrupted copies of them.
+type generator struct {
+	rnd     *rand.Rand
+	rates   corruptionRates
+	nextMRN int
+}
+
+func newGenerator(seed int64, rates corruptionRates) *generator {
+	return &generator{rnd: rand.New(rand.NewSource(seed)), rates: rates, nextMRN: 100000}
+}
+
+func (g *generator) pick(values []string) string {
+	return values[g.rnd.Intn(len(values))]
+}
+
+func (g *generator) chance(p float64) bool {
+	return g.rnd.Float64() < p
+}
+
+func (g *generator) mrn() fhir_models.Identifier {
+	g.nextMRN++
+	return fhir_models.Identifier{Use: "usual", System: mrnSystem, Value: strconv.Itoa(g.nextMRN)}
+}
+
+func (g *generator) address() fhir_models.Address {
+	c := cities[g.rnd.Intn(len(cities))]
+	line := fmt.Sprintf("%d %s", 1+g.rnd.Intn(9999), g.pick(streetNames))
+	return fhir_models.Address{Use: "home", Line: []string{line}, City: c.city, State: c.state, PostalCode: c.zip}
+}
+
+func (g *generator) phone() fhir_models.ContactPoint {
+	number := fmt.Sprintf("%03d-%03d-%04d", 200+g.rnd.Intn(800), 200+g.rnd.Intn(800), g.rnd.Intn(10000))
+	return fhir_models.ContactPoint{System: "phone", Value: number, Use: "home"}
+}
+
+func (g *generator) givenName(gender string) string {
+	if gender == "male" {
+		return g.pick(maleNames)
+	}
+	return g.pick(femaleNames)
+}
+
+// newPatient creates a patient with randomly chosen demographics.
+func (g *generator) newPatient() *fhir_models.Patient {
+	gender := "female"
+	if g.chance(0.5) {
+		gender = "male"
+	}
+	birth := time.Date(minBirthYear+g.rnd.Intn(maxBirthYear-minBirthYear+1), time.Month(1+g.rnd.Intn(12)), 1+g.rnd.Intn(28), 0, 0, 0, 0, time.UTC)
+
+	patient := &fhir_models.Patient{}
+	patient.Identifier = []fhir_models.Identifier{g.mrn()}
+	patient.Name = []fhir_models.HumanName{fhir_models.HumanName{
+		Use: "official", Family: []string{g.pick(familyNames)}, Given: []string{g.givenName(gender)}}}
+	patient.Gender = gender
+	patient.BirthDate = &fhir_models.FHIRDateTime{Time: birth, Precision: fhir_models.Date}
+	patient.Address = []fhir_models.Address{g.address()}
+	patient.Telecom = []fhir_models.ContactPoint{g.phone()}
+	return patient
+}
+
+// newTwin creates a different person who shares the patient's family name,
+// birth date, address and phone number. Twins are deliberately not
+// duplicates and do not appear in the answer key.
+func (g *generator) newTwin(patient *fhir_models.Patient) *fhir_models.Patient {
+	twin := copyPatient(patient)
+	twin.Identifier = []fhir_models.Identifier{g.mrn()}
+	given := patient.Name[0].Given[0]
+	for given == patient.Name[0].Given[0] {
+		given = g.givenName(twin.Gender)
+	}
+	twin.Name[0].Given = []string{given}
+
+	order := int32(2)
+	twin.MultipleBirthInteger = &order
+	first := int32(1)
+	patient.MultipleBirthInteger = &first
+	return twin
+}
+
+// newDuplicate creates a copy of the patient, as another system might record
+// it, and applies randomly selected corruptions. The corruptions applied are
+// returned so they can be reported.
+func (g *generator) newDuplicate(patient *fhir_models.Patient) (*fhir_models.Patient, []string) {
+	dup := copyPatient(patient)
+	// a duplicate record comes from a different source system
+	dup.Identifier = []fhir_models.Identifier{g.mrn()}
+
+	var applied []string
+	if g.chance(g.rates.Nickname) {
+		if names, ok := nicknames[dup.Name[0].Given[0]]; ok {
+			dup.Name[0].Given = []string{g.pick(names)}
+			applied = append(applied, "nickname")
+		}
+	}
+	if g.chance(g.rates.Typo) {
+		if g.chance(0.5) {
+			dup.Name[0].Given = []string{g.typo(dup.Name[0].Given[0])}
+		} else {
+			dup.Name[0].Family = []string{g.typo(dup.Name[0].Family[0])}
+		}
+		applied = append(applied, "typo")
+	}
+	if g.chance(g.rates.DateSwap) && dup.BirthDate != nil {
+		if birth, ok := g.transposeDate(dup.BirthDate.Time); ok {
+			dup.BirthDate = &fhir_models.FHIRDateTime{Time: birth, Precision: fhir_models.Date}
+			applied = append(applied, "date")
+		}
+	}
+	if g.chance(g.rates.Move) {
+		dup.Address = []fhir_models.Address{g.address()}
+		dup.Telecom = []fhir_models.ContactPoint{g.phone()}
+		applied = append(applied, "move")
+	}
+	if g.chance(g.rates.Missing) {
+		switch g.rnd.Intn(3) {
+		case 0:
+			dup.Address = nil
+		case 1:
+			dup.Telecom = nil
+		default:
+			dup.BirthDate = nil
+		}
+		applied = append(applied, "missing")
+	}
+	return dup, applied
+}
+
+// typo introduces a single character edit: a substitution, deletion,
+// insertion or transposition of adjacent characters. The result always
+// differs from s.
+func (g *generator) typo(s string) string {
+	if len(s) < 2 {
+		return s
+	}
+	for {
+		b := []byte(s)
+		i := 1 + g.rnd.Intn(len(b)-1)
+		letter := byte('a' + g.rnd.Intn(26))
+		switch g.rnd.Intn(4) {
+		case 0:
+			b[i] = letter
+		case 1:
+			b = append(b[:i], b[i+1:]...)
+		case 2:
+			b = append(b[:i], append([]byte{letter}, b[i:]...)...)
+		default:
+			b[i-1], b[i] = b[i], b[i-1]
+		}
+		// substituting the same letter or swapping equal letters changes
+		// nothing, so try again
+		if string(b) != s {
+			return string(b)
+		}
+	}
+}
+
+// transposeDate swaps the month and day of a date, the digits of the day or
+// the last two digits of the year, whichever first yields a different valid
+// birth date: one that isn't in the future or before minBirthYear. False is
+// returned if no transposition applies.
+func (g *generator) transposeDate(t time.Time) (time.Time, bool) {
+	var candidates []time.Time
+	if t.Day() <= 12 && t.Day() != int(t.Month()) {
+		candidates = append(candidates, time.Date(t.Year(), time.Month(t.Day()), int(t.Month()), 0, 0, 0, 0, time.UTC))
+	}
+	day := fmt.Sprintf("%02d", t.Day())
+	if swapped, _ := strconv.Atoi(string([]byte{day[1], day[0]})); swapped >= 1 && swapped <= 28 && swapped != t.Day() {
+		candidates = append(candidates, time.Date(t.Year(), t.Month(), swapped, 0, 0, 0, 0, time.UTC))
+	}
+	year := strconv.Itoa(t.Year())
+	if year[2] != year[3] {
+		swapped, _ := strconv.Atoi(year[:2] + string([]byte{year[3], year[2]}))
+		candidates = append(candidates, time.Date(swapped, t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+	}
+	now := time.Now()
+	for _, candidate := range candidates {
+		if !candidate.After(now) && candidate.Year() >= minBirthYear {
+			return candidate, true
+		}
+	}
+	return t, false
+}
+
+func copyPatient(patient *fhir_models.Patient) *fhir_models.Patient {
+	c := *patient
+	c.Identifier = append([]fhir_models.Identifier(nil), patient.Identifier...)
+	c.Name = make([]fhir_models.HumanName, len(patient.Name))
+	for i, name := range patient.Name {
+		c.Name[i] = name
+		c.Name[i].Family = append([]string(nil), name.Family...)
+		c.Name[i].Given = append([]string(nil), name.Given...)
+	}
+	c.Address = append([]fhir_models.Address(nil), patient.Address...)
+	c.Telecom = append([]fhir_models.ContactPoint(nil), patient.Telecom...)
+	return &c
+}
+
+func describe(patient *fhir_models.Patient) string {
+	return strings.Join(patient.Name[0].Given, " ") + " " + strings.Join(patient.Name[0].Family, " ")
+}
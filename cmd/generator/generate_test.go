@@ -0,0 +1,153 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type GeneratorSuite struct {
+}
+
+var _ = Suite(&GeneratorSuite{})
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func (s *GeneratorSuite) TestTypo(c *C) {
+	g := newGenerator(1, corruptionRates{})
+	for _, name := range []string{"Jennifer", "Lee", "Anna", "Al", "Abby"} {
+		for i := 0; i < 200; i++ {
+			typo := g.typo(name)
+			c.Assert(typo, Not(Equals), name)
+			c.Assert(editDistance(name, typo), Equals, 1, Commentf("%s became %s", name, typo))
+		}
+	}
+	c.Assert(g.typo("J"), Equals, "J")
+}
+
+func (s *GeneratorSuite) TestTransposeDate(c *C) {
+	g := newGenerator(1, corruptionRates{})
+	for _, expected := range []struct {
+		birth, transposed time.Time
+		ok                bool
+	}{
+		// month and day
+		{date(1980, 3, 7), date(1980, 7, 3), true},
+		// digits of the day
+		{date(1975, 5, 21), date(1975, 5, 12), true},
+		{date(2013, 10, 30), date(2013, 10, 3), true},
+		// digits of the year
+		{date(1962, 8, 14), date(1926, 8, 14), true},
+		// the year would be in the future
+		{date(2013, 10, 29), date(2013, 10, 29), false},
+		{date(2014, 6, 25), date(2014, 6, 25), false},
+		// the year would be before any birth date
+		{date(1991, 11, 11), date(1991, 11, 11), false},
+		{date(1980, 3, 3), date(1980, 3, 3), false},
+		// nothing to swap
+		{date(1955, 12, 25), date(1955, 12, 25), false},
+	} {
+		transposed, ok := g.transposeDate(expected.birth)
+		c.Assert(ok, Equals, expected.ok, Commentf("%s", expected.birth))
+		c.Assert(transposed, Equals, expected.transposed, Commentf("%s", expected.birth))
+	}
+}
+
+func (s *GeneratorSuite) TestNewDuplicate(c *C) {
+	for _, expected := range []struct {
+		rates   corruptionRates
+		applied []string
+	}{
+		{corruptionRates{}, nil},
+		{corruptionRates{Nickname: 1}, []string{"nickname"}},
+		{corruptionRates{Typo: 1}, []string{"typo"}},
+		{corruptionRates{DateSwap: 1}, []string{"date"}},
+		{corruptionRates{Move: 1}, []string{"move"}},
+		{corruptionRates{Missing: 1}, []string{"missing"}},
+		{corruptionRates{Nickname: 1, Typo: 1, DateSwap: 1, Move: 1, Missing: 1},
+			[]string{"nickname", "typo", "date", "move", "missing"}},
+	} {
+		g := newGenerator(1, expected.rates)
+		patient := g.newPatient()
+		patient.Name[0].Given = []string{"Robert"}
+		patient.BirthDate = &fhir_models.FHIRDateTime{Time: date(1980, 3, 7), Precision: fhir_models.Date}
+		original := copyPatient(patient)
+
+		dup, applied := g.newDuplicate(patient)
+		comment := Commentf("%v", expected.rates)
+		c.Assert(applied, DeepEquals, expected.applied, comment)
+		// the duplicate comes from another system, and the original is untouched
+		c.Assert(dup.Identifier[0].Value, Not(Equals), patient.Identifier[0].Value, comment)
+		c.Assert(patient, DeepEquals, original, comment)
+
+		if len(expected.applied) == 0 {
+			dup.Identifier = patient.Identifier
+			c.Assert(dup, DeepEquals, patient)
+		}
+		if expected.rates.DateSwap == 1 && expected.rates.Missing == 0 {
+			c.Assert(dup.BirthDate.Time, Equals, date(1980, 7, 3))
+		}
+		if expected.rates.Missing == 1 {
+			c.Assert(dup.Address == nil || dup.Telecom == nil || dup.BirthDate == nil, Equals, true, comment)
+		}
+	}
+}
+
+// editDistance returns the number of insertions, deletions, substitutions
+// and transpositions of adjacent characters that turn a into b.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
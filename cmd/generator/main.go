@@ -0,0 +1,145 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	"github.com/mitre/ptmatch/client"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// This application creates a synthetic record set of Patients with known
+// duplicates in the patient matching test harness. Each duplicate is a copy
+// of a generated patient with configurable corruptions applied (typos,
+// nicknames, transposed dates, missing fields and address moves). Twins,
+// who share most demographics but are different people, may also be added.
+// The record set, its Patients and the matching answer key are uploaded in
+// one step, so every benchmark comes with exact ground truth.
+func main() {
+	fhirURL := flag.String("fhirURL", "", "URL for the patient matching test harness server")
+	recordSetName := flag.String("name", "", "Name of the record set")
+	count := flag.Int("count", 100, "Number of distinct patients to generate")
+	dupRate := flag.Float64("dupRate", 0.2, "Fraction of patients that have duplicates")
+	maxDups := flag.Int("maxDups", 2, "Maximum number of duplicates of one patient")
+	twinRate := flag.Float64("twinRate", 0.02, "Fraction of patients that have a twin")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "Seed for the random number generator")
+	verbose := flag.Bool("v", false, "Print each generated record")
+
+	rates := corruptionRates{}
+	flag.Float64Var(&rates.Typo, "typo", 0.3, "Probability a duplicate has a typo in its name")
+	flag.Float64Var(&rates.Nickname, "nickname", 0.2, "Probability a duplicate uses a nickname")
+	flag.Float64Var(&rates.DateSwap, "dateSwap", 0.1, "Probability a duplicate has a transposed birth date")
+	flag.Float64Var(&rates.Missing, "missing", 0.2, "Probability a duplicate is missing a field")
+	flag.Float64Var(&rates.Move, "move", 0.15, "Probability a duplicate has a different address")
+
+	flag.Parse()
+
+	argsToName := map[string]string{"fhirURL": *fhirURL, "name": *recordSetName}
+	for argName, argValue := range argsToName {
+		if argValue == "" {
+			fmt.Printf("You must provide an argument for %s\n", argName)
+			os.Exit(1)
+		}
+	}
+	if *count < 1 || *maxDups < 1 {
+		fmt.Println("count and maxDups must be positive")
+		os.Exit(1)
+	}
+
+	harness := client.New(*fhirURL)
+
+	recordSet := ptm_models.NewTaggedRecordSet(harness.BaseURL, *recordSetName, "Patient")
+	recordSet.Description = fmt.Sprintf("Synthetic patients (count: %d, dupRate: %g, seed: %d)",
+		*count, *dupRate, *seed)
+	recordSet, err := harness.CreateRecordSet(recordSet)
+	if err != nil {
+		fmt.Printf("Couldn't create the record set: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	tag := recordSet.TagCoding()
+	upload := func(patient *fhir_models.Patient) string {
+		patient.Meta = &fhir_models.Meta{Tag: []fhir_models.Coding{tag}}
+		location, err := harness.CreateResource("Patient", patient)
+		if err != nil {
+			fmt.Printf("Couldn't upload patient: %s\n", err.Error())
+			os.Exit(1)
+		}
+		return location
+	}
+
+	gen := newGenerator(*seed, rates)
+	var pairs []ptm_models.RecordPair
+	numRecords := 0
+
+	for i := 0; i < *count; i++ {
+		patient := gen.newPatient()
+
+		var twin *fhir_models.Patient
+		if gen.chance(*twinRate) {
+			twin = gen.newTwin(patient)
+		}
+
+		cluster := []string{upload(patient)}
+		if *verbose {
+			fmt.Printf("%s %s\n", cluster[0], describe(patient))
+		}
+
+		if gen.chance(*dupRate) {
+			numDups := 1 + gen.rnd.Intn(*maxDups)
+			for d := 0; d < numDups; d++ {
+				dup, applied := gen.newDuplicate(patient)
+				location := upload(dup)
+				if *verbose {
+					fmt.Printf("  duplicate %s %s [%s]\n", location, describe(dup), strings.Join(applied, ","))
+				}
+				cluster = append(cluster, location)
+			}
+		}
+
+		if twin != nil {
+			location := upload(twin)
+			numRecords++
+			if *verbose {
+				fmt.Printf("  twin %s %s\n", location, describe(twin))
+			}
+		}
+
+		numRecords += len(cluster)
+		// every pair of records in a cluster is a match
+		for a := 0; a < len(cluster); a++ {
+			for b := a + 1; b < len(cluster); b++ {
+				pairs = append(pairs, ptm_models.NewRecordPair(cluster[a], cluster[b]))
+			}
+		}
+	}
+
+	answerKey := ptm_models.NewAnswerKey(recordSet, pairs)
+	if err = harness.SetAnswerKey(recordSet, answerKey); err != nil {
+		fmt.Printf("Couldn't set the answer key: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("Created record set %s (%s) with %d records and %d matching pairs\n",
+		recordSet.ID.Hex(), recordSet.Name, numRecords, len(pairs))
+}
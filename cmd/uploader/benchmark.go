@@ -0,0 +1,115 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// benchmarkMappings are the CSV mappings of well-known record linkage
+// benchmark datasets, selected with the -format flag.
+var benchmarkMappings = map[string]string{
+	// CSV files produced by the FEBRL data generator, where duplicates of
+	// record rec-12-org are identified as rec-12-dup-0, rec-12-dup-1, ...
+	"febrl": `{
+	  "identifier": [{"column": "rec_id", "system": "` + ptm_models.FEBRLRecordIDSystem + `"},
+	                 {"column": "soc_sec_id", "system": "urn:febrl:soc_sec_id"}],
+	  "family": "surname",
+	  "given": ["given_name"],
+	  "birthDate": "date_of_birth",
+	  "birthDateFormat": "20060102",
+	  "gender": "sex",
+	  "address": {"line": ["street_number", "address_1", "address_2"], "city": "suburb",
+	              "state": "state", "postalCode": "postcode"},
+	  "phone": ["phone_number"],
+	  "cluster": "rec_id",
+	  "clusterPattern": "^rec-([0-9]+)-"
+	}`,
+	// the data file of the ONC Patient Matching Algorithm Challenge; the
+	// matches are given separately as pairs of enterprise IDs
+	"onc": `{
+	  "identifier": [{"column": "EnterpriseID", "system": "` + ptm_models.ONCEnterpriseIDSystem + `"},
+	                 {"column": "MRN", "system": "urn:onc:pmac:mrn"},
+	                 {"column": "SSN", "system": "http://hl7.org/fhir/sid/us-ssn"}],
+	  "family": "LAST",
+	  "given": ["FIRST", "MIDDLE"],
+	  "birthDate": "DOB",
+	  "birthDateFormat": "01/02/2006",
+	  "gender": "GENDER",
+	  "address": {"line": ["ADDRESS1", "ADDRESS2"], "city": "CITY", "state": "STATE", "postalCode": "ZIP"},
+	  "phone": ["PHONE", "PHONE2"],
+	  "email": ["EMAIL"],
+	  "cluster": "EnterpriseID"
+	}`,
+}
+
+// benchmarkMapping returns the CSV mapping of the named benchmark format.
+func benchmarkMapping(format string) (*csvMapping, error) {
+	mapping, ok := benchmarkMappings[strings.ToLower(format)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown format %s", format)
+	}
+	return parseCSVMapping(strings.NewReader(mapping))
+}
+
+// loadMatchPairs reads a CSV file in which the first two columns of each row
+// identify a pair of matching records, and returns the cluster of each record
+// identifier mentioned. Further columns (e.g., a score) and rows that don't
+// identify any records, such as a header, have no effect.
+func loadMatchPairs(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	var pairs []ptm_models.RecordPair
+	for lineNum := 1; ; lineNum++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't parse line %d of %s: %s", lineNum, path, err.Error())
+		}
+		if len(record) < 2 {
+			continue
+		}
+		a, b := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if a != "" && b != "" && a != b {
+			pairs = append(pairs, ptm_models.NewRecordPair(a, b))
+		}
+	}
+
+	clusterOf := make(map[string]string)
+	for _, cluster := range ptm_models.RecordClusters(pairs) {
+		for _, id := range cluster {
+			clusterOf[id] = cluster[0]
+		}
+	}
+	return clusterOf, nil
+}
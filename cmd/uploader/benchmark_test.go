@@ -0,0 +1,123 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	ptm_models "github.com/mitre/ptmatch/models"
+	. "gopkg.in/check.v1"
+)
+
+// readBenchmark reads the Patients in the fixture directory with the given
+// mapping and returns them and their clusters, both keyed by the record
+// identifier in the mapping's cluster column.
+func readBenchmark(c *C, path string, m *csvMapping) (map[string]map[string]interface{}, map[string]string) {
+	clusters := make(map[string]string)
+	sourceIDs := make(map[string]string)
+	patients := make(map[string]map[string]interface{})
+	err := readCSVResources(uploaderFixtures+path, m, clusters, func(source string, resource map[string]interface{}) error {
+		identifier := resource["identifier"].([]interface{})[0].(map[string]interface{})
+		id := identifier["value"].(string)
+		sourceIDs[source] = id
+		patients[id] = resource
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	clusterOf := make(map[string]string)
+	for source, cluster := range clusters {
+		clusterOf[sourceIDs[source]] = cluster
+	}
+	return patients, clusterOf
+}
+
+func toPatient(c *C, resource map[string]interface{}) *fhir_models.Patient {
+	b, err := json.Marshal(resource)
+	c.Assert(err, IsNil)
+	patient := &fhir_models.Patient{}
+	c.Assert(json.Unmarshal(b, patient), IsNil)
+	return patient
+}
+
+func (s *UploaderSuite) TestBenchmarkMapping(c *C) {
+	for _, format := range []string{"febrl", "FEBRL", "onc", "Onc"} {
+		m, err := benchmarkMapping(format)
+		c.Assert(err, IsNil, Commentf(format))
+		c.Assert(m.Cluster, Not(Equals), "")
+	}
+	_, err := benchmarkMapping("synthea")
+	c.Assert(err, NotNil)
+}
+
+func (s *UploaderSuite) TestFEBRL(c *C) {
+	m, err := benchmarkMapping("febrl")
+	c.Assert(err, IsNil)
+	patients, clusters := readBenchmark(c, "febrl", m)
+
+	// duplicates are in the cluster of their original record
+	c.Assert(clusters, DeepEquals, map[string]string{"rec-0-org": "0", "rec-0-dup-0": "0", "rec-0-dup-1": "0",
+		"rec-1-org": "1", "rec-2-org": "2", "rec-2-dup-0": "2"})
+
+	patient := toPatient(c, patients["rec-1-org"])
+	c.Assert(patient.Identifier, DeepEquals, []fhir_models.Identifier{
+		{System: ptm_models.FEBRLRecordIDSystem, Value: "rec-1-org"},
+		{System: "urn:febrl:soc_sec_id", Value: "1234567"}})
+	c.Assert(patient.Name[0].Family, DeepEquals, []string{"nguyen"})
+	c.Assert(patient.BirthDate.Time.Equal(time.Date(1972, 11, 30, 0, 0, 0, 0, time.UTC)), Equals, true)
+	c.Assert(patient.Address[0].Line, DeepEquals, []string{"7", "hill road", "flat 2"})
+	c.Assert(patient.Address[0].PostalCode, Equals, "2830")
+
+	// a record identifier not in the FEBRL format is its own cluster
+	row := csvRow{columns: map[string]int{"rec_id": 0}, record: []string{"patient-7"}}
+	c.Assert(m.cluster(row), Equals, "patient-7")
+}
+
+func (s *UploaderSuite) TestLoadMatchPairs(c *C) {
+	clusterOf, err := loadMatchPairs(uploaderFixtures + "onc/pairs.csv")
+	c.Assert(err, IsNil)
+	// matches are followed transitively, and a record matched with itself and
+	// rows without a pair are ignored; the header names no records, so its
+	// cluster is never used
+	c.Assert(clusterOf, DeepEquals, map[string]string{"15800001": "15800001", "15800002": "15800001",
+		"15800005": "15800001", "15800003": "15800003", "15800004": "15800003",
+		"EnterpriseID1": "EnterpriseID1", "EnterpriseID2": "EnterpriseID1"})
+
+	_, err = loadMatchPairs(uploaderFixtures + "onc/missing.csv")
+	c.Assert(err, NotNil)
+}
+
+func (s *UploaderSuite) TestONC(c *C) {
+	m, err := benchmarkMapping("onc")
+	c.Assert(err, IsNil)
+	m.clusterOf, err = loadMatchPairs(uploaderFixtures + "onc/pairs.csv")
+	c.Assert(err, IsNil)
+	patients, clusters := readBenchmark(c, "onc/records", m)
+
+	// a record without a match is in a cluster of its own
+	c.Assert(clusters, DeepEquals, map[string]string{"15800001": "15800001", "15800002": "15800001",
+		"15800005": "15800001", "15800003": "15800003", "15800004": "15800003", "15800006": "15800006"})
+
+	patient := toPatient(c, patients["15800003"])
+	c.Assert(patient.Name[0].Given, DeepEquals, []string{"DAVID", "K"})
+	c.Assert(patient.Gender, Equals, "male")
+	c.Assert(patient.BirthDate.Time.Equal(time.Date(1972, 11, 30, 0, 0, 0, 0, time.UTC)), Equals, true)
+	c.Assert(patient.Telecom, DeepEquals, []fhir_models.ContactPoint{{System: "phone", Value: "212-555-0199"},
+		{System: "phone", Value: "212-555-0100"}})
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// checkpoint records the progress of an upload so that an interrupted upload
+// can be resumed. The checkpoint file holds one JSON object per line: the
+// first identifies the record set and each following line records a
+// resource that was successfully created.
+type checkpoint struct {
+	RecordSetID string
+	// maps the source of each uploaded resource to its location on the server
+	Uploaded map[string]string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+type checkpointLine struct {
+	RecordSetID string `json:"recordSetId,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Location    string `json:"location,omitempty"`
+}
+
+// openCheckpoint reads the checkpoint file at path, if it exists, and opens
+// it for appending. An empty path returns a checkpoint that is not saved.
+func openCheckpoint(path string) (*checkpoint, error) {
+	cp := &checkpoint{Uploaded: make(map[string]string)}
+	if path == "" {
+		return cp, nil
+	}
+
+	// a partially written last line is ended, so that it doesn't swallow the
+	// next line appended
+	partial := false
+	if data, err := ioutil.ReadFile(path); err == nil {
+		partial = len(data) > 0 && data[len(data)-1] != '\n'
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var line checkpointLine
+			// a partially written last line is ignored
+			if json.Unmarshal(scanner.Bytes(), &line) != nil {
+				continue
+			}
+			if line.RecordSetID != "" {
+				cp.RecordSetID = line.RecordSetID
+			}
+			if line.Source != "" {
+				cp.Uploaded[line.Source] = line.Location
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if partial {
+		if _, err = f.Write([]byte{'\n'}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	cp.file = f
+	return cp, nil
+}
+
+// setRecordSet records the identifier of the record set being uploaded.
+func (cp *checkpoint) setRecordSet(id string) error {
+	cp.RecordSetID = id
+	return cp.append(checkpointLine{RecordSetID: id})
+}
+
+// markUploaded records that the resource read from source was created at
+// location.
+func (cp *checkpoint) markUploaded(source, location string) error {
+	return cp.append(checkpointLine{Source: source, Location: location})
+}
+
+func (cp *checkpoint) append(line checkpointLine) error {
+	if cp.file == nil {
+		return nil
+	}
+	b, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	_, err = cp.file.Write(append(b, '\n'))
+	return err
+}
+
+func (cp *checkpoint) close() error {
+	if cp.file == nil {
+		return nil
+	}
+	return cp.file.Close()
+}
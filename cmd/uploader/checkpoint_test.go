@@ -0,0 +1,67 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *UploaderSuite) TestCheckpoint(c *C) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "upload.checkpoint")
+
+	cp, err := openCheckpoint(path)
+	c.Assert(err, IsNil)
+	c.Assert(cp.RecordSetID, Equals, "")
+	c.Assert(cp.setRecordSet("57a0f5c1e4b0a1b2c3d4e5f6"), IsNil)
+	c.Assert(cp.markUploaded("bundle.json#entry[0]", "http://localhost/Patient/1"), IsNil)
+	c.Assert(cp.close(), IsNil)
+
+	// an upload interrupted while writing a line
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	c.Assert(err, IsNil)
+	f.WriteString(`{"source": "bundle.json#entry[1]", "loca`)
+	f.Close()
+
+	cp, err = openCheckpoint(path)
+	c.Assert(err, IsNil)
+	c.Assert(cp.RecordSetID, Equals, "57a0f5c1e4b0a1b2c3d4e5f6")
+	c.Assert(cp.Uploaded, DeepEquals, map[string]string{"bundle.json#entry[0]": "http://localhost/Patient/1"})
+	c.Assert(cp.markUploaded("bundle.json#entry[2]", "http://localhost/Patient/3"), IsNil)
+	c.Assert(cp.close(), IsNil)
+
+	cp, err = openCheckpoint(path)
+	c.Assert(err, IsNil)
+	defer cp.close()
+	c.Assert(cp.Uploaded, DeepEquals, map[string]string{"bundle.json#entry[0]": "http://localhost/Patient/1",
+		"bundle.json#entry[2]": "http://localhost/Patient/3"})
+}
+
+func (s *UploaderSuite) TestCheckpointWithoutFile(c *C) {
+	cp, err := openCheckpoint("")
+	c.Assert(err, IsNil)
+	c.Assert(cp.setRecordSet("57a0f5c1e4b0a1b2c3d4e5f6"), IsNil)
+	c.Assert(cp.markUploaded("bundle.json#entry[0]", "http://localhost/Patient/1"), IsNil)
+	c.Assert(cp.Uploaded, HasLen, 0)
+	c.Assert(cp.close(), IsNil)
+}
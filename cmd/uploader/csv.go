@@ -0,0 +1,296 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// csvMapping describes how the columns of a CSV file are converted to the
+// elements of a FHIR Patient. Columns are identified by their header name.
+// An example mapping file:
+//
+//	{
+//	  "identifier": [{"column": "rec_id", "system": "urn:example:mrn"}],
+//	  "family": "surname",
+//	  "given": ["given_name", "middle_name"],
+//	  "birthDate": "date_of_birth",
+//	  "birthDateFormat": "20060102",
+//	  "gender": "sex",
+//	  "address": {"line": ["street_number", "address_1"], "city": "suburb",
+//	              "state": "state", "postalCode": "postcode"},
+//	  "phone": ["phone_number"],
+//	  "cluster": "cluster_id"
+//	}
+//
+// When the cluster column holds a record identifier from which the cluster
+// can be derived (e.g., rec-12-dup-0 in FEBRL data), clusterPattern gives a
+// regular expression whose first group extracts the cluster identifier.
+type csvMapping struct {
+	Identifier []struct {
+		Column string `json:"column"`
+		System string `json:"system"`
+	} `json:"identifier"`
+	Family          string   `json:"family"`
+	Given           []string `json:"given"`
+	BirthDate       string   `json:"birthDate"`
+	BirthDateFormat string   `json:"birthDateFormat"`
+	Gender          string   `json:"gender"`
+	// maps values found in the gender column, in any case, to FHIR gender
+	// codes
+	GenderValues map[string]string `json:"genderValues"`
+	Address      struct {
+		Line       []string `json:"line"`
+		City       string   `json:"city"`
+		State      string   `json:"state"`
+		PostalCode string   `json:"postalCode"`
+		Country    string   `json:"country"`
+	} `json:"address"`
+	Phone []string `json:"phone"`
+	Email []string `json:"email"`
+	// column holding the identifier of the cluster of records that refer to
+	// the same person; used to build the answer key
+	Cluster        string `json:"cluster"`
+	ClusterPattern string `json:"clusterPattern"`
+	// field delimiter; defaults to a comma
+	Delimiter string `json:"delimiter"`
+
+	clusterRegexp *regexp.Regexp
+	// maps values of the cluster column to clusters, when the matches are
+	// given as pairs of record identifiers
+	clusterOf map[string]string
+}
+
+var defaultGenderValues = map[string]string{
+	"m": "male", "male": "male", "f": "female", "female": "female",
+	"o": "other", "other": "other", "u": "unknown", "unknown": "unknown"}
+
+func loadCSVMapping(path string) (*csvMapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseCSVMapping(f)
+}
+
+func parseCSVMapping(r io.Reader) (*csvMapping, error) {
+	mapping := &csvMapping{}
+	if err := json.NewDecoder(r).Decode(mapping); err != nil {
+		return nil, fmt.Errorf("Couldn't parse the mapping file: %s", err.Error())
+	}
+	if mapping.BirthDateFormat == "" {
+		mapping.BirthDateFormat = "2006-01-02"
+	}
+	if mapping.GenderValues == nil {
+		mapping.GenderValues = defaultGenderValues
+	} else {
+		// values of the gender column are compared in lower case
+		genderValues := make(map[string]string)
+		for value, gender := range mapping.GenderValues {
+			genderValues[strings.ToLower(value)] = gender
+		}
+		mapping.GenderValues = genderValues
+	}
+	if mapping.ClusterPattern != "" {
+		var err error
+		if mapping.clusterRegexp, err = regexp.Compile(mapping.ClusterPattern); err != nil {
+			return nil, fmt.Errorf("Couldn't parse the cluster pattern: %s", err.Error())
+		}
+	}
+	return mapping, nil
+}
+
+// readCSVResources walks the directory tree rooted at path (or reads the
+// single file at path) and calls fn with a Patient built from each row of
+// every *.csv file. If the mapping names a cluster column, the cluster of
+// each row is recorded in clusters, keyed by the row's source.
+func readCSVResources(path string, mapping *csvMapping, clusters map[string]string, fn ptm_models.ResourceFunc) error {
+	return filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(filePath, ".csv") {
+			return nil
+		}
+		return readCSVFile(filePath, mapping, clusters, fn)
+	})
+}
+
+func readCSVFile(filePath string, mapping *csvMapping, clusters map[string]string, fn ptm_models.ResourceFunc) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("Couldn't read the CSV file: %s", err.Error())
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	if mapping.Delimiter != "" {
+		reader.Comma = []rune(mapping.Delimiter)[0]
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("Couldn't read the header of %s: %s", filePath, err.Error())
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	for lineNum := 2; ; lineNum++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Couldn't parse line %d of %s: %s", lineNum, filePath, err.Error())
+		}
+		row := csvRow{columns: columns, record: record}
+		source := fmt.Sprintf("%s:%d", filePath, lineNum)
+
+		if mapping.Cluster != "" {
+			if cluster := mapping.cluster(row); cluster != "" {
+				clusters[source] = cluster
+			}
+		}
+
+		resource, err := toResourceMap(mapping.patient(row))
+		if err != nil {
+			return err
+		}
+		if err = fn(source, resource); err != nil {
+			return err
+		}
+	}
+}
+
+type csvRow struct {
+	columns map[string]int
+	record  []string
+}
+
+// get returns the trimmed value of the named column, or an empty string if
+// the column is absent.
+func (r csvRow) get(column string) string {
+	i, ok := r.columns[column]
+	if !ok || i >= len(r.record) {
+		return ""
+	}
+	return strings.TrimSpace(r.record[i])
+}
+
+func (r csvRow) getAll(columns []string) []string {
+	var values []string
+	for _, column := range columns {
+		if v := r.get(column); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// cluster returns the identifier of the cluster of records to which the row
+// belongs.
+func (m *csvMapping) cluster(row csvRow) string {
+	value := row.get(m.Cluster)
+	if m.clusterRegexp != nil {
+		if match := m.clusterRegexp.FindStringSubmatch(value); len(match) > 1 {
+			value = match[1]
+		}
+	}
+	if cluster, ok := m.clusterOf[value]; ok {
+		return cluster
+	}
+	return value
+}
+
+// patient builds a FHIR Patient from a CSV row.
+func (m *csvMapping) patient(row csvRow) *fhir_models.Patient {
+	patient := &fhir_models.Patient{}
+
+	for _, id := range m.Identifier {
+		if v := row.get(id.Column); v != "" {
+			patient.Identifier = append(patient.Identifier,
+				fhir_models.Identifier{System: id.System, Value: v})
+		}
+	}
+
+	name := fhir_models.HumanName{Use: "official", Given: row.getAll(m.Given)}
+	if family := row.get(m.Family); family != "" {
+		name.Family = []string{family}
+	}
+	if len(name.Family) > 0 || len(name.Given) > 0 {
+		patient.Name = []fhir_models.HumanName{name}
+	}
+
+	if v := row.get(m.BirthDate); v != "" {
+		if birth, err := time.Parse(m.BirthDateFormat, v); err == nil {
+			patient.BirthDate = &fhir_models.FHIRDateTime{Time: birth, Precision: fhir_models.Date}
+		}
+	}
+
+	if v := strings.ToLower(row.get(m.Gender)); v != "" {
+		if gender, ok := m.GenderValues[v]; ok {
+			patient.Gender = gender
+		} else {
+			patient.Gender = "unknown"
+		}
+	}
+
+	addr := fhir_models.Address{Use: "home", Line: row.getAll(m.Address.Line),
+		City: row.get(m.Address.City), State: row.get(m.Address.State),
+		PostalCode: row.get(m.Address.PostalCode), Country: row.get(m.Address.Country)}
+	if len(addr.Line) > 0 || addr.City != "" || addr.State != "" || addr.PostalCode != "" || addr.Country != "" {
+		patient.Address = []fhir_models.Address{addr}
+	}
+
+	for _, phone := range row.getAll(m.Phone) {
+		patient.Telecom = append(patient.Telecom, fhir_models.ContactPoint{System: "phone", Value: phone})
+	}
+	for _, email := range row.getAll(m.Email) {
+		patient.Telecom = append(patient.Telecom, fhir_models.ContactPoint{System: "email", Value: email})
+	}
+
+	return patient
+}
+
+// toResourceMap converts a FHIR resource to the generic representation used
+// by the uploader.
+func toResourceMap(resource interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	. "gopkg.in/check.v1"
+)
+
+const peopleMapping = `{
+  "identifier": [{"column": "id", "system": "urn:test:id"}],
+  "family": "last",
+  "given": ["first", "middle"],
+  "birthDate": "dob",
+  "gender": "sex",
+  "address": {"line": ["street"], "city": "city", "state": "state", "postalCode": "zip"},
+  "phone": ["phone"],
+  "email": ["email"],
+  "cluster": "cluster"
+}`
+
+func mustParseCSVMapping(c *C, mapping string) *csvMapping {
+	m, err := parseCSVMapping(strings.NewReader(mapping))
+	c.Assert(err, IsNil)
+	return m
+}
+
+func (s *UploaderSuite) TestParseCSVMapping(c *C) {
+	m := mustParseCSVMapping(c, `{"family": "last"}`)
+	c.Assert(m.BirthDateFormat, Equals, "2006-01-02")
+	c.Assert(m.GenderValues, DeepEquals, defaultGenderValues)
+
+	m = mustParseCSVMapping(c, `{"gender": "sex", "genderValues": {"1": "male", "Female": "female", "W": "female"}}`)
+	c.Assert(m.GenderValues, DeepEquals, map[string]string{"1": "male", "female": "female", "w": "female"})
+
+	_, err := parseCSVMapping(strings.NewReader(`{"family": `))
+	c.Assert(err, NotNil)
+	_, err = parseCSVMapping(strings.NewReader(`{"cluster": "id", "clusterPattern": "rec-("}`))
+	c.Assert(err, NotNil)
+}
+
+func (s *UploaderSuite) TestPatient(c *C) {
+	columns := map[string]int{"id": 0, "last": 1, "first": 2, "middle": 3, "dob": 4, "sex": 5,
+		"street": 6, "city": 7, "state": 8, "zip": 9, "phone": 10, "email": 11}
+	m := mustParseCSVMapping(c, peopleMapping)
+	custom := mustParseCSVMapping(c, `{"gender": "sex", "genderValues": {"Man": "male", "Woman": "female"}}`)
+
+	for _, expected := range []struct {
+		mapping *csvMapping
+		record  []string
+		patient *fhir_models.Patient
+	}{
+		{m, []string{"1", "Smith", "John", "Q", "1980-03-07", "M", "1 Main St", "Bedford", "MA", "01730",
+			"555-1234", "john@example.com"},
+			&fhir_models.Patient{
+				Identifier: []fhir_models.Identifier{{System: "urn:test:id", Value: "1"}},
+				Name:       []fhir_models.HumanName{{Use: "official", Family: []string{"Smith"}, Given: []string{"John", "Q"}}},
+				BirthDate: &fhir_models.FHIRDateTime{Time: time.Date(1980, 3, 7, 0, 0, 0, 0, time.UTC),
+					Precision: fhir_models.Date},
+				Gender: "male",
+				Address: []fhir_models.Address{{Use: "home", Line: []string{"1 Main St"}, City: "Bedford",
+					State: "MA", PostalCode: "01730"}},
+				Telecom: []fhir_models.ContactPoint{{System: "phone", Value: "555-1234"},
+					{System: "email", Value: "john@example.com"}},
+			}},
+		// empty columns are left out and values that can't be converted
+		// aren't guessed at
+		{m, []string{"2", " Jones ", "", "", "03/07/1980", "X"},
+			&fhir_models.Patient{
+				Identifier: []fhir_models.Identifier{{System: "urn:test:id", Value: "2"}},
+				Name:       []fhir_models.HumanName{{Use: "official", Family: []string{"Jones"}}},
+				Gender:     "unknown",
+			}},
+		{m, []string{"", "", "", "", "", ""}, &fhir_models.Patient{}},
+		// gender values are matched in any case
+		{custom, []string{"", "", "", "", "", "WOMAN"}, &fhir_models.Patient{Gender: "female"}},
+		{custom, []string{"", "", "", "", "", "man"}, &fhir_models.Patient{Gender: "male"}},
+		{custom, []string{"", "", "", "", "", "M"}, &fhir_models.Patient{Gender: "unknown"}},
+	} {
+		patient := expected.mapping.patient(csvRow{columns: columns, record: expected.record})
+		c.Assert(patient, DeepEquals, expected.patient, Commentf("%v", expected.record))
+	}
+}
+
+func (s *UploaderSuite) TestReadCSVResources(c *C) {
+	m := mustParseCSVMapping(c, peopleMapping)
+	clusters := make(map[string]string)
+	var sources, families, genders []string
+	err := readCSVResources(uploaderFixtures+"csv", m, clusters, func(source string, resource map[string]interface{}) error {
+		c.Assert(resource["resourceType"], Equals, "Patient")
+		sources = append(sources, source[len(uploaderFixtures):])
+		family := ""
+		if names, ok := resource["name"].([]interface{}); ok {
+			family = names[0].(map[string]interface{})["family"].([]interface{})[0].(string)
+		}
+		families = append(families, family)
+		gender, _ := resource["gender"].(string)
+		genders = append(genders, gender)
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	// files in nested directories are read first, since they're walked in
+	// lexical order
+	c.Assert(sources, DeepEquals, []string{"csv/more/others.csv:2", "csv/more/others.csv:3",
+		"csv/people.csv:2", "csv/people.csv:3", "csv/people.csv:4"})
+	c.Assert(families, DeepEquals, []string{"Brown", "", "Smith", "Smyth", "Jones"})
+	c.Assert(genders, DeepEquals, []string{"male", "", "male", "female", "unknown"})
+
+	// a row without a cluster isn't in any
+	expectedClusters := make(map[string]string)
+	for source, cluster := range map[string]string{"csv/more/others.csv:2": "B",
+		"csv/people.csv:2": "A", "csv/people.csv:3": "A", "csv/people.csv:4": "B"} {
+		expectedClusters[uploaderFixtures+source] = cluster
+	}
+	c.Assert(clusters, DeepEquals, expectedClusters)
+}
+
+func (s *UploaderSuite) TestReadCSVFile(c *C) {
+	m := mustParseCSVMapping(c, `{"family": "last", "delimiter": ";"}`)
+	var sources []string
+	err := readCSVFile(uploaderFixtures+"csv/more/others.csv", m, make(map[string]string),
+		func(source string, resource map[string]interface{}) error {
+			sources = append(sources, source)
+			return nil
+		})
+	c.Assert(err, IsNil)
+	// with the wrong delimiter there's no column named last, but each row is
+	// still read
+	c.Assert(sources, HasLen, 2)
+
+	err = readCSVFile(uploaderFixtures+"csv/missing.csv", m, make(map[string]string),
+		func(source string, resource map[string]interface{}) error { return nil })
+	c.Assert(err, NotNil)
+}
@@ -1,95 +1,217 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"os"
+	"sort"
 	"strings"
+	"time"
 
-	fhir_models "github.com/intervention-engine/fhir/models"
+	"github.com/mitre/ptmatch/client"
 	ptm_models "github.com/mitre/ptmatch/models"
 )
 
-const tagURL = "http://mitre.org/ptmatch/recordSet"
-
 // This application will create a new record set in the patient matching test
 // harness. This record set will be associated with a FHIR resource tag.
-// It will go through a directory of patient resources, in JSON format, read
-// them in, apply the tag, and then upload them to the FHIR server.
+// It will go through a directory tree of FHIR resources, in JSON format, read
+// them in, apply the tag, and then upload them to the FHIR server. Files may
+// hold a single resource or a Bundle (e.g., Synthea output), and FHIR bulk
+// data NDJSON files are also read. Only resources of the record set's
+// resource type are uploaded.
+//
+// In CSV mode, each row of the *.csv files found is converted to a FHIR
+// Patient using a column mapping file. If the mapping names a cluster column,
+// rows with the same cluster identifier are known matches and an answer key
+// is created for the record set. Matches can instead be given as a CSV file of
+// pairs of values of the cluster column (e.g., record identifiers).
+//
+// Benchmark datasets in the FEBRL and ONC Patient Matching Algorithm Challenge
+// formats are converted using built-in mappings, selected with -format.
+//
+// Resources are uploaded concurrently and failed uploads are retried. When a
+// checkpoint file is given, an interrupted upload can be resumed by running
+// the application again with the same checkpoint file.
 func main() {
 	fhirURL := flag.String("fhirURL", "", "URL for the patient matching test harness server")
 	recordSetName := flag.String("name", "", "Name of the record set")
 	path := flag.String("path", "", "Path to the JSON files")
+	resourceType := flag.String("resourceType", "Patient", "Type of resource in the record set (e.g., Patient, Practitioner, Organization)")
+	workers := flag.Int("workers", 4, "Number of concurrent uploads")
+	retries := flag.Int("retries", 3, "Number of times a failed upload is retried")
+	backoff := flag.Duration("backoff", 500*time.Millisecond, "Delay before the first retry; doubled for each further retry")
+	checkpointPath := flag.String("checkpoint", "", "Path to a checkpoint file used to resume an interrupted upload")
+	reportPath := flag.String("report", "", "Path to which a JSON report of the upload is written")
+	csvMode := flag.Bool("csv", false, "Convert rows of CSV files to Patients")
+	mappingPath := flag.String("mapping", "", "Path to the column mapping file used in CSV mode")
+	format := flag.String("format", "", "Benchmark dataset format of the CSV files (febrl or onc); implies CSV mode")
+	pairsPath := flag.String("pairs", "", "Path to a CSV file of pairs of matching record identifiers, as found in the mapping's cluster column (e.g., ONC enterprise IDs)")
 
 	flag.Parse()
 
-	argsToName := map[string]string{"fhirURL": *fhirURL, "name": *recordSetName, "path": *path}
+	argsToName := map[string]string{"fhirURL": *fhirURL, "name": *recordSetName, "path": *path, "resourceType": *resourceType}
 	for argName, argValue := range argsToName {
 		if argValue == "" {
 			fmt.Printf("You must provide an argument for %s\n", argName)
-			return
+			os.Exit(1)
 		}
 	}
+	if *workers < 1 {
+		*workers = 1
+	}
 
-	trimmedFhirURL := strings.TrimRight(*fhirURL, "/")
+	var mapping *csvMapping
+	if *format != "" {
+		*csvMode = true
+	}
+	if *csvMode {
+		if (*mappingPath == "") == (*format == "") || *resourceType != "Patient" {
+			fmt.Println("CSV mode requires either a mapping file or a format, and a resource type of Patient")
+			os.Exit(1)
+		}
+		var err error
+		if *format != "" {
+			mapping, err = benchmarkMapping(*format)
+		} else {
+			mapping, err = loadCSVMapping(*mappingPath)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
 
-	recordSet := &ptm_models.RecordSet{Name: *recordSetName}
+		if *pairsPath != "" {
+			if mapping.Cluster == "" {
+				fmt.Println("The mapping must name a cluster column holding the identifiers used in the pairs file")
+				os.Exit(1)
+			}
+			if mapping.clusterOf, err = loadMatchPairs(*pairsPath); err != nil {
+				fmt.Printf("Couldn't read the pairs file: %s\n", err.Error())
+				os.Exit(1)
+			}
+		} else if strings.ToLower(*format) == "onc" {
+			// the enterprise IDs don't identify any matches by themselves
+			fmt.Println("No pairs file was given; the record set will not have an answer key")
+			mapping.Cluster = ""
+		}
+	}
 
-	recordSet.ResourceType = "Patient"
-	recordSet.Parameters = generateRecordSetParameters(trimmedFhirURL, *recordSetName)
-	rsj, _ := json.Marshal(recordSet)
-	body := bytes.NewReader(rsj)
+	cp, err := openCheckpoint(*checkpointPath)
+	if err != nil {
+		fmt.Printf("Couldn't read the checkpoint file: %s\n", err.Error())
+		os.Exit(1)
+	}
+	defer cp.close()
 
-	recordSetURL := trimmedFhirURL + "/RecordSet"
-	http.Post(recordSetURL, "application/json", body)
+	harness := client.New(*fhirURL)
 
-	files, err := ioutil.ReadDir(*path)
-	if err != nil {
-		fmt.Printf("Couldn't read the directory: %s\n", err.Error())
-		return
+	var recordSet *ptm_models.RecordSet
+	if cp.RecordSetID != "" {
+		recordSet, err = harness.GetRecordSet(cp.RecordSetID)
+		if err != nil {
+			fmt.Printf("Couldn't retrieve the record set to resume: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Resuming upload to record set %s; %d resources already uploaded\n",
+			cp.RecordSetID, len(cp.Uploaded))
+	} else {
+		recordSet = ptm_models.NewTaggedRecordSet(harness.BaseURL, *recordSetName, *resourceType)
+		recordSet, err = harness.CreateRecordSet(recordSet)
+		if err != nil {
+			fmt.Printf("Couldn't create the record set: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if err = cp.setRecordSet(recordSet.ID.Hex()); err != nil {
+			fmt.Printf("Couldn't update the checkpoint file: %s\n", err.Error())
+			os.Exit(1)
+		}
 	}
-	for _, file := range files {
-		if strings.HasSuffix(file.Name(), ".json") {
-			jsonBlob, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", *path, file.Name()))
-			patient := &fhir_models.Patient{}
-			if err != nil {
-				fmt.Printf("Couldn't read the JSON file: %s\n", err.Error())
-				return
-			}
-			json.Unmarshal(jsonBlob, patient)
-			tagCoding := fhir_models.Coding{System: tagURL, Code: tagValue(*recordSetName)}
-			meta := &fhir_models.Meta{Tag: []fhir_models.Coding{tagCoding}}
-			patient.Meta = meta
-			pj, _ := json.Marshal(patient)
-			pb := bytes.NewReader(pj)
-			patientURL := trimmedFhirURL + "/Patient"
-			resp, err := http.Post(patientURL, "application/json", pb)
-			if err != nil {
-				fmt.Printf("Couldn't upload patient: %s\n", err.Error())
-				return
-			}
-			if resp.StatusCode != http.StatusCreated {
-				fmt.Sprintf("Unexpected status code when creating a patient: %d\n", resp.StatusCode)
-				return
+	tag := recordSet.TagCoding()
+
+	report := &uploadReport{RecordSetID: recordSet.ID.Hex()}
+	u := newUploader(harness, *resourceType, *workers, *retries, *backoff, cp)
+
+	done := make(chan bool)
+	go func() {
+		for result := range u.results {
+			if result.Error != "" {
+				fmt.Printf("Couldn't upload %s from %s: %s\n", *resourceType, result.Source, result.Error)
+				report.Failed = append(report.Failed, result)
+			} else {
+				report.Created = append(report.Created, result)
 			}
 		}
+		done <- true
+	}()
+
+	enqueue := enqueueFunc(cp, tag, report, u.jobs)
+
+	clusters := make(map[string]string)
+	if *csvMode {
+		err = readCSVResources(*path, mapping, clusters, enqueue)
+	} else {
+		report.OtherTypes, err = readResources(*path, *resourceType, enqueue)
+	}
+	u.close()
+	<-done
+
+	if err != nil {
+		fmt.Printf("Reading stopped early, so some resources were not uploaded: %s\n", err.Error())
+	}
+
+	fmt.Printf("Record set %s: %d created, %d failed, %d skipped (already uploaded), %d resources of other types ignored\n",
+		report.RecordSetID, len(report.Created), len(report.Failed), len(report.Skipped), report.OtherTypes)
+
+	if len(clusters) > 0 {
+		if err != nil || len(report.Failed) > 0 {
+			fmt.Println("The answer key was not created because some records were not uploaded")
+		} else if aerr := setClusterAnswerKey(harness, recordSet, clusters, report); aerr != nil {
+			fmt.Printf("Couldn't set the answer key: %s\n", aerr.Error())
+			err = aerr
+		}
 	}
-}
 
-func tagValue(recordSetName string) string {
-	return strings.Replace(recordSetName, " ", "", -1)
+	if *reportPath != "" {
+		if rerr := writeReport(*reportPath, report); rerr != nil {
+			fmt.Printf("Couldn't write the report: %s\n", rerr.Error())
+		}
+	}
+
+	// a failure to read or upload any resource is an error, even though the
+	// resources that were read have been uploaded
+	if err != nil || len(report.Failed) > 0 {
+		cp.close()
+		os.Exit(1)
+	}
 }
 
-func generateRecordSetParameters(fhirURL, recordSetName string) *fhir_models.Parameters {
-	parameters := fhir_models.Parameters{}
+// setClusterAnswerKey creates the answer key of the record set from the
+// cluster identifiers of the uploaded records: every pair of records in the
+// same cluster is a match.
+func setClusterAnswerKey(harness *client.Client, recordSet *ptm_models.RecordSet,
+	clusters map[string]string, report *uploadReport) error {
+	members := make(map[string][]string)
+	for _, results := range [][]uploadResult{report.Created, report.Skipped} {
+		for _, result := range results {
+			if cluster, ok := clusters[result.Source]; ok {
+				members[cluster] = append(members[cluster], result.Location)
+			}
+		}
+	}
+
+	var pairs []ptm_models.RecordPair
+	for _, locations := range members {
+		for a := 0; a < len(locations); a++ {
+			for b := a + 1; b < len(locations); b++ {
+				pairs = append(pairs, ptm_models.NewRecordPair(locations[a], locations[b]))
+			}
+		}
+	}
+	sort.Sort(ptm_models.RecordPairSlice(pairs))
 
-	resourceParam := fhirURL + "/Patient"
-	urlPcc := fhir_models.ParametersParameterComponent{Name: "resourceUrl", ValueString: resourceParam}
-	tagParam := tagValue(recordSetName)
-	tagPcc := fhir_models.ParametersParameterComponent{Name: "_tag", ValueString: tagParam}
-	parameters.Parameter = []fhir_models.ParametersParameterComponent{urlPcc, tagPcc}
-	return &parameters
+	if err := harness.SetAnswerKey(recordSet, ptm_models.NewAnswerKey(recordSet, pairs)); err != nil {
+		return err
+	}
+	fmt.Printf("Answer key created with %d matching pairs\n", len(pairs))
+	return nil
 }
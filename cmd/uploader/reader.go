@@ -0,0 +1,72 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// readResources walks the directory tree rooted at path and calls fn for
+// every resource of the given type. Resources are read from FHIR JSON files
+// (*.json), which may hold a single resource or a Bundle, and from FHIR bulk
+// data files (*.ndjson) with one resource per line. Resources of other types
+// are skipped and counted.
+func readResources(path, resourceType string, fn ptm_models.ResourceFunc) (int, error) {
+	skipped := 0
+	err := filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		var n int
+		switch {
+		case strings.HasSuffix(filePath, ".json"):
+			n, err = readJSONFile(filePath, resourceType, fn)
+		case strings.HasSuffix(filePath, ".ndjson"):
+			n, err = readNDJSONFile(filePath, resourceType, fn)
+		}
+		skipped += n
+		return err
+	})
+	return skipped, err
+}
+
+func readJSONFile(filePath, resourceType string, fn ptm_models.ResourceFunc) (int, error) {
+	jsonBlob, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("Couldn't read the JSON file: %s", err.Error())
+	}
+	return ptm_models.ReadJSONResources(filePath, jsonBlob, resourceType, fn)
+}
+
+func readNDJSONFile(filePath, resourceType string, fn ptm_models.ResourceFunc) (int, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("Couldn't read the NDJSON file: %s", err.Error())
+	}
+	defer f.Close()
+	return ptm_models.ReadNDJSONResources(filePath, f, resourceType, fn)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	ptm_models "github.com/mitre/ptmatch/models"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type UploaderSuite struct {
+}
+
+var _ = Suite(&UploaderSuite{})
+
+const uploaderFixtures = "../../fixtures/uploader/"
+
+func (s *UploaderSuite) TestReadResources(c *C) {
+	for _, expected := range []struct {
+		path, resourceType string
+		sources            []string
+		skipped            int
+	}{
+		{"json/bundle.json", "Patient", []string{"json/bundle.json#entry[0]", "json/bundle.json#entry[2]"}, 1},
+		{"json/patient.json", "Patient", []string{"json/patient.json"}, 0},
+		// blank lines are counted so the source names the line in the file
+		{"json/nested/deeper/bulk.ndjson", "Patient",
+			[]string{"json/nested/deeper/bulk.ndjson:1", "json/nested/deeper/bulk.ndjson:4"}, 1},
+		// nested directories are read and files other than JSON and NDJSON
+		// are ignored
+		{"json", "Patient", []string{"json/bundle.json#entry[0]", "json/bundle.json#entry[2]",
+			"json/nested/deeper/bulk.ndjson:1", "json/nested/deeper/bulk.ndjson:4", "json/patient.json"}, 2},
+		{"json", "Organization", []string{"json/nested/deeper/bulk.ndjson:3"}, 6},
+		{"json", "Practitioner", nil, 7},
+	} {
+		var sources []string
+		skipped, err := readResources(uploaderFixtures+expected.path, expected.resourceType,
+			func(source string, resource map[string]interface{}) error {
+				c.Assert(resource["resourceType"], Equals, expected.resourceType)
+				sources = append(sources, source[len(uploaderFixtures):])
+				return nil
+			})
+		c.Assert(err, IsNil)
+		c.Assert(sources, DeepEquals, expected.sources, Commentf("%s", expected.path))
+		c.Assert(skipped, Equals, expected.skipped, Commentf("%s", expected.path))
+	}
+}
+
+func (s *UploaderSuite) TestReadResourcesError(c *C) {
+	var sources []string
+	_, err := readResources(uploaderFixtures+"broken", "Patient",
+		func(source string, resource map[string]interface{}) error {
+			sources = append(sources, source)
+			return nil
+		})
+	c.Assert(err, NotNil)
+	c.Assert(sources, HasLen, 0)
+
+	_, err = readResources(uploaderFixtures+"missing", "Patient",
+		func(source string, resource map[string]interface{}) error { return nil })
+	c.Assert(err, NotNil)
+}
+
+func (s *UploaderSuite) TestEnqueueTagsResources(c *C) {
+	tag := fhir_models.Coding{System: ptm_models.RecordSetTagSystem, Code: "test"}
+	cp := &checkpoint{Uploaded: map[string]string{
+		uploaderFixtures + "json/bundle.json#entry[2]": "http://localhost/Patient/b1"}}
+	report := &uploadReport{}
+	jobs := make(chan uploadJob, 10)
+
+	_, err := readResources(uploaderFixtures+"json", "Patient", enqueueFunc(cp, tag, report, jobs))
+	c.Assert(err, IsNil)
+	close(jobs)
+
+	// the resource uploaded before is skipped
+	c.Assert(report.Skipped, DeepEquals, []uploadResult{{Source: uploaderFixtures + "json/bundle.json#entry[2]",
+		ID: "b1", Location: "http://localhost/Patient/b1"}})
+
+	var ids []string
+	for job := range jobs {
+		ids = append(ids, job.resource["id"].(string))
+		meta := job.resource["meta"].(map[string]interface{})
+		// patient c is already tagged and isn't tagged again
+		c.Assert(meta["tag"], DeepEquals, []interface{}{
+			map[string]interface{}{"system": tag.System, "code": tag.Code}}, Commentf("%s", job.source))
+	}
+	c.Assert(ids, DeepEquals, []string{"a", "d", "e", "c"})
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	"github.com/mitre/ptmatch/client"
+	ptm_models "github.com/mitre/ptmatch/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// uploadResult describes the outcome of uploading one resource.
+type uploadResult struct {
+	Source   string `json:"source"`
+	ID       string `json:"id,omitempty"`
+	Location string `json:"location,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// uploadReport summarizes an upload.
+type uploadReport struct {
+	RecordSetID string         `json:"recordSetId"`
+	Created     []uploadResult `json:"created"`
+	Failed      []uploadResult `json:"failed"`
+	// resources uploaded by an earlier, interrupted run
+	Skipped []uploadResult `json:"skipped"`
+	// number of resources not of the record set's resource type
+	OtherTypes int `json:"otherTypes"`
+}
+
+type uploadJob struct {
+	source   string
+	resource map[string]interface{}
+}
+
+// enqueueFunc returns a ResourceFunc that tags each resource read and queues
+// it for upload. Resources that the checkpoint records as already uploaded
+// are reported as skipped instead.
+func enqueueFunc(cp *checkpoint, tag fhir_models.Coding, report *uploadReport, jobs chan<- uploadJob) ptm_models.ResourceFunc {
+	return func(source string, resource map[string]interface{}) error {
+		if location, ok := cp.Uploaded[source]; ok {
+			report.Skipped = append(report.Skipped, uploadResult{Source: source,
+				ID: idFromLocation(location), Location: location})
+			return nil
+		}
+		ptm_models.TagResource(resource, tag)
+		jobs <- uploadJob{source: source, resource: resource}
+		return nil
+	}
+}
+
+// uploader puts resources to the server using a pool of workers. Failed
+// requests that may succeed on a later attempt are retried with
+// exponential backoff. Each resource is given its identifier before the first
+// attempt, so a retried request whose earlier attempt reached the server
+// doesn't create a duplicate record.
+type uploader struct {
+	harness      *client.Client
+	resourceType string
+	retries      int
+	backoff      time.Duration
+	checkpoint   *checkpoint
+
+	jobs    chan uploadJob
+	results chan uploadResult
+	wg      sync.WaitGroup
+}
+
+func newUploader(harness *client.Client, resourceType string, workers, retries int,
+	backoff time.Duration, cp *checkpoint) *uploader {
+	u := &uploader{harness: harness, resourceType: resourceType, retries: retries,
+		backoff: backoff, checkpoint: cp,
+		jobs: make(chan uploadJob, workers), results: make(chan uploadResult, workers)}
+	for i := 0; i < workers; i++ {
+		u.wg.Add(1)
+		go u.work()
+	}
+	return u
+}
+
+func (u *uploader) work() {
+	defer u.wg.Done()
+	for job := range u.jobs {
+		u.results <- u.upload(job)
+	}
+}
+
+func (u *uploader) upload(job uploadJob) uploadResult {
+	result := uploadResult{Source: job.source}
+	wait := u.backoff
+	id := bson.NewObjectId().Hex()
+	for {
+		result.Attempts++
+		location, err := u.harness.PutResource(u.resourceType, id, job.resource)
+		if err == nil {
+			result.Location = location
+			result.ID = idFromLocation(location)
+			if err = u.checkpoint.markUploaded(job.source, location); err != nil {
+				fmt.Printf("Couldn't update the checkpoint file: %s\n", err.Error())
+			}
+			return result
+		}
+		if result.Attempts > u.retries || !client.IsTemporary(err) {
+			result.Error = err.Error()
+			return result
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// close waits for queued uploads to finish and then closes the results
+// channel.
+func (u *uploader) close() {
+	close(u.jobs)
+	u.wg.Wait()
+	close(u.results)
+}
+
+// idFromLocation returns the server-assigned identifier in a resource URL.
+func idFromLocation(location string) string {
+	return location[strings.LastIndex(location, "/")+1:]
+}
+
+func writeReport(path string, report *uploadReport) error {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
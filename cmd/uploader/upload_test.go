@@ -0,0 +1,177 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	"github.com/mitre/ptmatch/client"
+	ptm_models "github.com/mitre/ptmatch/models"
+	. "gopkg.in/check.v1"
+)
+
+// fhirServer accepts PUTs of resources, failing the first attempt to put each
+// resource with the given status code.
+type fhirServer struct {
+	*httptest.Server
+	firstStatus int
+
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+func newFHIRServer(firstStatus int) *fhirServer {
+	s := &fhirServer{firstStatus: firstStatus, attempts: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.attempts[r.URL.Path]++
+		first := s.attempts[r.URL.Path] == 1
+		s.mu.Unlock()
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if first && s.firstStatus != 0 {
+			w.WriteHeader(s.firstStatus)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	return s
+}
+
+func (s *fhirServer) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, attempts := range s.attempts {
+		n += attempts
+	}
+	return n
+}
+
+// runUpload uploads the Patients found in the fixture directory the way main
+// does.
+func runUpload(c *C, server *fhirServer, cp *checkpoint, retries int) *uploadReport {
+	report := &uploadReport{}
+	u := newUploader(client.New(server.URL), "Patient", 2, retries, time.Millisecond, cp)
+	done := make(chan bool)
+	go func() {
+		for result := range u.results {
+			if result.Error != "" {
+				report.Failed = append(report.Failed, result)
+			} else {
+				report.Created = append(report.Created, result)
+			}
+		}
+		done <- true
+	}()
+	tag := fhir_models.Coding{System: ptm_models.RecordSetTagSystem, Code: "test"}
+	_, err := readResources(uploaderFixtures+"json", "Patient", enqueueFunc(cp, tag, report, u.jobs))
+	u.close()
+	<-done
+	c.Assert(err, IsNil)
+	return report
+}
+
+func (s *UploaderSuite) TestRetryTemporaryFailure(c *C) {
+	server := newFHIRServer(http.StatusServiceUnavailable)
+	defer server.Close()
+	cp, _ := openCheckpoint("")
+
+	report := runUpload(c, server, cp, 1)
+	c.Assert(report.Failed, HasLen, 0)
+	c.Assert(report.Created, HasLen, 5)
+	for _, result := range report.Created {
+		c.Assert(result.Attempts, Equals, 2)
+		// the retry puts the resource to the same URL
+		c.Assert(strings.HasPrefix(result.Location, server.URL+"/Patient/"), Equals, true)
+		c.Assert(server.attempts["/Patient/"+result.ID], Equals, 2)
+	}
+}
+
+func (s *UploaderSuite) TestRetryLimit(c *C) {
+	server := newFHIRServer(http.StatusServiceUnavailable)
+	defer server.Close()
+	cp, _ := openCheckpoint("")
+
+	report := runUpload(c, server, cp, 0)
+	c.Assert(report.Created, HasLen, 0)
+	c.Assert(report.Failed, HasLen, 5)
+	c.Assert(server.requests(), Equals, 5)
+}
+
+func (s *UploaderSuite) TestNoRetryOfPermanentFailure(c *C) {
+	server := newFHIRServer(http.StatusBadRequest)
+	defer server.Close()
+	cp, _ := openCheckpoint("")
+
+	report := runUpload(c, server, cp, 3)
+	c.Assert(report.Created, HasLen, 0)
+	c.Assert(report.Failed, HasLen, 5)
+	for _, result := range report.Failed {
+		c.Assert(result.Attempts, Equals, 1)
+	}
+	c.Assert(server.requests(), Equals, 5)
+}
+
+func (s *UploaderSuite) TestResumeUpload(c *C) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "upload.checkpoint")
+
+	// the first run can't upload anything
+	server := newFHIRServer(http.StatusBadRequest)
+	cp, err := openCheckpoint(path)
+	c.Assert(err, IsNil)
+	report := runUpload(c, server, cp, 0)
+	c.Assert(report.Failed, HasLen, 5)
+	c.Assert(cp.close(), IsNil)
+	server.Close()
+
+	// the second run uploads everything
+	server = newFHIRServer(0)
+	cp, err = openCheckpoint(path)
+	c.Assert(err, IsNil)
+	c.Assert(cp.Uploaded, HasLen, 0)
+	report = runUpload(c, server, cp, 0)
+	c.Assert(report.Created, HasLen, 5)
+	c.Assert(cp.close(), IsNil)
+
+	// the third run finds nothing left to upload
+	cp, err = openCheckpoint(path)
+	c.Assert(err, IsNil)
+	c.Assert(cp.Uploaded, HasLen, 5)
+	report = runUpload(c, server, cp, 0)
+	c.Assert(cp.close(), IsNil)
+	server.Close()
+	c.Assert(report.Created, HasLen, 0)
+	c.Assert(report.Skipped, HasLen, 5)
+	c.Assert(server.requests(), Equals, 5)
+	for _, skipped := range report.Skipped {
+		c.Assert(cp.Uploaded[skipped.Source], Equals, skipped.Location)
+	}
+}
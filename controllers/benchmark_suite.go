@@ -0,0 +1,231 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	fhir_models "github.com/intervention-engine/fhir/models"
+
+	logger "github.com/mitre/ptmatch/logger"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// RunBenchmarkSuiteHandler creates a HandlerFunc that runs a BenchmarkSuite:
+// a RecordMatchRun is created and dispatched for every combination of record
+// matching system and dataset in the suite. Every system interface and record
+// set is checked, and every request constructed, before anything is written,
+// so a suite with a bad system interface or record set creates no runs. Each
+// record set is snapshotted once and the snapshot shared by its runs. If a
+// run can't be dispatched, the execution is still recorded, with the runs
+// dispatched so far and the error.
+func RunBenchmarkSuiteHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		suite, ok := loadBenchmarkSuite(ctx, db)
+		if !ok {
+			return
+		}
+
+		execution := ptm_models.BenchmarkExecution{ID: bson.NewObjectId(), StartedOn: time.Now().Round(time.Millisecond)}
+		runs := suite.NewRuns(execution.ID)
+		if len(runs) == 0 {
+			ctx.String(http.StatusBadRequest, "Benchmark Suite has no record matching systems or datasets")
+			ctx.Abort()
+			return
+		}
+
+		// load and check each system interface and record set once
+		sysIfaces := make(map[bson.ObjectId]*ptm_models.RecordMatchSystemInterface)
+		recSets := make(map[bson.ObjectId]*benchmarkRecordSet)
+		for _, run := range runs {
+			if !isValidRecordMatchRun(run) {
+				ctx.String(http.StatusBadRequest, "Benchmark Suite doesn't describe valid Record Match Runs")
+				ctx.Abort()
+				return
+			}
+			if _, ok := sysIfaces[run.RecordMatchSystemInterfaceID]; !ok {
+				sysIface, err := loadRecordMatchSysIface(db, run.RecordMatchSystemInterfaceID)
+				if err != nil {
+					abortRun(ctx, err)
+					return
+				}
+				sysIfaces[run.RecordMatchSystemInterfaceID] = sysIface
+			}
+			recSetIDs := []bson.ObjectId{run.MasterRecordSetID}
+			if run.MatchingMode == ptm_models.Query {
+				recSetIDs = append(recSetIDs, run.QueryRecordSetID)
+			}
+			for _, id := range recSetIDs {
+				if _, ok := recSets[id]; ok {
+					continue
+				}
+				recSet, members, err := loadValidRecordSet(db, id)
+				if err != nil {
+					abortRun(ctx, err)
+					return
+				}
+				recSets[id] = &benchmarkRecordSet{recSet: recSet, members: members}
+			}
+		}
+
+		for _, run := range runs {
+			if err := buildRecordMatchRequest(db, run, sysIfaces[run.RecordMatchSystemInterfaceID]); err != nil {
+				abortRun(ctx, err)
+				return
+			}
+		}
+
+		// snapshot each record set once, for all of the runs that use it
+		for _, rs := range recSets {
+			snapshot, err := snapshotRecordSet(db, rs.recSet, rs.members)
+			if err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+			rs.snapshotID = snapshot.ID
+		}
+
+		var dispatchErr error
+		for _, run := range runs {
+			run.MasterRecordSetSnapshotID = recSets[run.MasterRecordSetID].snapshotID
+			if run.MatchingMode == ptm_models.Query {
+				run.QueryRecordSetSnapshotID = recSets[run.QueryRecordSetID].snapshotID
+			}
+			if dispatchErr = storeAndQueueRecordMatchRun(db, run, sysIfaces[run.RecordMatchSystemInterfaceID]); dispatchErr != nil {
+				logger.Log.WithFields(
+					logrus.Fields{"method": "RunBenchmarkSuite", "suite": suite.ID,
+						"execution": execution.ID, "err": dispatchErr}).Warn("Unable to dispatch run")
+				execution.Error = fmt.Sprintf("Dispatched %d of %d runs: %s",
+					len(execution.RecordMatchRunIDs), len(runs), dispatchErr)
+				break
+			}
+			execution.RecordMatchRunIDs = append(execution.RecordMatchRunIDs, run.ID)
+		}
+
+		err := db.C(ptm_models.GetCollectionName("BenchmarkSuite")).UpdateId(suite.ID, bson.M{
+			"$push": bson.M{"executions": execution},
+			"$set":  bson.M{"meta.lastUpdatedOn": execution.StartedOn}})
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if dispatchErr != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, dispatchErr)
+			return
+		}
+
+		location := responseURL(ctx.Request, "BenchmarkSuite", suite.ID.Hex(), "$results")
+		location.RawQuery = "executionId=" + execution.ID.Hex()
+		ctx.Header("Location", location.String())
+		ctx.JSON(http.StatusCreated, execution)
+	}
+}
+
+// benchmarkRecordSet is a record set used by the runs of a benchmark
+// execution, with the members it selected and, once taken, its snapshot.
+type benchmarkRecordSet struct {
+	recSet     *ptm_models.RecordSet
+	members    []fhir_models.BundleEntryComponent
+	snapshotID bson.ObjectId
+}
+
+// GetBenchmarkResultsHandler creates a HandlerFunc that reports the progress
+// and results of an execution of a BenchmarkSuite as a matrix of record
+// matching systems and datasets. The most recent execution is reported unless
+// the executionId parameter names another.
+func GetBenchmarkResultsHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		suite, ok := loadBenchmarkSuite(ctx, db)
+		if !ok {
+			return
+		}
+		if len(suite.Executions) == 0 {
+			ctx.String(http.StatusNotFound, "Benchmark Suite hasn't been run")
+			ctx.Abort()
+			return
+		}
+
+		execution := &suite.Executions[len(suite.Executions)-1]
+		if executionID := ctx.Query("executionId"); executionID != "" {
+			execution = nil
+			for i := range suite.Executions {
+				if suite.Executions[i].ID.Hex() == executionID {
+					execution = &suite.Executions[i]
+				}
+			}
+			if execution == nil {
+				ctx.String(http.StatusNotFound, "Benchmark Suite execution not found")
+				ctx.Abort()
+				return
+			}
+		}
+
+		var runs []ptm_models.RecordMatchRun
+		err := db.C(ptm_models.GetCollectionName("RecordMatchRun")).
+			Find(bson.M{"_id": bson.M{"$in": execution.RecordMatchRunIDs}}).
+			Select(bson.M{"state": 1, "metrics": 1, "matchingMode": 1, "recordMatchSystemInterfaceId": 1,
+				"masterRecordSetId": 1, "queryRecordSetId": 1}).All(&runs)
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		var sysIfaces []ptm_models.RecordMatchSystemInterface
+		err = db.C(ptm_models.GetCollectionName("RecordMatchSystemInterface")).
+			Find(bson.M{"_id": bson.M{"$in": suite.RecordMatchSystemInterfaceIDs}}).
+			Select(bson.M{"name": 1}).All(&sysIfaces)
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		names := make(map[bson.ObjectId]string)
+		for _, sysIface := range sysIfaces {
+			names[sysIface.ID] = sysIface.Name
+		}
+
+		ctx.JSON(http.StatusOK, ptm_models.NewBenchmarkResults(suite, execution, runs, names))
+	}
+}
+
+// loadBenchmarkSuite loads the suite identified in the request path. If it
+// can't be loaded, an error response is written and false is returned.
+func loadBenchmarkSuite(ctx *gin.Context, db *mgo.Database) (*ptm_models.BenchmarkSuite, bool) {
+	id, err := toBsonObjectID(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return nil, false
+	}
+	obj, err := ptm_models.LoadResource(db, "BenchmarkSuite", id)
+	if err == mgo.ErrNotFound {
+		ctx.String(http.StatusNotFound, "Benchmark Suite not found")
+		ctx.Abort()
+		return nil, false
+	} else if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return obj.(*ptm_models.BenchmarkSuite), true
+}
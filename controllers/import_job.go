@@ -0,0 +1,232 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+
+	"github.com/mitre/ptmatch/client"
+	logger "github.com/mitre/ptmatch/logger"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// maxImportSize is the largest upload accepted by the import operation.
+const maxImportSize = 256 << 20
+
+// importProgressInterval is the number of records imported between updates
+// of the job's progress.
+const importProgressInterval = 100
+
+// importAttempts is the number of times the creation of a record is
+// attempted when the FHIR server reports a temporary error.
+const importAttempts = 3
+
+var errImportTooLarge = errors.New("The upload is too large to import")
+
+// importRecord is a resource read from an upload, waiting to be imported.
+type importRecord struct {
+	source   string
+	resource map[string]interface{}
+}
+
+// ImportRecordSetHandler creates a HandlerFunc that imports the records in an
+// uploaded Bundle, NDJSON file or zip of JSON files into a record set. The
+// upload may be the request body or a multipart form file named "file". The
+// records are tagged and created on the FHIR server by a background job,
+// whose progress is reported by the returned ImportJob.
+func ImportRecordSetHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		recSet, ok := loadRecordSet(ctx, db)
+		if !ok {
+			return
+		}
+		if recSet.Parameter("_tag") == "" {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("Records can only be imported into a tagged record set"))
+			return
+		}
+
+		source, data, err := readImportUpload(ctx)
+		if err != nil {
+			status := http.StatusBadRequest
+			if err == errImportTooLarge {
+				status = http.StatusRequestEntityTooLarge
+			}
+			ctx.AbortWithError(status, err)
+			return
+		}
+
+		// the upload is parsed before the job starts, so that malformed
+		// uploads are rejected without importing any records
+		var records []importRecord
+		skipped, err := ptm_models.ReadResources(source, data, recSet.ResourceType,
+			func(source string, resource map[string]interface{}) error {
+				records = append(records, importRecord{source: source, resource: resource})
+				return nil
+			})
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		job := &ptm_models.ImportJob{RecordSetID: recSet.ID, Status: ptm_models.ImportJobRunning,
+			Total: len(records), Skipped: skipped}
+		if _, err = ptm_models.PersistResource(db, "ImportJob", job); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		logger.Log.WithFields(
+			logrus.Fields{"method": "ImportRecordSet", "record set": recSet.ID, "job": job.ID,
+				"records": len(records), "skipped": skipped}).Info("Starting import job")
+
+		// the job outlives the request, so it uses its own session
+		go runImportJob(db.Session.Copy(), db.Name, job.ID, recSet, records)
+
+		ctx.Header("Location", responseURL(ctx.Request, "ImportJob", job.ID.Hex()).String())
+		ctx.JSON(http.StatusAccepted, job)
+	}
+}
+
+// readImportUpload returns the name and content of the uploaded file.
+func readImportUpload(ctx *gin.Context) (string, []byte, error) {
+	source := "upload"
+	var r io.Reader = ctx.Request.Body
+	if strings.HasPrefix(ctx.ContentType(), "multipart/form-data") {
+		file, header, err := ctx.Request.FormFile("file")
+		if err != nil {
+			return "", nil, err
+		}
+		defer file.Close()
+		source, r = header.Filename, file
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxImportSize+1))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(data) > maxImportSize {
+		return "", nil, errImportTooLarge
+	}
+	return source, data, nil
+}
+
+// runImportJob tags the records and creates them on the FHIR server holding
+// the record set, updating the progress and errors of the job as it goes.
+func runImportJob(session *mgo.Session, dbName string, jobID bson.ObjectId,
+	recSet *ptm_models.RecordSet, records []importRecord) {
+	defer session.Close()
+	c := session.DB(dbName).C(ptm_models.GetCollectionName("ImportJob"))
+
+	fhirClient := client.New(recSet.BaseURL())
+	tag := recSet.TagCoding()
+
+	var processed, created, failed int
+	var pending []ptm_models.ImportRecordError
+	update := func(fields bson.M) {
+		fields["processed"] = processed
+		fields["created"] = created
+		fields["failed"] = failed
+		fields["meta.lastUpdatedOn"] = time.Now().Round(time.Millisecond)
+		change := bson.M{"$set": fields}
+		if len(pending) > 0 {
+			change["$push"] = bson.M{"errors": bson.M{"$each": pending}}
+			pending = nil
+		}
+		if err := c.UpdateId(jobID, change); err != nil {
+			logger.Log.WithFields(
+				logrus.Fields{"method": "runImportJob", "job": jobID, "err": err}).Warn("Unable to update import job")
+		}
+	}
+
+	for _, record := range records {
+		ptm_models.TagResource(record.resource, tag)
+
+		// the id is assigned here, so a retry of a request that timed out
+		// after the record was created doesn't create it again
+		id := bson.NewObjectId().Hex()
+		var err error
+		for attempt := 1; attempt <= importAttempts; attempt++ {
+			_, err = fhirClient.PutResource(recSet.ResourceType, id, record.resource)
+			if err == nil || !client.IsTemporary(err) {
+				break
+			}
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+
+		processed++
+		if err != nil {
+			if failed < ptm_models.MaxImportJobErrors {
+				pending = append(pending, ptm_models.ImportRecordError{Source: record.source, Message: err.Error()})
+			}
+			failed++
+		} else {
+			created++
+		}
+		if processed%importProgressInterval == 0 {
+			update(bson.M{})
+		}
+	}
+
+	status, message := ptm_models.ImportJobCompleted, ""
+	if failed > 0 && created == 0 {
+		status, message = ptm_models.ImportJobFailed, "None of the records could be imported"
+	}
+	update(bson.M{"status": status, "message": message, "completedOn": time.Now().Round(time.Millisecond)})
+
+	logger.Log.WithFields(
+		logrus.Fields{"method": "runImportJob", "record set": recSet.ID, "job": jobID,
+			"created": created, "failed": failed}).Info("Import job finished")
+}
+
+// FailInterruptedImportJobs marks the import jobs that were still running
+// when the server last stopped as failed. A job runs in the server's process
+// and its records aren't kept, so it can't be resumed; the records that were
+// imported stay in the record set.
+func FailInterruptedImportJobs(provider func() *mgo.Database) {
+	db := provider()
+	if db == nil {
+		return
+	}
+	now := time.Now().Round(time.Millisecond)
+	info, err := db.C(ptm_models.GetCollectionName("ImportJob")).UpdateAll(
+		bson.M{"status": ptm_models.ImportJobRunning},
+		bson.M{"$set": bson.M{"status": ptm_models.ImportJobFailed,
+			"message":            "The import was interrupted by a restart of the server",
+			"completedOn":        now,
+			"meta.lastUpdatedOn": now}})
+	if err != nil {
+		logger.Log.WithFields(
+			logrus.Fields{"method": "FailInterruptedImportJobs", "err": err}).Warn("Unable to update interrupted import jobs")
+		return
+	}
+	if info.Updated > 0 {
+		logger.Log.WithFields(
+			logrus.Fields{"method": "FailInterruptedImportJobs", "jobs": info.Updated}).Info("Interrupted import jobs marked failed")
+	}
+}
@@ -0,0 +1,366 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	ptm_http "github.com/mitre/ptmatch/http"
+	logger "github.com/mitre/ptmatch/logger"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+const (
+	outboxPollInterval = time.Second
+	// how long a record matcher has to answer a delivery attempt
+	outboxDeliveryTimeout = 30 * time.Second
+	// how long a claimed message is left alone before it is presumed that
+	// the delivery attempt was abandoned (e.g., the server was restarted);
+	// longer than a delivery attempt can take
+	outboxLease = 2 * time.Minute
+)
+
+// outboxClient delivers outbox messages. Unlike ptm_http.HTTPClient, it gives
+// up on a record matcher that doesn't answer.
+var outboxClient = &http.Client{CheckRedirect: ptm_http.RedirectError, Timeout: outboxDeliveryTimeout}
+
+// outboxWake prompts the dispatcher to look for due messages without waiting
+// for the next poll.
+var outboxWake = make(chan struct{}, 1)
+
+func wakeOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// outboxBusy holds the hosts to which a message is being delivered. Messages
+// to a host are delivered one at a time, and messages to different hosts at
+// the same time, so a record matcher that is slow to answer holds up only its
+// own messages.
+var outboxBusy = struct {
+	sync.Mutex
+	hosts map[string]bool
+}{hosts: make(map[string]bool)}
+
+// reserveOutboxHost marks the host busy, reporting false if it already was.
+func reserveOutboxHost(host string) bool {
+	outboxBusy.Lock()
+	defer outboxBusy.Unlock()
+	if outboxBusy.hosts[host] {
+		return false
+	}
+	outboxBusy.hosts[host] = true
+	return true
+}
+
+func releaseOutboxHost(host string) {
+	outboxBusy.Lock()
+	delete(outboxBusy.hosts, host)
+	outboxBusy.Unlock()
+}
+
+// outboxHost returns the host of the endpoint, by which deliveries are
+// grouped.
+func outboxHost(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return endpoint
+}
+
+// StartOutboxDispatcher starts a background worker that delivers queued
+// OutboxMessages to record matching systems.
+func StartOutboxDispatcher(provider func() *mgo.Database) {
+	go func() {
+		ticker := time.NewTicker(outboxPollInterval)
+		defer ticker.Stop()
+		for {
+			dispatchDueMessages(provider)
+			select {
+			case <-ticker.C:
+			case <-outboxWake:
+			}
+		}
+	}()
+}
+
+// dispatchDueMessages starts the delivery of each message whose next attempt
+// is due, unless a message is being delivered to the same host. A message is
+// claimed before it is delivered, so that a message is never delivered by two
+// dispatchers at the same time.
+func dispatchDueMessages(provider func() *mgo.Database) {
+	db := provider()
+	if db == nil {
+		return
+	}
+	session := db.Session.Copy()
+	defer session.Close()
+	c := session.DB(db.Name).C(ptm_models.GetCollectionName("OutboxMessage"))
+
+	now := time.Now().Round(time.Millisecond)
+	var due []ptm_models.OutboxMessage
+	err := c.Find(bson.M{"status": ptm_models.OutboxPending, "nextAttemptOn": bson.M{"$lte": now}}).
+		Sort("nextAttemptOn").Select(bson.M{"endpoint": 1}).All(&due)
+	if err != nil {
+		logger.Log.WithFields(
+			logrus.Fields{"method": "dispatchDueMessages", "err": err}).Warn("Unable to find due outbox messages")
+		return
+	}
+
+	for _, m := range due {
+		host := outboxHost(m.Endpoint)
+		if !reserveOutboxHost(host) {
+			continue
+		}
+		msg := &ptm_models.OutboxMessage{}
+		_, err = c.Find(bson.M{"_id": m.ID, "status": ptm_models.OutboxPending, "nextAttemptOn": bson.M{"$lte": now}}).
+			Apply(mgo.Change{Update: bson.M{"$set": bson.M{"nextAttemptOn": now.Add(outboxLease)}}, ReturnNew: true}, msg)
+		if err != nil {
+			releaseOutboxHost(host)
+			if err != mgo.ErrNotFound {
+				logger.Log.WithFields(
+					logrus.Fields{"method": "dispatchDueMessages", "err": err}).Warn("Unable to claim outbox message")
+			}
+			continue
+		}
+
+		go func(s *mgo.Session, msg *ptm_models.OutboxMessage, host string) {
+			defer s.Close()
+			deliverOutboxMessage(s.DB(db.Name), msg)
+			releaseOutboxHost(host)
+			// the host may have more messages waiting
+			wakeOutbox()
+		}(session.Copy(), msg, host)
+	}
+}
+
+// deliverOutboxMessage makes one attempt to deliver the message and records
+// the outcome with both the message and its record match run.
+func deliverOutboxMessage(db *mgo.Database, msg *ptm_models.OutboxMessage) {
+	attempt := ptm_models.DeliveryAttempt{AttemptedOn: time.Now().Round(time.Millisecond)}
+	var result string
+
+	method := msg.Method
+	if method == "" {
+		method = http.MethodPut
+	}
+	var resp *http.Response
+	req, err := http.NewRequest(method, msg.Endpoint, strings.NewReader(msg.Body))
+	if err == nil {
+		req.Header.Set("Content-Type", msg.ContentType)
+		resp, err = outboxClient.Do(req)
+	}
+	if resp != nil {
+		resp.Body.Close()
+		attempt.StatusCode = resp.StatusCode
+		result = resp.Status
+	}
+	switch {
+	case resp != nil && resp.StatusCode == http.StatusFound:
+		// HACK In this configuration, the test harness only supports itself as the FHIR message broker
+		logger.Log.Debug("deliverOutboxMessage: redirect detected - Infer server is in Heart mode")
+		bundle := &fhir_models.Bundle{}
+		if err = json.Unmarshal([]byte(msg.Body), bundle); err == nil {
+			_, err = ptm_models.PersistFhirResource(db, "Bundle", bundle)
+		}
+		if err != nil {
+			attempt.Error = err.Error()
+		} else {
+			attempt.Delivered = true
+		}
+	case err != nil:
+		attempt.Error = err.Error()
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		attempt.Delivered = true
+	default:
+		attempt.Error = resp.Status
+	}
+	if attempt.Error != "" {
+		result = attempt.Error
+	}
+
+	msg.RecordAttempt(attempt, ptm_models.OutboxInitialBackoff, ptm_models.OutboxMaxBackoff)
+	n := len(msg.Attempts)
+
+	logger.Log.WithFields(
+		logrus.Fields{"method": "deliverOutboxMessage", "message": msg.ID, "run": msg.RecordMatchRunID,
+			"endpoint": msg.Endpoint, "attempt": n, "result": result, "status": msg.Status}).Info("Delivery attempt")
+
+	// the delivery may have been cancelled while the attempt was made, in
+	// which case the attempt is recorded but the message stays cancelled
+	c := db.C(ptm_models.GetCollectionName("OutboxMessage"))
+	err = c.Update(bson.M{"_id": msg.ID, "status": ptm_models.OutboxPending}, bson.M{
+		"$set": bson.M{"status": msg.Status, "nextAttemptOn": msg.NextAttemptOn,
+			"meta.lastUpdatedOn": attempt.AttemptedOn},
+		"$push": bson.M{"attempts": attempt}})
+	if err == mgo.ErrNotFound {
+		msg.Status = ptm_models.OutboxCancelled
+		err = c.UpdateId(msg.ID, bson.M{
+			"$set":  bson.M{"meta.lastUpdatedOn": attempt.AttemptedOn},
+			"$push": bson.M{"attempts": attempt}})
+	}
+	if err != nil {
+		logger.Log.WithFields(
+			logrus.Fields{"method": "deliverOutboxMessage", "message": msg.ID, "err": err}).Warn("Unable to update outbox message")
+	}
+
+	var status string
+	switch {
+	case attempt.Delivered && attempt.StatusCode == http.StatusFound:
+		status = "Redirect from Msg Broker Not Supported; " + msg.Description + " persisted in local database"
+	case attempt.Delivered:
+		status = fmt.Sprintf("%s Sent [%s] (attempt %d)", msg.Description, result, n)
+	case msg.Status == ptm_models.OutboxCancelled:
+		status = fmt.Sprintf("Error Sending %s to Record Matcher [%s] (attempt %d of %d); delivery cancelled",
+			msg.Description, result, n, msg.MaxAttempts)
+	case msg.Status == ptm_models.OutboxDead:
+		status = fmt.Sprintf("Error Sending %s to Record Matcher [%s] (attempt %d of %d); giving up",
+			msg.Description, result, n, msg.MaxAttempts)
+	default:
+		status = fmt.Sprintf("Error Sending %s to Record Matcher [%s] (attempt %d of %d); retrying at %s",
+			msg.Description, result, n, msg.MaxAttempts, msg.NextAttemptOn.Format(time.RFC3339))
+	}
+	// the outcome of delivering a request decides the state of the run
+	var state string
+	var set bson.M
+	if msg.Description == ptm_models.OutboxRequest {
+		if attempt.Delivered {
+			state, set = ptm_models.RunSent, bson.M{"request.submittedOn": attempt.AttemptedOn}
+			// the record matcher's time to respond starts now
+			run := &ptm_models.RecordMatchRun{}
+			err = db.C(ptm_models.GetCollectionName("RecordMatchRun")).FindId(msg.RecordMatchRunID).
+				Select(bson.M{"responseTimeout": 1}).One(run)
+			if err == nil && run.ResponseTimeout > 0 {
+				set["responseDeadline"] = ptm_models.ResponseDeadline(attempt.AttemptedOn, run.ResponseTimeout)
+			}
+		} else if msg.Status == ptm_models.OutboxDead {
+			state = ptm_models.RunFailed
+		}
+	}
+	if state != "" {
+		err = ptm_models.TransitionRun(db, msg.RecordMatchRunID, state, status, set)
+		if _, ok := err.(*ptm_models.RunTransitionError); ok {
+			// e.g., the run was cancelled while the request was in flight
+			logger.Log.WithFields(
+				logrus.Fields{"method": "deliverOutboxMessage", "run": msg.RecordMatchRunID, "err": err}).Info("Run state unchanged")
+			err = ptm_models.AddRunStatus(db, msg.RecordMatchRunID, status)
+		}
+	} else {
+		err = ptm_models.AddRunStatus(db, msg.RecordMatchRunID, status)
+	}
+	if err != nil {
+		logger.Log.WithFields(
+			logrus.Fields{"method": "deliverOutboxMessage", "run": msg.RecordMatchRunID, "err": err}).Warn("Unable to update run status")
+	}
+}
+
+// queueRecordMatchRequest adds the run's request message to the outbox, to be
+// delivered to the record matcher's server endpoint.
+func queueRecordMatchRequest(db *mgo.Database, recMatchRun *ptm_models.RecordMatchRun,
+	recMatchSysIface *ptm_models.RecordMatchSystemInterface) (*ptm_models.OutboxMessage, error) {
+	method, endpoint := prepDelivery(recMatchSysIface, recMatchRun.Request.Message.Id)
+	return queueMessage(db, recMatchRun.ID, ptm_models.OutboxRequest, method, endpoint, recMatchRun.Request.Message)
+}
+
+// queueMessage adds a message about the run to the outbox, to be delivered
+// to the endpoint with the HTTP method.
+func queueMessage(db *mgo.Database, runID bson.ObjectId, description, method, endpoint string,
+	message *fhir_models.Bundle) (*ptm_models.OutboxMessage, error) {
+	body, err := message.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	msg := ptm_models.NewOutboxMessage(runID, description, method, endpoint,
+		"application/json+fhir", body, ptm_models.OutboxMaxAttempts)
+	if _, err = ptm_models.PersistResource(db, "OutboxMessage", msg); err != nil {
+		return nil, err
+	}
+	wakeOutbox()
+	return msg, nil
+}
+
+// ResendRecordMatchRequestHandler creates a HandlerFunc that makes another
+// attempt to deliver a run's request message. If the request is still waiting
+// to be retried, the next attempt is made now; otherwise, including when the
+// request was dead-lettered, the request is queued again with a fresh set of
+// attempts. The run goes back to the queued state.
+func ResendRecordMatchRequestHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		recMatchRun, ok := loadRecordMatchRun(ctx, db)
+		if !ok {
+			return
+		}
+		id := recMatchRun.ID
+		if recMatchRun.Request.Message == nil {
+			ctx.String(http.StatusBadRequest, "Record Match Run has no request to send")
+			ctx.Abort()
+			return
+		}
+		err := ptm_models.TransitionRun(db, id, ptm_models.RunQueued, "Request Queued for Resend", nil)
+		if !transitioned(ctx, err) {
+			return
+		}
+
+		c := db.C(ptm_models.GetCollectionName("OutboxMessage"))
+		msg := &ptm_models.OutboxMessage{}
+		now := time.Now().Round(time.Millisecond)
+		_, err = c.Find(bson.M{"recordMatchRunId": id, "description": ptm_models.OutboxRequest,
+			"status": ptm_models.OutboxPending}).
+			Apply(mgo.Change{Update: bson.M{"$set": bson.M{"nextAttemptOn": now}}, ReturnNew: true}, msg)
+		switch {
+		case err == mgo.ErrNotFound:
+			// the system interface may have been corrected since the request was
+			// first sent, so look up the endpoint again
+			obj, err := ptm_models.LoadResource(db, "RecordMatchSystemInterface",
+				recMatchRun.RecordMatchSystemInterfaceID)
+			if err != nil {
+				ctx.String(http.StatusBadRequest, "Unable to find Record Match System Interface")
+				ctx.Abort()
+				return
+			}
+			msg, err = queueRecordMatchRequest(db, recMatchRun, obj.(*ptm_models.RecordMatchSystemInterface))
+			if err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+		case err != nil:
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		default:
+			wakeOutbox()
+		}
+
+		ctx.Header("Location", responseURL(ctx.Request, "OutboxMessage", msg.ID.Hex()).String())
+		ctx.JSON(http.StatusAccepted, msg)
+	}
+}
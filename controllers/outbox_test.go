@@ -0,0 +1,174 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+func (s *ServerSuite) TestDeliverOutboxMessage(c *C) {
+	statusCode := http.StatusServiceUnavailable
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statusCode)
+	}))
+	defer broker.Close()
+
+	run := &ptm_models.RecordMatchRun{}
+	_, err := ptm_models.PersistResource(database, "RecordMatchRun", run)
+	c.Assert(err, IsNil)
+	msg := ptm_models.NewOutboxMessage(run.ID, ptm_models.OutboxRequest, http.MethodPut, broker.URL+"/Bundle/1",
+		"application/json+fhir", []byte("{}"), 2)
+	_, err = ptm_models.PersistResource(database, "OutboxMessage", msg)
+	c.Assert(err, IsNil)
+
+	// the first attempt fails and is retried later
+	deliverOutboxMessage(database, msg)
+	obj, err := ptm_models.LoadResource(database, "OutboxMessage", msg.ID)
+	c.Assert(err, IsNil)
+	stored := obj.(*ptm_models.OutboxMessage)
+	c.Assert(stored.Status, Equals, ptm_models.OutboxPending)
+	c.Assert(stored.Attempts, HasLen, 1)
+	c.Assert(stored.Attempts[0].StatusCode, Equals, http.StatusServiceUnavailable)
+
+	statusCode = http.StatusOK
+	deliverOutboxMessage(database, stored)
+	obj, err = ptm_models.LoadResource(database, "OutboxMessage", msg.ID)
+	c.Assert(err, IsNil)
+	c.Assert(obj.(*ptm_models.OutboxMessage).Status, Equals, ptm_models.OutboxDelivered)
+
+	obj, err = ptm_models.LoadResource(database, "RecordMatchRun", run.ID)
+	c.Assert(err, IsNil)
+	run = obj.(*ptm_models.RecordMatchRun)
+	c.Assert(run.Status, HasLen, 2)
+	c.Assert(run.Request.SubmittedOn.IsZero(), Equals, false)
+}
+
+func (s *ServerSuite) TestDeadLetterOutboxMessage(c *C) {
+	run := &ptm_models.RecordMatchRun{}
+	_, err := ptm_models.PersistResource(database, "RecordMatchRun", run)
+	c.Assert(err, IsNil)
+	// nothing listens on this endpoint, so there's no response
+	msg := ptm_models.NewOutboxMessage(run.ID, ptm_models.OutboxRequest, http.MethodPut, "http://127.0.0.1:1/Bundle/1",
+		"application/json+fhir", []byte("{}"), 1)
+	_, err = ptm_models.PersistResource(database, "OutboxMessage", msg)
+	c.Assert(err, IsNil)
+
+	deliverOutboxMessage(database, msg)
+	obj, err := ptm_models.LoadResource(database, "OutboxMessage", msg.ID)
+	c.Assert(err, IsNil)
+	stored := obj.(*ptm_models.OutboxMessage)
+	c.Assert(stored.Status, Equals, ptm_models.OutboxDead)
+	c.Assert(stored.Attempts[0].Error, Not(Equals), "")
+}
+
+func (s *ServerSuite) TestDispatchAroundSlowRecordMatcher(c *C) {
+	timeout := outboxClient.Timeout
+	outboxClient.Timeout = 500 * time.Millisecond
+	defer func() { outboxClient.Timeout = timeout }()
+
+	// one record matcher never answers, the other answers right away
+	hang := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer slow.Close()
+	defer close(hang)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer fast.Close()
+
+	run := &ptm_models.RecordMatchRun{}
+	_, err := ptm_models.PersistResource(database, "RecordMatchRun", run)
+	c.Assert(err, IsNil)
+	var msgs []*ptm_models.OutboxMessage
+	for _, endpoint := range []string{slow.URL + "/Bundle/1", fast.URL + "/Bundle/2"} {
+		msg := ptm_models.NewOutboxMessage(run.ID, ptm_models.OutboxCancellation, http.MethodPut, endpoint,
+			"application/json+fhir", []byte("{}"), 2)
+		_, err = ptm_models.PersistResource(database, "OutboxMessage", msg)
+		c.Assert(err, IsNil)
+		msgs = append(msgs, msg)
+	}
+
+	dispatchDueMessages(func() *mgo.Database { return database })
+	status := func(msg *ptm_models.OutboxMessage) string {
+		obj, err := ptm_models.LoadResource(database, "OutboxMessage", msg.ID)
+		c.Assert(err, IsNil)
+		return obj.(*ptm_models.OutboxMessage).Status
+	}
+	waitFor := func(msg *ptm_models.OutboxMessage, f func(*ptm_models.OutboxMessage) bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !f(msg) && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	// the fast record matcher gets its message while the slow one stalls
+	waitFor(msgs[1], func(m *ptm_models.OutboxMessage) bool { return status(m) == ptm_models.OutboxDelivered })
+	c.Assert(status(msgs[1]), Equals, ptm_models.OutboxDelivered)
+
+	// and the attempt to reach the slow one times out
+	attempts := func(m *ptm_models.OutboxMessage) []ptm_models.DeliveryAttempt {
+		obj, err := ptm_models.LoadResource(database, "OutboxMessage", m.ID)
+		c.Assert(err, IsNil)
+		return obj.(*ptm_models.OutboxMessage).Attempts
+	}
+	waitFor(msgs[0], func(m *ptm_models.OutboxMessage) bool { return len(attempts(m)) > 0 })
+	c.Assert(attempts(msgs[0]), HasLen, 1)
+	c.Assert(attempts(msgs[0])[0].Delivered, Equals, false)
+	c.Assert(status(msgs[0]), Equals, ptm_models.OutboxPending)
+}
+
+func (s *ServerSuite) TestOutboxHost(c *C) {
+	c.Assert(outboxHost("http://localhost:3001/Bundle/1"), Equals, "localhost:3001")
+	c.Assert(outboxHost("not a url"), Equals, "not a url")
+}
+
+func (s *ServerSuite) TestDeliveryCancelledDuringAttempt(c *C) {
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broker.Close()
+
+	run := &ptm_models.RecordMatchRun{}
+	_, err := ptm_models.PersistResource(database, "RecordMatchRun", run)
+	c.Assert(err, IsNil)
+	msg := ptm_models.NewOutboxMessage(run.ID, ptm_models.OutboxRequest, http.MethodPut, broker.URL+"/Bundle/1",
+		"application/json+fhir", []byte("{}"), 2)
+	_, err = ptm_models.PersistResource(database, "OutboxMessage", msg)
+	c.Assert(err, IsNil)
+
+	// the run is cancelled after the message was claimed for an attempt
+	err = database.C(ptm_models.GetCollectionName("OutboxMessage")).UpdateId(msg.ID,
+		bson.M{"$set": bson.M{"status": ptm_models.OutboxCancelled}})
+	c.Assert(err, IsNil)
+	deliverOutboxMessage(database, msg)
+
+	obj, err := ptm_models.LoadResource(database, "OutboxMessage", msg.ID)
+	c.Assert(err, IsNil)
+	stored := obj.(*ptm_models.OutboxMessage)
+	c.Assert(stored.Status, Equals, ptm_models.OutboxCancelled)
+	c.Assert(stored.Attempts, HasLen, 1)
+}
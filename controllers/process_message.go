@@ -0,0 +1,107 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	logger "github.com/mitre/ptmatch/logger"
+	"github.com/mitre/ptmatch/middleware"
+	ptm_models "github.com/mitre/ptmatch/models"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+)
+
+// ProcessMessageHandler creates a HandlerFunc that implements the FHIR
+// $process-message operation, through which a record matcher with the
+// process-message delivery mode returns its responses. Responses to runs
+// whose record matcher has another delivery mode are rejected. The message is
+// stored as a Bundle, as if it had been PUT, and then processed like any
+// other response.
+func ProcessMessageHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		msg := &fhir_models.Bundle{}
+		if err := ctx.BindJSON(msg); err != nil {
+			return
+		}
+		if msg.Type != "message" || len(msg.Entry) == 0 {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("The Bundle is not a FHIR message"))
+			return
+		}
+		msgHdr, ok := msg.Entry[0].Resource.(*fhir_models.MessageHeader)
+		if !ok {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("The message does not start with a MessageHeader"))
+			return
+		}
+		if msgHdr.Response == nil {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("The message is not a response"))
+			return
+		}
+
+		// only record matchers that use the process-message delivery mode
+		// return their responses this way
+		run := &ptm_models.RecordMatchRun{}
+		err := db.C(ptm_models.GetCollectionName("RecordMatchRun")).Find(
+			bson.M{"request.message.entry.resource._id": msgHdr.Response.Identifier}).
+			Select(bson.M{"recordMatchSystemInterfaceId": 1}).One(run)
+		if err == mgo.ErrNotFound {
+			ctx.AbortWithError(http.StatusNotFound, errors.New("Unable to find the request to which the message responds"))
+			return
+		} else if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		obj, err := ptm_models.LoadResource(db, "RecordMatchSystemInterface", run.RecordMatchSystemInterfaceID)
+		if err == nil && obj.(*ptm_models.RecordMatchSystemInterface).DeliveryMode != ptm_models.DeliveryProcessMessage {
+			ctx.AbortWithError(http.StatusBadRequest,
+				errors.New("The record matcher doesn't use the process-message delivery mode"))
+			return
+		}
+
+		if msg.Id != "" {
+			ptm_models.UpdateFhirLastUpdatedDate(msg)
+			_, err = db.C(ptm_models.GetCollectionName("Bundle")).UpsertId(msg.Id, msg)
+		} else {
+			_, err = ptm_models.PersistFhirResource(db, "Bundle", msg)
+		}
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		logger.Log.WithFields(
+			logrus.Fields{"method": "ProcessMessageHandler", "message": msg.Id}).Info("Message received")
+
+		err = middleware.ProcessRecordMatchResponse(db, msg)
+		if err == mgo.ErrNotFound {
+			ctx.AbortWithError(http.StatusNotFound, errors.New("Unable to find the request to which the message responds"))
+			return
+		} else if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		ctx.Status(http.StatusAccepted)
+	}
+}
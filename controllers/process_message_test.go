@@ -0,0 +1,84 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	fhir_models "github.com/intervention-engine/fhir/models"
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// postProcessMessage stores a sent run for a record matcher with the
+// delivery mode, then posts an acknowledgement of its request to
+// $process-message. The response code and the run are returned.
+func postProcessMessage(c *C, deliveryMode string) (int, *ptm_models.RecordMatchRun) {
+	rmsi := &ptm_models.RecordMatchSystemInterface{DeliveryMode: deliveryMode}
+	_, err := ptm_models.PersistResource(database, "RecordMatchSystemInterface", rmsi)
+	c.Assert(err, IsNil)
+
+	reqHdr := &fhir_models.MessageHeader{}
+	reqHdr.Id = bson.NewObjectId().Hex()
+	run := &ptm_models.RecordMatchRun{State: ptm_models.RunSent, RecordMatchSystemInterfaceID: rmsi.ID,
+		Request: ptm_models.RecordMatchRequest{Message: &fhir_models.Bundle{Type: "message",
+			Entry: []fhir_models.BundleEntryComponent{{Resource: reqHdr}}}}}
+	_, err = ptm_models.PersistResource(database, "RecordMatchRun", run)
+	c.Assert(err, IsNil)
+
+	ack := &fhir_models.Bundle{Type: "message", Entry: []fhir_models.BundleEntryComponent{{Resource: &fhir_models.MessageHeader{
+		Event:    &fhir_models.Coding{System: "http://github.com/mitre/ptmatch/fhir/message-events", Code: "record-match"},
+		Response: &fhir_models.MessageHeaderResponseComponent{Identifier: reqHdr.Id, Code: ptm_models.ResponseOK}}}}}
+	ack.Id = bson.NewObjectId().Hex()
+	body, err := json.Marshal(ack)
+	c.Assert(err, IsNil)
+
+	e := gin.New()
+	e.POST("/$process-message", ProcessMessageHandler(func() *mgo.Database { return database }))
+	r, err := http.NewRequest("POST", "/$process-message", bytes.NewReader(body))
+	c.Assert(err, IsNil)
+	r.Header.Set("Content-Type", "application/json")
+	rw := httptest.NewRecorder()
+	e.ServeHTTP(rw, r)
+
+	obj, err := ptm_models.LoadResource(database, "RecordMatchRun", run.ID)
+	c.Assert(err, IsNil)
+	return rw.Code, obj.(*ptm_models.RecordMatchRun)
+}
+
+func (s *ServerSuite) TestProcessMessage(c *C) {
+	code, run := postProcessMessage(c, ptm_models.DeliveryProcessMessage)
+	c.Assert(code, Equals, http.StatusAccepted)
+	c.Assert(run.State, Equals, ptm_models.RunAcknowledged)
+	c.Assert(run.Responses, HasLen, 1)
+}
+
+func (s *ServerSuite) TestProcessMessageOtherDeliveryMode(c *C) {
+	// a record matcher that PUTs its responses as Bundles can't use
+	// $process-message
+	code, run := postProcessMessage(c, "")
+	c.Assert(code, Equals, http.StatusBadRequest)
+	c.Assert(run.State, Equals, ptm_models.RunSent)
+	c.Assert(run.Responses, HasLen, 0)
+}
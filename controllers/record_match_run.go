@@ -17,7 +17,6 @@ limitations under the License.
 package controllers
 
 import (
-	"bytes"
 	"errors"
 	"net/http"
 	"strconv"
@@ -32,13 +31,15 @@ import (
 	"github.com/satori/go.uuid"
 
 	fhir_models "github.com/intervention-engine/fhir/models"
-	ptm_http "github.com/mitre/ptmatch/http"
 	logger "github.com/mitre/ptmatch/logger"
 	ptm_models "github.com/mitre/ptmatch/models"
 )
 
 // CreateRecordMatchRunHandler creates a HandlerFunc that creates a new
-// RecordMatchRun and constructs and sends a Record Match request message.
+// RecordMatchRun and constructs a Record Match request message, which is
+// queued for delivery to the record matcher. A run created in the draft state
+// is stored without a request; the request is constructed when the run is
+// submitted.
 func CreateRecordMatchRunHandler(provider func() *mgo.Database) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		recMatchRun := &ptm_models.RecordMatchRun{}
@@ -53,6 +54,16 @@ func CreateRecordMatchRunHandler(provider func() *mgo.Database) gin.HandlerFunc
 			ctx.Abort()
 			return
 		}
+		if recMatchRun.ResponseTimeout < 0 {
+			ctx.String(http.StatusBadRequest, "Invalid responseTimeout")
+			ctx.Abort()
+			return
+		}
+		if recMatchRun.State != "" && recMatchRun.State != ptm_models.RunDraft {
+			ctx.String(http.StatusBadRequest, "A Record Match Run can only be created as a draft")
+			ctx.Abort()
+			return
+		}
 
 		// retrieve and validate the record match context
 		recMatchContextID := recMatchRun.RecordMatchContextID
@@ -66,91 +77,286 @@ func CreateRecordMatchRunHandler(provider func() *mgo.Database) gin.HandlerFunc
 			return
 		}
 
-		// Retrieve the info about the record matcher
-		obj, err := ptm_models.LoadResource(provider(), "RecordMatchSystemInterface",
-			recMatchRun.RecordMatchSystemInterfaceID)
+		if recMatchRun.State == ptm_models.RunDraft {
+			recMatchRun.Status = []ptm_models.RecordMatchRunStatusComponent{
+				{Message: "Draft Created", State: ptm_models.RunDraft, CreatedOn: time.Now()}}
+			resource, err := ptm_models.PersistResource(provider(), "RecordMatchRun", recMatchRun)
+			if err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+			ctx.JSON(http.StatusCreated, resource)
+			return
+		}
+
+		recMatchRun.Status = nil
+		if !dispatchNewRecordMatchRun(ctx, provider(), recMatchRun) {
+			return
+		}
+
+		ctx.JSON(http.StatusCreated, recMatchRun)
+	}
+}
+
+// dispatchNewRecordMatchRun constructs the request for a new run, stores the
+// run and queues the request for delivery to the record matcher. If that
+// fails, an error response is written and false is returned.
+func dispatchNewRecordMatchRun(ctx *gin.Context, db *mgo.Database, recMatchRun *ptm_models.RecordMatchRun) bool {
+	recMatchSysIface, err := prepRecordMatchRequest(db, recMatchRun)
+	if err == nil {
+		err = storeAndQueueRecordMatchRun(db, recMatchRun, recMatchSysIface)
+	}
+	if err != nil {
+		abortRun(ctx, err)
+		return false
+	}
+	return true
+}
+
+// storeAndQueueRecordMatchRun stores a new run, whose request has been
+// constructed, and queues the request for delivery to the record matcher.
+func storeAndQueueRecordMatchRun(db *mgo.Database, recMatchRun *ptm_models.RecordMatchRun,
+	recMatchSysIface *ptm_models.RecordMatchSystemInterface) error {
+	// the request is delivered by the outbox dispatcher once the run is stored
+	recMatchRun.State = ptm_models.RunQueued
+	recMatchRun.Status = append(recMatchRun.Status, ptm_models.RecordMatchRunStatusComponent{
+		Message: "Request Queued", State: ptm_models.RunQueued, CreatedOn: time.Now()})
+
+	// Persist the record match run
+	if _, err := ptm_models.PersistResource(db, "RecordMatchRun", recMatchRun); err != nil {
+		return err
+	}
+
+	if _, err := queueRecordMatchRequest(db, recMatchRun, recMatchSysIface); err != nil {
+		logger.Log.WithFields(
+			logrus.Fields{"method": "storeAndQueueRecordMatchRun",
+				"err": err}).Warn("Unable to queue Record Match Request")
+		return err
+	}
+	return nil
+}
+
+// SubmitRecordMatchRunHandler creates a HandlerFunc that constructs the
+// request for a draft RecordMatchRun and queues it for delivery to the record
+// matcher.
+func SubmitRecordMatchRunHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		recMatchRun, ok := loadRecordMatchRun(ctx, db)
+		if !ok {
+			return
+		}
+		if recMatchRun.State != ptm_models.RunDraft {
+			ctx.String(http.StatusConflict, "Only a draft Record Match Run can be submitted; this run is "+recMatchRun.State)
+			ctx.Abort()
+			return
+		}
+
+		recMatchSysIface, err := prepRecordMatchRequest(db, recMatchRun)
+		if err != nil {
+			abortRun(ctx, err)
+			return
+		}
+		err = ptm_models.TransitionRun(db, recMatchRun.ID, ptm_models.RunQueued, "Request Queued", bson.M{
+			"request":                   recMatchRun.Request,
+			"responseTimeout":           recMatchRun.ResponseTimeout,
+			"masterRecordSetSnapshotId": recMatchRun.MasterRecordSetSnapshotID,
+			"queryRecordSetSnapshotId":  recMatchRun.QueryRecordSetSnapshotID})
+		if !transitioned(ctx, err) {
+			return
+		}
+		if _, err = queueRecordMatchRequest(db, recMatchRun, recMatchSysIface); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		obj, err := ptm_models.LoadResource(db, "RecordMatchRun", recMatchRun.ID)
 		if err != nil {
-			ctx.String(http.StatusBadRequest, "Unable to find Record Match System Interface")
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		ctx.JSON(http.StatusAccepted, obj)
+	}
+}
+
+// UpdateRecordMatchRunHandler creates a HandlerFunc that updates the
+// configuration of a RecordMatchRun: its note, context, record matcher,
+// matching mode, record sets and response timeout. The rest of the run, such
+// as its request, responses and metrics, is kept. A run's state is changed
+// only by the run's operations (e.g., $submit).
+func UpdateRecordMatchRunHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		existing, ok := loadRecordMatchRun(ctx, db)
+		if !ok {
+			return
+		}
+		recMatchRun := &ptm_models.RecordMatchRun{}
+		if err := ctx.Bind(recMatchRun); err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		if recMatchRun.ResponseTimeout < 0 {
+			ctx.String(http.StatusBadRequest, "Invalid responseTimeout")
 			ctx.Abort()
 			return
 		}
-		recMatchSysIface := obj.(*ptm_models.RecordMatchSystemInterface)
-		if !isValidRecordMatchSysIface(recMatchSysIface) {
-			ctx.String(http.StatusBadRequest, "Invalid Record Match System Interface")
+		if recMatchRun.State != "" && recMatchRun.State != existing.State {
+			ctx.String(http.StatusConflict, "The state of a Record Match Run can't be changed by an update")
 			ctx.Abort()
 			return
 		}
 
-		// construct a record match request
-		reqMatchRequest, err := newRecordMatchRequest(recMatchSysIface.ResponseEndpoint, recMatchRun, provider())
+		update, err := recMatchRun.ConfigurationUpdate()
 		if err != nil {
-			logger.Log.WithFields(
-				logrus.Fields{"method": "CreateRecordMatchRun",
-					"err": err}).Warn("Unable to create Record Match Request")
 			ctx.AbortWithError(http.StatusBadRequest, err)
 			return
 		}
-		// attach the request message to the run object
-		recMatchRun.Request = *reqMatchRequest
+		c := db.C(ptm_models.GetCollectionName("RecordMatchRun"))
+		if err = c.UpdateId(existing.ID, update); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		updated := &ptm_models.RecordMatchRun{}
+		if err = c.FindId(existing.ID).One(updated); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
 
-		// Construct body of the http request for the record match request
-		reqBody, _ := reqMatchRequest.Message.MarshalJSON()
+		ctx.Header("Location", responseURL(ctx.Request, "RecordMatchRun", updated.ID.Hex()).String())
+		ctx.JSON(http.StatusOK, updated)
+	}
+}
 
-		svrEndpoint := prepEndpoint(recMatchSysIface.ServerEndpoint, reqMatchRequest.Message.Id)
+// runError describes why a run can't be sent, in a form that can be written
+// as a response.
+type runError struct {
+	Status  int
+	Message string
+	// problems with a record set, if that's why the run can't be sent
+	Validation *ptm_models.RecordSetValidation
+}
 
-		logger.Log.WithFields(
-			logrus.Fields{"method": "CreateRecordMatchRun",
-				"server endpoint": svrEndpoint,
-				"reqBody":         string(reqBody[:]),
-				"message":         reqMatchRequest.Message}).Info("About to submit request")
+func (e *runError) Error() string {
+	if e.Validation != nil {
+		return e.Message + ": " + strings.Join(e.Validation.Problems, "; ")
+	}
+	return e.Message
+}
 
-		reqMatchRequest.SubmittedOn = time.Now()
+// abortRun writes the response for an error that prevents a run from being
+// sent.
+func abortRun(ctx *gin.Context, err error) {
+	re, ok := err.(*runError)
+	switch {
+	case !ok:
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	case re.Validation != nil:
+		ctx.JSON(re.Status, re.Validation)
+	default:
+		ctx.String(re.Status, re.Message)
+	}
+	ctx.Abort()
+}
 
-		// Send the record match request to a FHIR server acting as msg broker.
-		resp, err := ptm_http.Put(svrEndpoint, "application/json+fhir",
-			bytes.NewReader(reqBody))
-		if err != nil {
-			// HACK In this configuration, the test harness only supports itself as the FHIR message broker
-			if resp.StatusCode == 302 {
-				logger.Log.Debug("CreateRecordMatchRun: redirect detected - Infer server is in Heart mode")
-				_, err = ptm_models.PersistFhirResource(provider(), "Bundle", reqMatchRequest.Message)
-				if err != nil {
-					ctx.AbortWithError(http.StatusInternalServerError, err)
-					return
-				}
-			} else {
-				logger.Log.WithFields(
-					logrus.Fields{"method": "CreateRecordMatchRun",
-						"err": err}).Warn("Sending Record Match Request")
-				ctx.AbortWithError(http.StatusInternalServerError, err)
-				return
-			}
-		}
+// prepRecordMatchRequest checks the run's record matcher and record sets,
+// snapshots the record sets and attaches a new request message to the run. If
+// the run can't be sent because of its configuration, a *runError is
+// returned.
+func prepRecordMatchRequest(db *mgo.Database,
+	recMatchRun *ptm_models.RecordMatchRun) (*ptm_models.RecordMatchSystemInterface, error) {
+	recMatchSysIface, err := loadRecordMatchSysIface(db, recMatchRun.RecordMatchSystemInterfaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	// check that the record sets select records, and record the members
+	// as the record matcher will see them
+	if err = snapshotRecordSets(db, recMatchRun); err != nil {
+		return nil, err
+	}
+
+	if err = buildRecordMatchRequest(db, recMatchRun, recMatchSysIface); err != nil {
+		return nil, err
+	}
+	return recMatchSysIface, nil
+}
+
+// loadRecordMatchSysIface retrieves the info about a record matcher. A
+// *runError is returned if it can't be found or is invalid.
+func loadRecordMatchSysIface(db *mgo.Database, id bson.ObjectId) (*ptm_models.RecordMatchSystemInterface, error) {
+	obj, err := ptm_models.LoadResource(db, "RecordMatchSystemInterface", id)
+	if err != nil {
+		return nil, &runError{Status: http.StatusBadRequest, Message: "Unable to find Record Match System Interface"}
+	}
+	recMatchSysIface := obj.(*ptm_models.RecordMatchSystemInterface)
+	if !isValidRecordMatchSysIface(recMatchSysIface) {
+		return nil, &runError{Status: http.StatusBadRequest, Message: "Invalid Record Match System Interface"}
+	}
+	return recMatchSysIface, nil
+}
+
+// buildRecordMatchRequest constructs the request message for the record
+// matcher and attaches it to the run. The run's response timeout defaults to
+// that of the record matcher.
+func buildRecordMatchRequest(db *mgo.Database, recMatchRun *ptm_models.RecordMatchRun,
+	recMatchSysIface *ptm_models.RecordMatchSystemInterface) error {
+	if recMatchRun.ResponseTimeout == 0 {
+		recMatchRun.ResponseTimeout = recMatchSysIface.ResponseTimeout
+	}
+
+	// construct a record match request
+	reqMatchRequest, err := newRecordMatchRequest(responseEndpoint(recMatchSysIface), recMatchRun, db)
+	if err != nil {
 		logger.Log.WithFields(
-			logrus.Fields{"method": "CreateRecordMatchRun",
-				"status": resp.StatusCode}).Info("Put Response Status")
-
-		// Store status, Sent, with the run object
-		recMatchRun.Status = make([]ptm_models.RecordMatchRunStatusComponent, 1)
-		recMatchRun.Status[0].CreatedOn = time.Now()
-		// if a success code was received
-		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-			recMatchRun.Status[0].Message = "Request Sent [" + resp.Status + "]"
-		} else if resp.StatusCode == 302 {
-			recMatchRun.Status[0].Message = "Redirect from Msg Broker Not Supported; Request persisted in local database"
-		} else {
-			recMatchRun.Status[0].Message = "Error Sending Request to Record Matcher [" + resp.Status + "]"
-		}
+			logrus.Fields{"method": "buildRecordMatchRequest",
+				"err": err}).Warn("Unable to create Record Match Request")
+		return &runError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	// attach the request message to the run object
+	recMatchRun.Request = *reqMatchRequest
+	return nil
+}
 
-		// Persist the record match run
-		resource, err := ptm_models.PersistResource(provider(), "RecordMatchRun", recMatchRun)
-		if err != nil {
+// loadRecordMatchRun loads the run identified in the request path. If it
+// can't be loaded, an error response is written and false is returned.
+func loadRecordMatchRun(ctx *gin.Context, db *mgo.Database) (*ptm_models.RecordMatchRun, bool) {
+	id, err := toBsonObjectID(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return nil, false
+	}
+	obj, err := ptm_models.LoadResource(db, "RecordMatchRun", id)
+	if err == mgo.ErrNotFound {
+		ctx.String(http.StatusNotFound, "Record Match Run not found")
+		ctx.Abort()
+		return nil, false
+	} else if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return obj.(*ptm_models.RecordMatchRun), true
+}
+
+// transitioned writes an error response and returns false if a run couldn't
+// be moved to a new state.
+func transitioned(ctx *gin.Context, err error) bool {
+	switch err.(type) {
+	case nil:
+		return true
+	case *ptm_models.RunTransitionError:
+		ctx.String(http.StatusConflict, err.Error())
+		ctx.Abort()
+	default:
+		if err == mgo.ErrNotFound {
+			ctx.String(http.StatusNotFound, "Record Match Run not found")
+			ctx.Abort()
+		} else {
 			ctx.AbortWithError(http.StatusInternalServerError, err)
-			return
 		}
-
-		ctx.JSON(http.StatusCreated, resource)
 	}
+	return false
 }
 
 func GetRecordMatchRunMetricsHandler(provider func() *mgo.Database) gin.HandlerFunc {
@@ -262,7 +468,8 @@ func isValidRecordMatchSysIface(rmsi *ptm_models.RecordMatchSystemInterface) boo
 	// check that server, destination, and response endpoints are Set
 	// TODO check that server, destination, and response endpoint values seem reasonable
 	if rmsi.ID.Valid() && rmsi.DestinationEndpoint != "" &&
-		rmsi.ServerEndpoint != "" && rmsi.ResponseEndpoint != "" {
+		rmsi.ServerEndpoint != "" && rmsi.ResponseEndpoint != "" &&
+		(rmsi.DeliveryMode == "" || ptm_models.IsDeliveryMode(rmsi.DeliveryMode)) {
 		isValid = true
 	}
 	return isValid
@@ -288,6 +495,38 @@ func prepEndpoint(baseURL, id string) string {
 	return result
 }
 
+// prepDelivery returns the HTTP method and the URL with which the message
+// with the id is delivered to the record matcher, according to the delivery
+// mode of the record match system interface.
+func prepDelivery(rmsi *ptm_models.RecordMatchSystemInterface, id string) (string, string) {
+	switch rmsi.DeliveryMode {
+	case ptm_models.DeliveryProcessMessage:
+		return http.MethodPost, processMessageEndpoint(rmsi.ServerEndpoint)
+	case ptm_models.DeliveryPost:
+		return http.MethodPost, rmsi.ServerEndpoint
+	default:
+		return http.MethodPut, prepEndpoint(rmsi.ServerEndpoint, id)
+	}
+}
+
+// responseEndpoint returns the endpoint to which the record matcher returns
+// its responses, as named in the MessageHeader of a request, according to the
+// delivery mode of the record match system interface. With the
+// process-message mode, responses go to the $process-message operation of
+// the response endpoint; otherwise, to the response endpoint itself.
+func responseEndpoint(rmsi *ptm_models.RecordMatchSystemInterface) string {
+	if rmsi.DeliveryMode == ptm_models.DeliveryProcessMessage {
+		return processMessageEndpoint(rmsi.ResponseEndpoint)
+	}
+	return rmsi.ResponseEndpoint
+}
+
+// processMessageEndpoint returns the URL of the $process-message operation
+// of the FHIR server with the base URL, which may end in /Bundle.
+func processMessageEndpoint(baseURL string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/Bundle") + "/$process-message"
+}
+
 func newRecordMatchRequest(srcEndpoint string,
 	recMatchRun *ptm_models.RecordMatchRun, db *mgo.Database) (*ptm_models.RecordMatchRequest, error) {
 
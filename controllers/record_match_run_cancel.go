@@ -0,0 +1,158 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/satori/go.uuid"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	logger "github.com/mitre/ptmatch/logger"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// CancelRecordMatchRunHandler creates a HandlerFunc that cancels a
+// RecordMatchRun. Pending deliveries of the run's request are stopped and, if
+// the record matcher may have received the request, a cancellation message is
+// sent to it. Responses that arrive for a cancelled run are stored, but not
+// scored.
+func CancelRecordMatchRunHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		recMatchRun, ok := loadRecordMatchRun(ctx, db)
+		if !ok {
+			return
+		}
+
+		status := "Cancelled"
+		if reason := ctx.Query("reason"); reason != "" {
+			status += " [" + reason + "]"
+		}
+		now := time.Now().Round(time.Millisecond)
+		err := ptm_models.TransitionRun(db, recMatchRun.ID, ptm_models.RunCancelled, status,
+			bson.M{"cancelledOn": now})
+		if !transitioned(ctx, err) {
+			return
+		}
+
+		// stop retrying the request; an attempt that is under way can't be
+		// stopped, and its outcome won't change the message's status
+		c := db.C(ptm_models.GetCollectionName("OutboxMessage"))
+		_, err = c.UpdateAll(
+			bson.M{"recordMatchRunId": recMatchRun.ID, "status": ptm_models.OutboxPending},
+			bson.M{"$set": bson.M{"status": ptm_models.OutboxCancelled, "meta.lastUpdatedOn": now}})
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		// the run loaded above may predate the delivery of the request, so
+		// the outbox is checked as well
+		attempted, err := c.Find(bson.M{"recordMatchRunId": recMatchRun.ID,
+			"description": ptm_models.OutboxRequest,
+			"$or": []bson.M{
+				{"attempts.0": bson.M{"$exists": true}},
+				// claimed for an attempt that is under way
+				{"nextAttemptOn": bson.M{"$gt": now}, "attempts": bson.M{"$exists": false}},
+			}}).Count()
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		if wasSent(recMatchRun) || attempted > 0 {
+			if err = queueCancellation(db, recMatchRun); err != nil {
+				logger.Log.WithFields(
+					logrus.Fields{"method": "CancelRecordMatchRun", "run": recMatchRun.ID,
+						"err": err}).Warn("Unable to queue cancellation message")
+				ctx.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		obj, err := ptm_models.LoadResource(db, "RecordMatchRun", recMatchRun.ID)
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, obj)
+	}
+}
+
+// wasSent reports whether the record matcher may have received the run's
+// request.
+func wasSent(recMatchRun *ptm_models.RecordMatchRun) bool {
+	if !recMatchRun.Request.SubmittedOn.IsZero() {
+		return true
+	}
+	switch recMatchRun.State {
+	case ptm_models.RunSent, ptm_models.RunAcknowledged, ptm_models.RunResponding, ptm_models.RunTimedOut:
+		return true
+	}
+	return false
+}
+
+// queueCancellation adds a message to the outbox that tells the record
+// matcher that the run was cancelled.
+func queueCancellation(db *mgo.Database, recMatchRun *ptm_models.RecordMatchRun) error {
+	obj, err := ptm_models.LoadResource(db, "RecordMatchSystemInterface",
+		recMatchRun.RecordMatchSystemInterfaceID)
+	if err != nil {
+		return err
+	}
+	recMatchSysIface := obj.(*ptm_models.RecordMatchSystemInterface)
+
+	msg, err := newCancellationMessage(recMatchSysIface.ResponseEndpoint, recMatchRun, db)
+	if err != nil {
+		return err
+	}
+	method, endpoint := prepDelivery(recMatchSysIface, msg.Id)
+	_, err = queueMessage(db, recMatchRun.ID, ptm_models.OutboxCancellation, method, endpoint, msg)
+	return err
+}
+
+// newCancellationMessage constructs a FHIR message that cancels the run's
+// request. The message identifies the request message that is cancelled.
+func newCancellationMessage(srcEndpoint string,
+	recMatchRun *ptm_models.RecordMatchRun, db *mgo.Database) (*fhir_models.Bundle, error) {
+	msgHdr, err := newMessageHeader(srcEndpoint, recMatchRun, db)
+	if err != nil {
+		return nil, err
+	}
+	msgHdr.Event.Code = "record-match-cancel"
+
+	params := &fhir_models.Parameters{}
+	params.Id = uuid.NewV4().String()
+	params.Parameter = []fhir_models.ParametersParameterComponent{
+		{Name: "request", ValueString: recMatchRun.Request.Message.Id}}
+	msgHdr.Data = []fhir_models.Reference{{Reference: "urn:uuid:" + params.Id}}
+
+	msg := &fhir_models.Bundle{}
+	msg.Id = bson.NewObjectId().Hex()
+	msg.Type = "message"
+	msg.Entry = []fhir_models.BundleEntryComponent{
+		{FullUrl: "urn:uuid:" + msgHdr.Id, Resource: msgHdr},
+		{FullUrl: "urn:uuid:" + params.Id, Resource: params}}
+	return msg, nil
+}
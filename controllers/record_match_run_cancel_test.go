@@ -0,0 +1,51 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "gopkg.in/check.v1"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+func (s *ServerSuite) TestNewCancellationMessage(c *C) {
+	r := ptm_models.InsertResourceFromFile(database, "RecordMatchSystemInterface", "../fixtures/record-match-sys-if-01.json")
+	recMatchSysIface := r.(*ptm_models.RecordMatchSystemInterface)
+
+	recMatchRun := &ptm_models.RecordMatchRun{RecordMatchSystemInterfaceID: recMatchSysIface.ID}
+	recMatchRun.Request.Message = &fhir_models.Bundle{}
+	recMatchRun.Request.Message.Id = "5750238da7fc204288bba7f5"
+
+	msg, err := newCancellationMessage("http://replace.me/with/selurl/global", recMatchRun, database)
+	c.Assert(err, IsNil)
+	c.Assert(msg.Type, Equals, "message")
+	c.Assert(msg.Entry, HasLen, 2)
+
+	msgHdr := msg.Entry[0].Resource.(*fhir_models.MessageHeader)
+	c.Assert(msgHdr.Event.Code, Equals, "record-match-cancel")
+	c.Assert(msgHdr.Destination[0].Endpoint, Equals, recMatchSysIface.DestinationEndpoint)
+	c.Assert(msgHdr.Data[0].Reference, Equals, msg.Entry[1].FullUrl)
+
+	params := msg.Entry[1].Resource.(*fhir_models.Parameters)
+	c.Assert(params.Parameter[0].ValueString, Equals, recMatchRun.Request.Message.Id)
+}
+
+func (s *ServerSuite) TestWasSent(c *C) {
+	c.Assert(wasSent(&ptm_models.RecordMatchRun{State: ptm_models.RunQueued}), Equals, false)
+	c.Assert(wasSent(&ptm_models.RecordMatchRun{State: ptm_models.RunSent}), Equals, true)
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	fhir_models "github.com/intervention-engine/fhir/models"
+
+	logger "github.com/mitre/ptmatch/logger"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// maxUnmappedReported is the number of records without a dataset identifier
+// listed when a submission can't be created.
+const maxUnmappedReported = 100
+
+// UnmappedRecordsError is returned when the results of a run can't be
+// reported in terms of dataset identifiers.
+type UnmappedRecordsError struct {
+	Message       string   `json:"message"`
+	UnmappedCount int      `json:"unmappedCount"`
+	Unmapped      []string `json:"unmapped"`
+}
+
+// GetONCSubmissionHandler creates a HandlerFunc that returns the links found
+// by a record match run in the pairwise CSV format of the ONC Patient
+// Matching Algorithm Challenge. Records are identified by the value their
+// identifier in the system given by the "system" query parameter (by default
+// the ONC enterprise ID) had when the run was submitted. The query
+// parameters are:
+//
+//	system    identifier system of the dataset's record identifiers
+//	grade     comma separated match grades to include; by default all
+//	          grades other than certainly-not are included
+//	minScore  lowest score of the links to include
+//	unmapped  error (default), skip or id; how records without an
+//	          identifier in the system are handled
+//	header    true to include a header row
+func GetONCSubmissionHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		id, err := toBsonObjectID(ctx.Param("id"))
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		obj, err := ptm_models.LoadResource(db, "RecordMatchRun", id)
+		if err != nil {
+			if err == mgo.ErrNotFound {
+				ctx.String(http.StatusNotFound, "Not Found")
+				ctx.Abort()
+			} else {
+				ctx.AbortWithError(http.StatusInternalServerError, err)
+			}
+			return
+		}
+		recMatchRun := obj.(*ptm_models.RecordMatchRun)
+
+		system := ctx.DefaultQuery("system", ptm_models.ONCEnterpriseIDSystem)
+		unmapped := ctx.DefaultQuery("unmapped", ptm_models.UnmappedError)
+		if unmapped != ptm_models.UnmappedError && unmapped != ptm_models.UnmappedSkip &&
+			unmapped != ptm_models.UnmappedResourceID {
+			ctx.String(http.StatusBadRequest, "unmapped must be one of error, skip or id")
+			ctx.Abort()
+			return
+		}
+		var minScore float64
+		if s := ctx.Query("minScore"); s != "" {
+			if minScore, err = strconv.ParseFloat(s, 64); err != nil {
+				ctx.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+		}
+		grades := make(map[string]bool)
+		for _, grade := range strings.Split(ctx.Query("grade"), ",") {
+			if grade = strings.TrimSpace(grade); grade != "" {
+				grades[grade] = true
+			}
+		}
+
+		var links []ptm_models.Link
+		for _, link := range recMatchRun.GetLinks() {
+			if link.Score < minScore {
+				continue
+			}
+			if len(grades) > 0 && !grades[link.Match] || len(grades) == 0 && link.Match == "certainly-not" {
+				continue
+			}
+			links = append(links, link)
+		}
+
+		// map the records of the run's record sets to their dataset
+		// identifiers, as they were when the run was submitted
+		type runRecordSet struct {
+			recSetID, snapshotID bson.ObjectId
+		}
+		recSets := []runRecordSet{{recMatchRun.MasterRecordSetID, recMatchRun.MasterRecordSetSnapshotID}}
+		if recMatchRun.MatchingMode == ptm_models.Query {
+			recSets = append(recSets, runRecordSet{recMatchRun.QueryRecordSetID, recMatchRun.QueryRecordSetSnapshotID})
+		}
+		var members []fhir_models.BundleEntryComponent
+		for _, recSet := range recSets {
+			setMembers, status, err := loadRunMembers(db, recSet.recSetID, recSet.snapshotID)
+			if err != nil {
+				ctx.AbortWithError(status, err)
+				return
+			}
+			members = append(members, setMembers...)
+		}
+
+		pairs, missing := ptm_models.SubmissionPairs(links, ptm_models.IdentifierMap(members, system), unmapped)
+		if len(missing) > 0 && unmapped == ptm_models.UnmappedError {
+			report := UnmappedRecordsError{
+				Message:       "Some records have no identifier in the system " + system,
+				UnmappedCount: len(missing), Unmapped: missing}
+			if len(missing) > maxUnmappedReported {
+				report.Unmapped = missing[:maxUnmappedReported]
+			}
+			ctx.JSON(http.StatusUnprocessableEntity, report)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err = ptm_models.WriteONCSubmission(&buf, pairs, ctx.Query("header") == "true"); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		logger.Log.WithFields(
+			logrus.Fields{"method": "GetONCSubmission", "run": recMatchRun.ID,
+				"links": len(links), "pairs": len(pairs), "unmapped": len(missing)}).Info("Exported ONC submission")
+
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"run-%s-onc.csv\"", recMatchRun.ID.Hex()))
+		ctx.Data(http.StatusOK, "text/csv", buf.Bytes())
+	}
+}
+
+// loadRunMembers returns the members of a record set of a run. The members in
+// the run's snapshot of the record set are read at their snapshot versions,
+// so that records changed or removed since the run are still identified.
+// Runs without a snapshot fall back to the current members of the record
+// set. The status code of the response to an error is also returned.
+func loadRunMembers(db *mgo.Database, recSetID, snapshotID bson.ObjectId) ([]fhir_models.BundleEntryComponent, int, error) {
+	if snapshotID != "" {
+		obj, err := ptm_models.LoadResource(db, "RecordSetSnapshot", snapshotID)
+		if err == nil {
+			members, err := ptm_models.LoadSnapshotMembers(obj.(*ptm_models.RecordSetSnapshot))
+			if err != nil {
+				return nil, http.StatusBadGateway, err
+			}
+			return members, http.StatusOK, nil
+		}
+		if err != mgo.ErrNotFound {
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	obj, err := ptm_models.LoadResource(db, "RecordSet", recSetID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	members, err := ptm_models.LoadRecordSetMembers(obj.(*ptm_models.RecordSet))
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	return members, http.StatusOK, nil
+}
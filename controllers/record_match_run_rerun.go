@@ -0,0 +1,85 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+
+	logger "github.com/mitre/ptmatch/logger"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// RerunRecordMatchRunHandler creates a HandlerFunc that repeats a
+// RecordMatchRun: a new run with the same configuration is created, and a new
+// request is constructed and queued for delivery. The record matcher may be
+// changed with the recordMatchSystemInterfaceId parameter, e.g., to compare
+// two versions of a matcher. The original run lists its reruns.
+func RerunRecordMatchRunHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		original, ok := loadRecordMatchRun(ctx, db)
+		if !ok {
+			return
+		}
+
+		rerun := original.NewRerun()
+		if sysIfaceID := ctx.Query("recordMatchSystemInterfaceId"); sysIfaceID != "" {
+			id, err := toBsonObjectID(sysIfaceID)
+			if err != nil {
+				ctx.String(http.StatusBadRequest, "Invalid recordMatchSystemInterfaceId")
+				ctx.Abort()
+				return
+			}
+			if id != rerun.RecordMatchSystemInterfaceID {
+				rerun.RecordMatchSystemInterfaceID = id
+				// use the response timeout of the new record matcher
+				rerun.ResponseTimeout = 0
+			}
+		}
+		if !isValidRecordMatchRun(rerun) {
+			ctx.String(http.StatusBadRequest, "Invalid RecordMatchRun content")
+			ctx.Abort()
+			return
+		}
+		rerun.Status = []ptm_models.RecordMatchRunStatusComponent{
+			{Message: "Rerun of [" + original.ID.Hex() + "]", CreatedOn: time.Now()}}
+
+		if !dispatchNewRecordMatchRun(ctx, db, rerun) {
+			return
+		}
+
+		err := db.C(ptm_models.GetCollectionName("RecordMatchRun")).UpdateId(original.ID, bson.M{
+			"$addToSet": bson.M{"rerunIds": rerun.ID},
+			"$push": bson.M{"status": ptm_models.RecordMatchRunStatusComponent{
+				Message: "Rerun as [" + rerun.ID.Hex() + "]", CreatedOn: time.Now()}}})
+		if err != nil {
+			logger.Log.WithFields(
+				logrus.Fields{"method": "RerunRecordMatchRun", "run": original.ID,
+					"rerun": rerun.ID, "err": err}).Warn("Unable to link rerun to original run")
+		}
+
+		ctx.Header("Location", responseURL(ctx.Request, "RecordMatchRun", rerun.ID.Hex()).String())
+		ctx.JSON(http.StatusCreated, rerun)
+	}
+}
@@ -70,6 +70,7 @@ func (s *ServerSuite) TearDownTest(c *C) {
 		database.C("recordMatchRuns").DropCollection()
 		database.C("recordMatchContexts").DropCollection()
 		database.C("recordMatchSystemInterfaces").DropCollection()
+		database.C("outboxMessages").DropCollection()
 	}
 }
 
@@ -228,3 +229,37 @@ func (s *ServerSuite) TestNewMessageHeader(c *C) {
 	c.Assert(msgHdr.Source.Endpoint, Equals, src)
 	c.Assert(msgHdr.Event.Code, Equals, "record-match")
 }
+
+// TestPrepDelivery tests that messages are delivered according to the
+// delivery mode of the record match system interface.
+func (s *ServerSuite) TestPrepDelivery(c *C) {
+	rmsi := &ptm_models.RecordMatchSystemInterface{ServerEndpoint: "http://localhost:3001/"}
+	method, endpoint := prepDelivery(rmsi, "1")
+	c.Assert(method, Equals, http.MethodPut)
+	c.Assert(endpoint, Equals, "http://localhost:3001/Bundle/1")
+
+	rmsi.DeliveryMode = ptm_models.DeliveryProcessMessage
+	method, endpoint = prepDelivery(rmsi, "1")
+	c.Assert(method, Equals, http.MethodPost)
+	c.Assert(endpoint, Equals, "http://localhost:3001/$process-message")
+
+	rmsi.DeliveryMode = ptm_models.DeliveryPost
+	rmsi.ServerEndpoint = "http://localhost:8080/messages"
+	method, endpoint = prepDelivery(rmsi, "1")
+	c.Assert(method, Equals, http.MethodPost)
+	c.Assert(endpoint, Equals, "http://localhost:8080/messages")
+}
+
+// TestResponseEndpoint checks that the endpoint named for responses follows
+// the delivery mode of the record match system interface.
+func (s *ServerSuite) TestResponseEndpoint(c *C) {
+	rmsi := &ptm_models.RecordMatchSystemInterface{ResponseEndpoint: "http://localhost:3001/Bundle/"}
+	c.Assert(responseEndpoint(rmsi), Equals, "http://localhost:3001/Bundle/")
+
+	rmsi.DeliveryMode = ptm_models.DeliveryProcessMessage
+	c.Assert(responseEndpoint(rmsi), Equals, "http://localhost:3001/$process-message")
+
+	rmsi.DeliveryMode = ptm_models.DeliveryPost
+	rmsi.ResponseEndpoint = "http://localhost:3001/responses"
+	c.Assert(responseEndpoint(rmsi), Equals, "http://localhost:3001/responses")
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2"
+
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// CreateRecordMatchSystemInterfaceHandler creates a HandlerFunc that creates
+// a RecordMatchSystemInterface, after checking its delivery mode.
+func CreateRecordMatchSystemInterfaceHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		rmsi := &ptm_models.RecordMatchSystemInterface{}
+		if err := ctx.Bind(rmsi); err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		if !checkDeliveryMode(ctx, rmsi) {
+			return
+		}
+
+		if _, err := ptm_models.PersistResource(provider(), "RecordMatchSystemInterface", rmsi); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		ctx.Header("Location", responseURL(ctx.Request, "RecordMatchSystemInterface", rmsi.ID.Hex()).String())
+		ctx.JSON(http.StatusCreated, rmsi)
+	}
+}
+
+// UpdateRecordMatchSystemInterfaceHandler creates a HandlerFunc that replaces
+// a RecordMatchSystemInterface, after checking its delivery mode.
+func UpdateRecordMatchSystemInterfaceHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := toBsonObjectID(ctx.Param("id"))
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		db := provider()
+		obj, err := ptm_models.LoadResource(db, "RecordMatchSystemInterface", id)
+		if err == mgo.ErrNotFound {
+			ctx.String(http.StatusNotFound, "Record Match System Interface not found")
+			ctx.Abort()
+			return
+		} else if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		existing := obj.(*ptm_models.RecordMatchSystemInterface)
+
+		rmsi := &ptm_models.RecordMatchSystemInterface{}
+		if err = ctx.Bind(rmsi); err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		rmsi.ID, rmsi.Meta = existing.ID, existing.Meta
+		if !checkDeliveryMode(ctx, rmsi) {
+			return
+		}
+		ptm_models.UpdateLastUpdatedDate(rmsi)
+		if err = db.C(ptm_models.GetCollectionName("RecordMatchSystemInterface")).UpdateId(id, rmsi); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		ctx.Header("Location", responseURL(ctx.Request, "RecordMatchSystemInterface", id.Hex()).String())
+		ctx.JSON(http.StatusOK, rmsi)
+	}
+}
+
+// checkDeliveryMode checks that the delivery mode of the record match system
+// interface, if set, is known. If it isn't, an error response is written and
+// false is returned.
+func checkDeliveryMode(ctx *gin.Context, rmsi *ptm_models.RecordMatchSystemInterface) bool {
+	if rmsi.DeliveryMode != "" && !ptm_models.IsDeliveryMode(rmsi.DeliveryMode) {
+		ctx.String(http.StatusBadRequest, "Unknown delivery mode: "+rmsi.DeliveryMode)
+		ctx.Abort()
+		return false
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+)
+
+func (s *ServerSuite) TestCreateRecordMatchSystemInterface(c *C) {
+	e := gin.New()
+	e.POST("/RecordMatchSystemInterface",
+		CreateRecordMatchSystemInterfaceHandler(func() *mgo.Database { return database }))
+
+	for _, expected := range []struct {
+		body string
+		code int
+	}{
+		{`{"name": "FRIL", "deliveryMode": "process-message"}`, http.StatusCreated},
+		{`{"name": "FRIL"}`, http.StatusCreated},
+		{`{"name": "FRIL", "deliveryMode": "email"}`, http.StatusBadRequest},
+	} {
+		r, err := http.NewRequest("POST", "/RecordMatchSystemInterface", strings.NewReader(expected.body))
+		c.Assert(err, IsNil)
+		r.Header.Set("Content-Type", "application/json")
+		rw := httptest.NewRecorder()
+		e.ServeHTTP(rw, r)
+		c.Assert(rw.Code, Equals, expected.code, Commentf("%s", expected.body))
+	}
+}
@@ -0,0 +1,337 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+
+	logger "github.com/mitre/ptmatch/logger"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// AnswerKeyFromLinksResult is returned after an answer key is built from the
+// Patient.link elements of a record set's members.
+type AnswerKeyFromLinksResult struct {
+	RecordSet     *ptm_models.RecordSet     `json:"recordSet"`
+	PairCount     int                       `json:"pairCount"`
+	ExternalLinks []ptm_models.ExternalLink `json:"externalLinks,omitempty"`
+}
+
+// CreateAnswerKeyFromLinksHandler creates a HandlerFunc that builds the answer
+// key of a record set from the seealso and replaced-by links recorded on the
+// patients in the set. Links to patients outside the set are reported, but
+// are not part of the answer key.
+func CreateAnswerKeyFromLinksHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		recSet, ok := loadRecordSet(ctx, provider())
+		if !ok {
+			return
+		}
+
+		members, err := ptm_models.LoadRecordSetMembers(recSet)
+		if err != nil {
+			logger.Log.WithFields(
+				logrus.Fields{"method": "CreateAnswerKeyFromLinks",
+					"record set": recSet.ID, "err": err}).Warn("Unable to load record set members")
+			ctx.AbortWithError(http.StatusBadGateway, err)
+			return
+		}
+
+		pairs, external := ptm_models.PatientLinkPairs(recSet.BaseURL(), members)
+		recSet.AnswerKey = *ptm_models.NewAnswerKey(recSet, pairs)
+
+		logger.Log.WithFields(
+			logrus.Fields{"method": "CreateAnswerKeyFromLinks",
+				"record set": recSet.ID,
+				"members":    len(members),
+				"pairs":      len(pairs),
+				"external":   len(external)}).Info("Built answer key from links")
+
+		c := provider().C(ptm_models.GetCollectionName("RecordSet"))
+		err = c.UpdateId(recSet.ID, bson.M{"$set": bson.M{
+			"answerKey":          recSet.AnswerKey,
+			"meta.lastUpdatedOn": time.Now().Round(time.Millisecond)}})
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, AnswerKeyFromLinksResult{
+			RecordSet:     recSet,
+			PairCount:     len(pairs),
+			ExternalLinks: external})
+	}
+}
+
+// CreateRecordSetHandler creates a HandlerFunc that creates a record set after
+// a dry run of its search expression, rejecting record sets whose search
+// fails or selects no records or records of mixed types. The dry run can be
+// skipped with validate=false, for a record set whose records are uploaded
+// after it is created.
+func CreateRecordSetHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		recSet := &ptm_models.RecordSet{}
+		if err := ctx.Bind(recSet); err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		if ctx.Query("validate") != "false" {
+			validation, _ := ptm_models.ValidateRecordSet(recSet)
+			if !validation.Valid {
+				logger.Log.WithFields(
+					logrus.Fields{"method": "CreateRecordSet", "name": recSet.Name,
+						"problems": validation.Problems}).Info("Rejected invalid record set")
+				ctx.JSON(http.StatusBadRequest, validation)
+				return
+			}
+		}
+
+		if _, err := ptm_models.PersistResource(provider(), "RecordSet", recSet); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		ctx.Header("Location", responseURL(ctx.Request, "RecordSet", recSet.ID.Hex()).String())
+		ctx.JSON(http.StatusCreated, recSet)
+	}
+}
+
+// ValidateRecordSetHandler creates a HandlerFunc that performs a dry run of a
+// record set's search expression and reports the number and types of the
+// records it selects.
+func ValidateRecordSetHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		recSet, ok := loadRecordSet(ctx, provider())
+		if !ok {
+			return
+		}
+		validation, _ := ptm_models.ValidateRecordSet(recSet)
+		ctx.JSON(http.StatusOK, validation)
+	}
+}
+
+// GetRecordSetProfileHandler returns a summary of the record set's members
+// and answer key, such as field completeness and the distribution of
+// cluster sizes.
+func GetRecordSetProfileHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		recSet, ok := loadRecordSet(ctx, provider())
+		if !ok {
+			return
+		}
+
+		members, err := ptm_models.LoadRecordSetMembers(recSet)
+		if err != nil {
+			logger.Log.WithFields(
+				logrus.Fields{"method": "GetRecordSetProfile",
+					"record set": recSet.ID, "err": err}).Warn("Unable to load record set members")
+			ctx.AbortWithError(http.StatusBadGateway, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, ptm_models.NewRecordSetProfile(recSet, members))
+	}
+}
+
+// ExportRecordSetHandler creates a HandlerFunc that returns a zip archive of
+// the record set's members and answer key, for use by matchers that run
+// offline.
+func ExportRecordSetHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		recSet, ok := loadRecordSet(ctx, provider())
+		if !ok {
+			return
+		}
+
+		members, err := ptm_models.LoadRecordSetMembers(recSet)
+		if err != nil {
+			logger.Log.WithFields(
+				logrus.Fields{"method": "ExportRecordSet",
+					"record set": recSet.ID, "err": err}).Warn("Unable to load record set members")
+			ctx.AbortWithError(http.StatusBadGateway, err)
+			return
+		}
+
+		// build the archive before responding so that errors can be reported
+		var archive bytes.Buffer
+		manifest, err := ptm_models.WriteRecordSetExport(&archive, recSet, members)
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		logger.Log.WithFields(
+			logrus.Fields{"method": "ExportRecordSet", "record set": recSet.ID,
+				"members": manifest.RecordCount, "pairs": manifest.PairCount}).Info("Exported record set")
+
+		filename := ptm_models.RecordSetTagCode(recSet.Name)
+		if filename == "" {
+			filename = recSet.ID.Hex()
+		}
+		ctx.Header("Content-Disposition", "attachment; filename=\""+filename+".zip\"")
+		ctx.Data(http.StatusOK, "application/zip", archive.Bytes())
+	}
+}
+
+// RecordSetDependents lists the resources that prevent a record set from
+// being deleted.
+type RecordSetDependents struct {
+	Message         string          `json:"message"`
+	RecordMatchRuns []bson.ObjectId `json:"recordMatchRuns"`
+}
+
+// DeleteRecordSetResult summarizes what was removed by a cascading delete of
+// a record set.
+type DeleteRecordSetResult struct {
+	RecordsDeleted            int `json:"recordsDeleted"`
+	RecordsUntagged           int `json:"recordsUntagged"`
+	RecordMatchRunsDeleted    int `json:"recordMatchRunsDeleted"`
+	RecordSetSnapshotsDeleted int `json:"recordSetSnapshotsDeleted"`
+	ImportJobsDeleted         int `json:"importJobsDeleted"`
+}
+
+// DeleteRecordSetHandler creates a HandlerFunc that deletes a record set and
+// its answer key. A record set referenced by record match runs is not deleted
+// unless cascade=true is requested. A cascading delete also removes the
+// tagged members of the record set from the FHIR server, along with its
+// import jobs, and removes the dependent runs (and the record set snapshots
+// they refer to) if deleteRuns=true is requested.
+func DeleteRecordSetHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		recSet, ok := loadRecordSet(ctx, db)
+		if !ok {
+			return
+		}
+
+		var runs []struct {
+			ID bson.ObjectId `bson:"_id"`
+		}
+		runQuery := bson.M{"$or": []bson.M{
+			bson.M{"masterRecordSetId": recSet.ID}, bson.M{"queryRecordSetId": recSet.ID}}}
+		err := db.C(ptm_models.GetCollectionName("RecordMatchRun")).Find(runQuery).Select(bson.M{"_id": 1}).All(&runs)
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		runIDs := make([]bson.ObjectId, len(runs))
+		for i, run := range runs {
+			runIDs[i] = run.ID
+		}
+
+		if ctx.Query("cascade") != "true" {
+			if len(runIDs) > 0 {
+				ctx.JSON(http.StatusConflict, RecordSetDependents{
+					Message:         "The record set is referenced by record match runs; use cascade=true to delete it",
+					RecordMatchRuns: runIDs})
+				return
+			}
+			if err = db.C(ptm_models.GetCollectionName("RecordSet")).RemoveId(recSet.ID); err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+			ctx.Status(http.StatusNoContent)
+			return
+		}
+
+		result := DeleteRecordSetResult{}
+		// the members are removed first, so that the delete can be retried
+		// if the FHIR server fails part way through
+		if recSet.Parameter("_tag") != "" {
+			members, err := ptm_models.LoadRecordSetMembers(recSet)
+			if err == nil {
+				result.RecordsDeleted, result.RecordsUntagged, err = ptm_models.RemoveRecordSetMembers(recSet, members)
+			}
+			if err != nil {
+				logger.Log.WithFields(
+					logrus.Fields{"method": "DeleteRecordSet", "record set": recSet.ID, "err": err}).Warn("Unable to remove record set members")
+				ctx.AbortWithError(http.StatusBadGateway, err)
+				return
+			}
+		}
+
+		deleteRuns := ctx.Query("deleteRuns") == "true"
+		removals := []struct {
+			resourceType string
+			selector     bson.M
+			count        *int
+			remove       bool
+		}{
+			{"RecordMatchRun", bson.M{"_id": bson.M{"$in": runIDs}}, &result.RecordMatchRunsDeleted, deleteRuns && len(runIDs) > 0},
+			// snapshots document the records seen by runs, so they are kept
+			// as long as the runs are
+			{"RecordSetSnapshot", bson.M{"recordSetId": recSet.ID}, &result.RecordSetSnapshotsDeleted, deleteRuns || len(runIDs) == 0},
+			{"ImportJob", bson.M{"recordSetId": recSet.ID}, &result.ImportJobsDeleted, true},
+		}
+		for _, removal := range removals {
+			if !removal.remove {
+				continue
+			}
+			info, err := db.C(ptm_models.GetCollectionName(removal.resourceType)).RemoveAll(removal.selector)
+			if err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+			*removal.count = info.Removed
+		}
+
+		if err = db.C(ptm_models.GetCollectionName("RecordSet")).RemoveId(recSet.ID); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		logger.Log.WithFields(
+			logrus.Fields{"method": "DeleteRecordSet", "record set": recSet.ID,
+				"records deleted":  result.RecordsDeleted,
+				"records untagged": result.RecordsUntagged,
+				"runs deleted":     result.RecordMatchRunsDeleted}).Info("Deleted record set")
+
+		ctx.JSON(http.StatusOK, result)
+	}
+}
+
+// loadRecordSet retrieves the record set identified in the request path.
+// If the record set cannot be loaded, an error response is written and
+// false is returned.
+func loadRecordSet(ctx *gin.Context, db *mgo.Database) (*ptm_models.RecordSet, bool) {
+	id, err := toBsonObjectID(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	obj, err := ptm_models.LoadResource(db, "RecordSet", id)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			ctx.String(http.StatusNotFound, "Not Found")
+			ctx.Abort()
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return nil, false
+	}
+	return obj.(*ptm_models.RecordSet), true
+}
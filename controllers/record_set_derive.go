@@ -0,0 +1,392 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	fhir_models "github.com/intervention-engine/fhir/models"
+
+	"github.com/mitre/ptmatch/client"
+	logger "github.com/mitre/ptmatch/logger"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// DeriveRecordSetRequest holds the settings used to derive new record sets
+// from existing ones. Only the settings that apply to the operation are used.
+type DeriveRecordSetRequest struct {
+	// name of the derived record set (the train partition of a split)
+	Name string `json:"name"`
+	// number of records in a sample
+	Count int `json:"count"`
+	// name of the test partition of a split
+	TestName string `json:"testName"`
+	// fraction of the records placed in the test partition of a split
+	TestFraction float64 `json:"testFraction"`
+	// seed of the random choices; a seed is picked and recorded if zero
+	Seed int64 `json:"seed"`
+	// the record sets combined with the one in the request path
+	RecordSetIDs []string `json:"recordSetIds"`
+	// secret key of a pseudonymization, which is not stored
+	Key string `json:"key"`
+}
+
+// minPseudonymKeyLength is the shortest secret key accepted for
+// pseudonymizing a record set.
+const minPseudonymKeyLength = 16
+
+// SplitRecordSetResult holds the record sets created by a train/test split.
+type SplitRecordSetResult struct {
+	Train *ptm_models.RecordSet `json:"train"`
+	Test  *ptm_models.RecordSet `json:"test"`
+}
+
+// derivation holds a record set, its members and answer key pairs while
+// record sets are derived from it.
+type derivation struct {
+	recSet  *ptm_models.RecordSet
+	members []fhir_models.BundleEntryComponent
+	pairs   []ptm_models.RecordPair
+}
+
+// SampleRecordSetHandler creates a HandlerFunc that creates a record set from
+// a random sample of the members of an existing record set.
+func SampleRecordSetHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		req, source, ok := prepDerivation(ctx, db)
+		if !ok {
+			return
+		}
+		if req.Count <= 0 {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("A positive count is required"))
+			return
+		}
+
+		urls := ptm_models.SampleRecords(ptm_models.MemberURLs(source.members), req.Count, rand.New(rand.NewSource(req.Seed)))
+		lineage := &ptm_models.Lineage{Operation: ptm_models.DeriveSample,
+			Sources: []bson.ObjectId{source.recSet.ID}, SampleSize: req.Count, Seed: req.Seed}
+
+		recSet, err := createDerivedRecordSet(db, source, req.Name, lineage, urls)
+		if err != nil {
+			abortDerivation(ctx, err)
+			return
+		}
+		ctx.Header("Location", responseURL(ctx.Request, "RecordSet", recSet.ID.Hex()).String())
+		ctx.JSON(http.StatusCreated, recSet)
+	}
+}
+
+// SplitRecordSetHandler creates a HandlerFunc that splits the members of an
+// existing record set into train and test record sets. Records in the same
+// answer key cluster are kept in the same partition.
+func SplitRecordSetHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		req, source, ok := prepDerivation(ctx, db)
+		if !ok {
+			return
+		}
+		if req.TestName == "" || ptm_models.RecordSetTagCode(req.TestName) == ptm_models.RecordSetTagCode(req.Name) {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("A testName, distinct from the name, is required"))
+			return
+		}
+		if req.TestFraction <= 0 || req.TestFraction >= 1 {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("testFraction must be between 0 and 1"))
+			return
+		}
+		if !checkRecordSetName(ctx, db, req.TestName) {
+			return
+		}
+
+		train, test := ptm_models.SplitRecords(ptm_models.MemberURLs(source.members), source.pairs,
+			req.TestFraction, rand.New(rand.NewSource(req.Seed)))
+
+		result := SplitRecordSetResult{}
+		var err error
+		for _, partition := range []struct {
+			name   string
+			label  string
+			urls   []string
+			recSet **ptm_models.RecordSet
+		}{{req.Name, "train", train, &result.Train}, {req.TestName, "test", test, &result.Test}} {
+			lineage := &ptm_models.Lineage{Operation: ptm_models.DeriveSplit,
+				Sources: []bson.ObjectId{source.recSet.ID}, TestFraction: req.TestFraction,
+				Partition: partition.label, Seed: req.Seed}
+			*partition.recSet, err = createDerivedRecordSet(db, source, partition.name, lineage, partition.urls)
+			if err != nil {
+				// don't leave a train partition without its test partition
+				if result.Train != nil {
+					removeDerivedRecordSet(db, source, result.Train, train)
+				}
+				abortDerivation(ctx, err)
+				return
+			}
+		}
+		ctx.JSON(http.StatusCreated, result)
+	}
+}
+
+// CombineRecordSetsHandler creates a HandlerFunc that creates a record set
+// holding the union or intersection (depending on the operation) of the
+// members of the record set in the request path and the record sets listed
+// in the request. The record sets must hold the same type of resource on the
+// same FHIR server.
+func CombineRecordSetsHandler(provider func() *mgo.Database, operation string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		req, first, ok := prepDerivation(ctx, db)
+		if !ok {
+			return
+		}
+		if len(req.RecordSetIDs) == 0 {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("At least one other record set is required"))
+			return
+		}
+
+		sources := []*derivation{first}
+		for _, idString := range req.RecordSetIDs {
+			id, err := toBsonObjectID(idString)
+			if err != nil {
+				ctx.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+			obj, err := ptm_models.LoadResource(db, "RecordSet", id)
+			if err != nil {
+				if err == mgo.ErrNotFound {
+					ctx.AbortWithError(http.StatusBadRequest, errors.New("Unknown record set: "+idString))
+				} else {
+					ctx.AbortWithError(http.StatusInternalServerError, err)
+				}
+				return
+			}
+			recSet := obj.(*ptm_models.RecordSet)
+			if recSet.ResourceType != first.recSet.ResourceType || recSet.BaseURL() != first.recSet.BaseURL() {
+				ctx.AbortWithError(http.StatusBadRequest,
+					errors.New("Record sets must hold the same type of resource on the same server"))
+				return
+			}
+			source, err := loadDerivationSource(recSet)
+			if err != nil {
+				abortDerivation(ctx, err)
+				return
+			}
+			sources = append(sources, source)
+		}
+
+		combined := &derivation{recSet: first.recSet}
+		sets := make([][]string, len(sources))
+		lineage := &ptm_models.Lineage{Operation: operation}
+		for i, source := range sources {
+			sets[i] = ptm_models.MemberURLs(source.members)
+			combined.members = append(combined.members, source.members...)
+			combined.pairs = append(combined.pairs, source.pairs...)
+			lineage.Sources = append(lineage.Sources, source.recSet.ID)
+		}
+
+		var urls []string
+		if operation == ptm_models.DeriveIntersection {
+			urls = ptm_models.IntersectRecords(sets...)
+		} else {
+			urls = ptm_models.UnionRecords(sets...)
+		}
+
+		recSet, err := createDerivedRecordSet(db, combined, req.Name, lineage, urls)
+		if err != nil {
+			abortDerivation(ctx, err)
+			return
+		}
+		ctx.Header("Location", responseURL(ctx.Request, "RecordSet", recSet.ID.Hex()).String())
+		ctx.JSON(http.StatusCreated, recSet)
+	}
+}
+
+// PseudonymizeRecordSetHandler creates a HandlerFunc that creates a record set
+// of new Patients in which the direct identifiers of the members of an
+// existing record set are replaced using a keyed, deterministic mapping. The
+// answer key of the new record set refers to the new Patients. Values are as
+// many edits apart after pseudonymization as before, so matching difficulty
+// is preserved; see ptm_models.Pseudonymizer.
+func PseudonymizeRecordSetHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		db := provider()
+		req, source, ok := prepDerivation(ctx, db)
+		if !ok {
+			return
+		}
+		if len(req.Key) < minPseudonymKeyLength {
+			ctx.AbortWithError(http.StatusBadRequest,
+				fmt.Errorf("A key of at least %d characters is required", minPseudonymKeyLength))
+			return
+		}
+		for _, member := range source.members {
+			if _, ok := member.Resource.(*fhir_models.Patient); !ok {
+				ctx.AbortWithError(http.StatusBadRequest, errors.New("Only Patient records can be pseudonymized"))
+				return
+			}
+		}
+
+		recSet := ptm_models.NewTaggedRecordSet(source.recSet.BaseURL(), req.Name, "Patient")
+		recSet.Meta = &ptm_models.Meta{Lineage: &ptm_models.Lineage{Operation: ptm_models.DerivePseudonymize,
+			Sources: []bson.ObjectId{source.recSet.ID}}}
+		tag := recSet.TagCoding()
+
+		pseudonymizer := ptm_models.NewPseudonymizer(req.Key)
+		fhirClient := client.New(recSet.BaseURL())
+		urls := make(map[string]string)
+		for _, member := range source.members {
+			patient := pseudonymizer.Patient(member.Resource.(*fhir_models.Patient))
+			patient.Meta = &fhir_models.Meta{Tag: []fhir_models.Coding{tag}}
+			location, err := fhirClient.CreateResource("Patient", patient)
+			if err != nil {
+				abortDerivation(ctx, err)
+				return
+			}
+			urls[member.FullUrl] = location
+		}
+		recSet.AnswerKey = *ptm_models.NewAnswerKey(recSet, ptm_models.RewritePairs(source.pairs, urls))
+
+		if _, err := ptm_models.PersistResource(db, "RecordSet", recSet); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		logger.Log.WithFields(
+			logrus.Fields{"method": "PseudonymizeRecordSet", "record set": recSet.ID,
+				"source": source.recSet.ID, "members": len(urls)}).Info("Pseudonymized record set created")
+
+		ctx.Header("Location", responseURL(ctx.Request, "RecordSet", recSet.ID.Hex()).String())
+		ctx.JSON(http.StatusCreated, recSet)
+	}
+}
+
+// prepDerivation binds the request and loads the source record set from the
+// request path, writing an error response if either is invalid.
+func prepDerivation(ctx *gin.Context, db *mgo.Database) (*DeriveRecordSetRequest, *derivation, bool) {
+	recSet, ok := loadRecordSet(ctx, db)
+	if !ok {
+		return nil, nil, false
+	}
+
+	req := &DeriveRecordSetRequest{}
+	if err := ctx.BindJSON(req); err != nil {
+		return nil, nil, false
+	}
+	if req.Name == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("A name is required for the derived record set"))
+		return nil, nil, false
+	}
+	if req.Seed == 0 {
+		req.Seed = time.Now().UnixNano()
+	}
+	if !checkRecordSetName(ctx, db, req.Name) {
+		return nil, nil, false
+	}
+
+	source, err := loadDerivationSource(recSet)
+	if err != nil {
+		abortDerivation(ctx, err)
+		return nil, nil, false
+	}
+	return req, source, true
+}
+
+func loadDerivationSource(recSet *ptm_models.RecordSet) (*derivation, error) {
+	members, err := ptm_models.LoadRecordSetMembers(recSet)
+	if err != nil {
+		return nil, err
+	}
+	return &derivation{recSet: recSet, members: members,
+		pairs: ptm_models.AnswerKeyPairs(&recSet.AnswerKey)}, nil
+}
+
+// checkRecordSetName ensures that no record set has a name with the same tag
+// code, since members of the derived record set are found by their tag.
+func checkRecordSetName(ctx *gin.Context, db *mgo.Database, name string) bool {
+	c := db.C(ptm_models.GetCollectionName("RecordSet"))
+	count, err := c.Find(bson.M{"parameters.parameter": bson.M{"$elemMatch": bson.M{
+		"name": "_tag", "valueString": ptm_models.RecordSetTagCode(name)}}}).Count()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return false
+	}
+	if count > 0 {
+		ctx.AbortWithError(http.StatusConflict, errors.New("A record set with the tag of "+name+" already exists"))
+		return false
+	}
+	return true
+}
+
+// createDerivedRecordSet tags the chosen members of the source and saves the
+// derived record set, whose answer key holds the source pairs between them.
+// If it fails, no member is left with the tag: TagRecordSetMembers removes
+// the tag from the members tagged before a failure.
+func createDerivedRecordSet(db *mgo.Database, source *derivation, name string,
+	lineage *ptm_models.Lineage, urls []string) (*ptm_models.RecordSet, error) {
+	recSet := ptm_models.NewDerivedRecordSet(source.recSet, name, lineage, urls, source.pairs)
+	members := ptm_models.FilterMembers(source.members, urls)
+
+	if err := ptm_models.TagRecordSetMembers(recSet.TagCoding(), members); err != nil {
+		return nil, err
+	}
+
+	if _, err := ptm_models.PersistResource(db, "RecordSet", recSet); err != nil {
+		// don't leave tagged records that belong to no record set
+		if uerr := ptm_models.UntagRecordSetMembers(recSet.TagCoding(), members); uerr != nil {
+			logger.Log.WithFields(
+				logrus.Fields{"method": "createDerivedRecordSet", "err": uerr}).Warn("Unable to remove tag after failure")
+		}
+		return nil, err
+	}
+
+	logger.Log.WithFields(
+		logrus.Fields{"method": "createDerivedRecordSet", "record set": recSet.ID,
+			"operation": lineage.Operation, "sources": lineage.Sources,
+			"members": len(urls)}).Info("Derived record set created")
+	return recSet, nil
+}
+
+// removeDerivedRecordSet undoes createDerivedRecordSet, given the same
+// members, removing the tag of the derived record set from them and deleting
+// it. Failures are logged, since they follow the error that is reported.
+func removeDerivedRecordSet(db *mgo.Database, source *derivation, recSet *ptm_models.RecordSet, urls []string) {
+	if err := ptm_models.UntagRecordSetMembers(recSet.TagCoding(), ptm_models.FilterMembers(source.members, urls)); err != nil {
+		logger.Log.WithFields(
+			logrus.Fields{"method": "removeDerivedRecordSet", "record set": recSet.ID, "err": err}).Warn("Unable to remove tag after failure")
+	}
+	if err := db.C(ptm_models.GetCollectionName("RecordSet")).RemoveId(recSet.ID); err != nil {
+		logger.Log.WithFields(
+			logrus.Fields{"method": "removeDerivedRecordSet", "record set": recSet.ID, "err": err}).Warn("Unable to delete record set after failure")
+	}
+}
+
+// abortDerivation reports an error resolving or tagging the members of a
+// record set, which are held on the FHIR server.
+func abortDerivation(ctx *gin.Context, err error) {
+	logger.Log.WithFields(
+		logrus.Fields{"method": "abortDerivation", "err": err}).Warn("Unable to derive record set")
+	ctx.AbortWithError(http.StatusBadGateway, err)
+}
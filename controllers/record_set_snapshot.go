@@ -0,0 +1,178 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"net/http"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	fhir_models "github.com/intervention-engine/fhir/models"
+
+	logger "github.com/mitre/ptmatch/logger"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// RecordSetSnapshotDiffs reports the differences between the record sets
+// processed by two record match runs.
+type RecordSetSnapshotDiffs struct {
+	Master *ptm_models.RecordSetSnapshotDiff `json:"master,omitempty"`
+	Query  *ptm_models.RecordSetSnapshotDiff `json:"query,omitempty"`
+}
+
+// snapshotRecordSets validates the record sets used by the run and
+// associates a snapshot of the members of each with the run. If a record set
+// can't be found, or its search expression fails or selects no records or
+// records of mixed types, a *runError is returned, since the record matcher
+// would be unable to process the run.
+func snapshotRecordSets(db *mgo.Database, recMatchRun *ptm_models.RecordMatchRun) error {
+	snapshot, err := validateAndSnapshotRecordSet(db, recMatchRun.MasterRecordSetID)
+	if err != nil {
+		return err
+	}
+	recMatchRun.MasterRecordSetSnapshotID = snapshot.ID
+
+	if recMatchRun.MatchingMode == ptm_models.Query {
+		snapshot, err = validateAndSnapshotRecordSet(db, recMatchRun.QueryRecordSetID)
+		if err != nil {
+			return err
+		}
+		recMatchRun.QueryRecordSetSnapshotID = snapshot.ID
+	}
+	return nil
+}
+
+func validateAndSnapshotRecordSet(db *mgo.Database, recSetID bson.ObjectId) (*ptm_models.RecordSetSnapshot, error) {
+	recSet, members, err := loadValidRecordSet(db, recSetID)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotRecordSet(db, recSet, members)
+}
+
+// loadValidRecordSet loads a record set and the members it selects. A
+// *runError is returned if the record set can't be found or is invalid.
+func loadValidRecordSet(db *mgo.Database, recSetID bson.ObjectId) (*ptm_models.RecordSet, []fhir_models.BundleEntryComponent, error) {
+	obj, err := ptm_models.LoadResource(db, "RecordSet", recSetID)
+	if err != nil {
+		return nil, nil, &runError{Status: http.StatusBadRequest, Message: "Unable to find Record Set " + recSetID.Hex()}
+	}
+	recSet := obj.(*ptm_models.RecordSet)
+
+	validation, members := ptm_models.ValidateRecordSet(recSet)
+	if !validation.Valid {
+		logger.Log.WithFields(
+			logrus.Fields{"record set": recSetID, "problems": validation.Problems}).Warn("Invalid record set")
+		return nil, nil, &runError{Status: http.StatusBadRequest, Message: "Invalid Record Set " + recSetID.Hex(),
+			Validation: validation}
+	}
+	return recSet, members, nil
+}
+
+// snapshotRecordSet persists a snapshot of the given members of the record
+// set. If the members are unchanged since the most recent snapshot of the
+// set, that snapshot is returned instead.
+func snapshotRecordSet(db *mgo.Database, recSet *ptm_models.RecordSet, members []fhir_models.BundleEntryComponent) (*ptm_models.RecordSetSnapshot, error) {
+	snapshot := ptm_models.NewRecordSetSnapshot(recSet, members)
+
+	c := db.C(ptm_models.GetCollectionName("RecordSetSnapshot"))
+	latest := &ptm_models.RecordSetSnapshot{}
+	err := c.Find(bson.M{"recordSetId": recSet.ID}).Sort("-meta.createdOn").Select(bson.M{"members": 0}).One(latest)
+	if err == nil && latest.Hash == snapshot.Hash {
+		logger.Log.WithFields(
+			logrus.Fields{"record set": recSet.ID, "snapshot": latest.ID}).Info("Record set unchanged; reusing snapshot")
+		return latest, nil
+	}
+
+	_, err = ptm_models.PersistResource(db, "RecordSetSnapshot", snapshot)
+	if err != nil {
+		return nil, err
+	}
+	logger.Log.WithFields(
+		logrus.Fields{"record set": recSet.ID, "snapshot": snapshot.ID, "count": snapshot.Count}).Info("Record set snapshot created")
+	return snapshot, nil
+}
+
+// GetRecordSetSnapshotDiffHandler creates a HandlerFunc that compares the
+// record set snapshots of a record match run with those of the run
+// identified by the "other" query parameter. The other run is treated as
+// the earlier of the two.
+func GetRecordSetSnapshotDiffHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		runID, err := toBsonObjectID(ctx.Param("id"))
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		otherID, err := toBsonObjectID(ctx.Query("other"))
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		db := provider()
+		var runs [2]*ptm_models.RecordMatchRun
+		for i, id := range []bson.ObjectId{otherID, runID} {
+			obj, err := ptm_models.LoadResource(db, "RecordMatchRun", id)
+			if err != nil {
+				ctx.String(http.StatusNotFound, "Record Match Run Not Found: "+id.Hex())
+				ctx.Abort()
+				return
+			}
+			runs[i] = obj.(*ptm_models.RecordMatchRun)
+		}
+
+		diffs := RecordSetSnapshotDiffs{}
+		diffs.Master, err = diffSnapshots(db,
+			runs[0].MasterRecordSetSnapshotID, runs[1].MasterRecordSetSnapshotID)
+		if err == nil {
+			diffs.Query, err = diffSnapshots(db,
+				runs[0].QueryRecordSetSnapshotID, runs[1].QueryRecordSetSnapshotID)
+		}
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if diffs.Master == nil {
+			ctx.String(http.StatusNotFound, "No Record Set Snapshots Associated with Runs")
+			ctx.Abort()
+			return
+		}
+
+		ctx.JSON(http.StatusOK, diffs)
+	}
+}
+
+// diffSnapshots compares two snapshots. A nil diff is returned if either
+// snapshot identifier is not set.
+func diffSnapshots(db *mgo.Database, fromID, toID bson.ObjectId) (*ptm_models.RecordSetSnapshotDiff, error) {
+	if !fromID.Valid() || !toID.Valid() {
+		return nil, nil
+	}
+	from, err := ptm_models.LoadResource(db, "RecordSetSnapshot", fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := ptm_models.LoadResource(db, "RecordSetSnapshot", toID)
+	if err != nil {
+		return nil, err
+	}
+	return ptm_models.DiffSnapshots(from.(*ptm_models.RecordSetSnapshot), to.(*ptm_models.RecordSetSnapshot)), nil
+}
@@ -40,7 +40,10 @@ import (
 )
 
 var SearchParams = map[string][]string{
-	"RecordMatchRun": []string{"recordMatchContextId"},
+	"ImportJob":         []string{"recordSetId", "status"},
+	"OutboxMessage":     []string{"recordMatchRunId", "status"},
+	"RecordMatchRun":    []string{"recordMatchContextId", "state", "rerunOfId", "benchmarkSuiteId", "benchmarkExecutionId", "runScheduleId"},
+	"RecordSetSnapshot": []string{"recordSetId"},
 }
 
 type ResourceController struct {
@@ -0,0 +1,205 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+
+	logger "github.com/mitre/ptmatch/logger"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+const runSchedulePollInterval = 30 * time.Second
+
+// CreateRunScheduleHandler creates a HandlerFunc that creates a RunSchedule,
+// after checking its schedule and template.
+func CreateRunScheduleHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		rs := &ptm_models.RunSchedule{}
+		if err := ctx.Bind(rs); err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		rs.History = nil
+		if !prepRunSchedule(ctx, rs) {
+			return
+		}
+
+		if _, err := ptm_models.PersistResource(provider(), "RunSchedule", rs); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		ctx.Header("Location", responseURL(ctx.Request, "RunSchedule", rs.ID.Hex()).String())
+		ctx.JSON(http.StatusCreated, rs)
+	}
+}
+
+// UpdateRunScheduleHandler creates a HandlerFunc that replaces a RunSchedule.
+// The history of the schedule is kept.
+func UpdateRunScheduleHandler(provider func() *mgo.Database) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := toBsonObjectID(ctx.Param("id"))
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		db := provider()
+		obj, err := ptm_models.LoadResource(db, "RunSchedule", id)
+		if err == mgo.ErrNotFound {
+			ctx.String(http.StatusNotFound, "Run Schedule not found")
+			ctx.Abort()
+			return
+		} else if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		existing := obj.(*ptm_models.RunSchedule)
+
+		rs := &ptm_models.RunSchedule{}
+		if err = ctx.Bind(rs); err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		rs.ID, rs.Meta, rs.History = existing.ID, existing.Meta, existing.History
+		if !prepRunSchedule(ctx, rs) {
+			return
+		}
+		ptm_models.UpdateLastUpdatedDate(rs)
+		if err = db.C(ptm_models.GetCollectionName("RunSchedule")).UpdateId(id, rs); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		ctx.Header("Location", responseURL(ctx.Request, "RunSchedule", id.Hex()).String())
+		ctx.JSON(http.StatusOK, rs)
+	}
+}
+
+// prepRunSchedule checks the schedule and template and works out when the
+// first run is due. If the schedule is invalid, an error response is written
+// and false is returned.
+func prepRunSchedule(ctx *gin.Context, rs *ptm_models.RunSchedule) bool {
+	if !isValidRecordMatchRun(&rs.Template) {
+		ctx.String(http.StatusBadRequest, "Invalid RecordMatchRun template")
+		ctx.Abort()
+		return false
+	}
+	next, err := rs.NextRun(time.Now())
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid schedule: "+err.Error())
+		ctx.Abort()
+		return false
+	}
+	if next.IsZero() {
+		ctx.String(http.StatusBadRequest, "Invalid schedule: "+rs.Schedule+" never occurs")
+		ctx.Abort()
+		return false
+	}
+	rs.NextRunOn = next
+	return true
+}
+
+// StartRunScheduler starts a background worker that creates and dispatches
+// the runs of RunSchedules as they come due.
+func StartRunScheduler(provider func() *mgo.Database) {
+	go func() {
+		ticker := time.NewTicker(runSchedulePollInterval)
+		defer ticker.Stop()
+		for {
+			runDueSchedules(provider)
+			<-ticker.C
+		}
+	}()
+}
+
+// runDueSchedules creates a run for each schedule that is due. If the server
+// was down when a schedule came due, one run is created for the missed
+// occurrences. Each occurrence is claimed before its run is created, so that
+// only one run is created for it.
+func runDueSchedules(provider func() *mgo.Database) {
+	db := provider()
+	if db == nil {
+		return
+	}
+	session := db.Session.Copy()
+	defer session.Close()
+	db = session.DB(db.Name)
+	c := db.C(ptm_models.GetCollectionName("RunSchedule"))
+
+	now := time.Now().Round(time.Millisecond)
+	var schedules []ptm_models.RunSchedule
+	err := c.Find(bson.M{"paused": bson.M{"$ne": true}, "nextRunOn": bson.M{"$lte": now}}).
+		Select(bson.M{"history": 0}).All(&schedules)
+	if err != nil {
+		logger.Log.WithFields(
+			logrus.Fields{"method": "runDueSchedules", "err": err}).Warn("Unable to find due schedules")
+		return
+	}
+
+	for i := range schedules {
+		rs := &schedules[i]
+		claim := bson.M{"$unset": bson.M{"nextRunOn": ""}}
+		if next, err := rs.NextRun(now); err == nil && !next.IsZero() {
+			claim = bson.M{"$set": bson.M{"nextRunOn": next}}
+		}
+		err = c.Update(bson.M{"_id": rs.ID, "nextRunOn": rs.NextRunOn}, claim)
+		if err == mgo.ErrNotFound {
+			// claimed by another worker, or changed since it was found
+			continue
+		} else if err != nil {
+			logger.Log.WithFields(
+				logrus.Fields{"method": "runDueSchedules", "schedule": rs.ID, "err": err}).Warn("Unable to claim schedule")
+			continue
+		}
+
+		entry := ptm_models.ScheduledRun{ScheduledFor: rs.NextRunOn, CreatedOn: now}
+		run, err := createScheduledRun(db, rs)
+		if err != nil {
+			entry.Error = err.Error()
+			logger.Log.WithFields(
+				logrus.Fields{"method": "runDueSchedules", "schedule": rs.ID, "err": err}).Warn("Unable to create scheduled run")
+		} else {
+			entry.RecordMatchRunID = run.ID
+			logger.Log.WithFields(
+				logrus.Fields{"method": "runDueSchedules", "schedule": rs.ID, "run": run.ID}).Info("Created scheduled run")
+		}
+		err = c.UpdateId(rs.ID, bson.M{"$push": bson.M{"history": bson.M{
+			"$each":  []ptm_models.ScheduledRun{entry},
+			"$slice": -ptm_models.MaxRunScheduleHistory}}})
+		if err != nil {
+			logger.Log.WithFields(
+				logrus.Fields{"method": "runDueSchedules", "schedule": rs.ID, "err": err}).Warn("Unable to record schedule history")
+		}
+	}
+}
+
+func createScheduledRun(db *mgo.Database, rs *ptm_models.RunSchedule) (*ptm_models.RecordMatchRun, error) {
+	run := rs.NewRun()
+	run.Status = []ptm_models.RecordMatchRunStatusComponent{
+		{Message: "Scheduled by [" + rs.ID.Hex() + "]", CreatedOn: time.Now()}}
+	recMatchSysIface, err := prepRecordMatchRequest(db, run)
+	if err != nil {
+		return nil, err
+	}
+	return run, storeAndQueueRecordMatchRun(db, run, recMatchSysIface)
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/Sirupsen/logrus"
+
+	logger "github.com/mitre/ptmatch/logger"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+const runTimeoutSweepInterval = 30 * time.Second
+
+// StartRunTimeoutSweeper starts a background worker that times out runs
+// whose record matcher hasn't responded by the run's response deadline.
+func StartRunTimeoutSweeper(provider func() *mgo.Database) {
+	go func() {
+		ticker := time.NewTicker(runTimeoutSweepInterval)
+		defer ticker.Stop()
+		for {
+			sweepTimedOutRuns(provider)
+			<-ticker.C
+		}
+	}()
+}
+
+// sweepTimedOutRuns moves each run that is waiting for responses past its
+// deadline to the timed-out state. Runs that received some results are
+// flagged as partial.
+func sweepTimedOutRuns(provider func() *mgo.Database) {
+	db := provider()
+	if db == nil {
+		return
+	}
+	session := db.Session.Copy()
+	defer session.Close()
+	db = session.DB(db.Name)
+
+	now := time.Now().Round(time.Millisecond)
+	var runs []ptm_models.RecordMatchRun
+	err := db.C(ptm_models.GetCollectionName("RecordMatchRun")).Find(bson.M{
+		"state":            bson.M{"$in": []string{ptm_models.RunSent, ptm_models.RunAcknowledged, ptm_models.RunResponding}},
+		"responseDeadline": bson.M{"$lte": now}}).
+		Select(bson.M{"responseDeadline": 1, "responses.kind": 1}).All(&runs)
+	if err != nil {
+		logger.Log.WithFields(
+			logrus.Fields{"method": "sweepTimedOutRuns", "err": err}).Warn("Unable to find overdue runs")
+		return
+	}
+
+	for _, run := range runs {
+		// acknowledgements and errors don't make the response partial
+		results := 0
+		for _, resp := range run.Responses {
+			if resp.Kind == ptm_models.ResponseResults {
+				results++
+			}
+		}
+		partial := results > 0
+		msg := fmt.Sprintf("Timed Out [no results by %s]", run.ResponseDeadline.Format(time.RFC3339))
+		if partial {
+			msg = fmt.Sprintf("Timed Out [%d result messages by %s]", results, run.ResponseDeadline.Format(time.RFC3339))
+		}
+		err = ptm_models.TransitionRun(db, run.ID, ptm_models.RunTimedOut, msg,
+			bson.M{"timedOutOn": now, "partialResponse": partial})
+		if _, ok := err.(*ptm_models.RunTransitionError); ok {
+			// a response arrived or the run was cancelled since the query
+			continue
+		}
+		if err != nil {
+			logger.Log.WithFields(
+				logrus.Fields{"method": "sweepTimedOutRuns", "run": run.ID, "err": err}).Warn("Unable to time out run")
+			continue
+		}
+		logger.Log.WithFields(
+			logrus.Fields{"method": "sweepTimedOutRuns", "run": run.ID, "partial": partial}).Info("Run timed out")
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+func (s *ServerSuite) TestSweepTimedOutRuns(c *C) {
+	deadline := time.Now().Add(-time.Minute)
+	acked := &ptm_models.RecordMatchRun{State: ptm_models.RunAcknowledged, ResponseDeadline: deadline,
+		Responses: []ptm_models.RecordMatchResponse{{Kind: ptm_models.ResponseAcknowledgement}}}
+	responding := &ptm_models.RecordMatchRun{State: ptm_models.RunResponding, ResponseDeadline: deadline,
+		Responses: []ptm_models.RecordMatchResponse{{Kind: ptm_models.ResponseAcknowledgement},
+			{Kind: ptm_models.ResponseResults}}}
+	waiting := &ptm_models.RecordMatchRun{State: ptm_models.RunSent, ResponseDeadline: time.Now().Add(time.Hour)}
+	for _, run := range []*ptm_models.RecordMatchRun{acked, responding, waiting} {
+		_, err := ptm_models.PersistResource(database, "RecordMatchRun", run)
+		c.Assert(err, IsNil)
+	}
+
+	sweepTimedOutRuns(func() *mgo.Database { return database })
+
+	// an acknowledgement alone isn't a partial response
+	for _, expected := range []struct {
+		run     *ptm_models.RecordMatchRun
+		state   string
+		partial bool
+	}{
+		{acked, ptm_models.RunTimedOut, false},
+		{responding, ptm_models.RunTimedOut, true},
+		{waiting, ptm_models.RunSent, false},
+	} {
+		obj, err := ptm_models.LoadResource(database, "RecordMatchRun", expected.run.ID)
+		c.Assert(err, IsNil)
+		run := obj.(*ptm_models.RecordMatchRun)
+		c.Assert(run.State, Equals, expected.state)
+		c.Assert(run.PartialResponse, Equals, expected.partial)
+	}
+}
@@ -50,3 +50,44 @@ func Put(url string, bodyType string, body io.Reader) (resp *http.Response, err
 
 	return HTTPClient.Do(req)
 }
+
+// Get issues a GET to the specified URL, requesting a FHIR JSON response.
+//
+// Caller should close resp.Body when done reading from it.
+func Get(url string) (resp *http.Response, err error) {
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json+fhir")
+
+	return HTTPClient.Do(req)
+}
+
+// Post issues a POST to the specified URL.
+//
+// Caller should close resp.Body when done reading from it.
+func Post(url string, bodyType string, body io.Reader) (resp *http.Response, err error) {
+
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", bodyType)
+
+	return HTTPClient.Do(req)
+}
+
+// Delete issues a DELETE to the specified URL.
+//
+// Caller should close resp.Body when done reading from it.
+func Delete(url string) (resp *http.Response, err error) {
+
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return HTTPClient.Do(req)
+}
@@ -65,6 +65,7 @@ func main() {
 
 	ar := func(e *gin.Engine) {
 		server.Setup(e)
+		server.StartWorkers()
 
 		if *assetPath != "" {
 			e.StaticFile("/", fmt.Sprintf("%s/index.html", *assetPath))
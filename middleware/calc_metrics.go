@@ -79,26 +79,9 @@ func calcMetrics(db *mgo.Database, recMatchRun *ptm_models.RecordMatchRun,
 								"search":   score}).Info("calcMetrics")
 							// if we have an answer key to compare against
 							if answerKey != nil {
-								truth := answerMap[refURL]
-								if truth != nil {
-									// look for linked URL in array of known linked records
-									idx := indexOf(truth.linkedURLs, linkedURL)
-									if idx >= 0 {
-										truePositiveCount++
-										truth.numFound[idx]++
-									} else {
-										falsePositiveCount++
-									}
-								} else if answerMap[linkedURL] != nil {
-									truth := answerMap[linkedURL]
-									// look for reference URL in array of known linked records
-									idx := indexOf(truth.linkedURLs, refURL)
-									if idx >= 0 {
-										truePositiveCount++
-										truth.numFound[idx]++
-									} else {
-										falsePositiveCount++
-									}
+								if truth, idx := findAnswer(answerMap, refURL, linkedURL); truth != nil {
+									truePositiveCount++
+									truth.numFound[idx]++
 								} else {
 									// no entry found in answer key; this is a false positive
 									falsePositiveCount++
@@ -220,17 +203,19 @@ func buildAnswerMap(answerKey *fhir_models.Bundle) (map[string]*groundTruth, int
 								"full url": refURL,
 								"link url": linkedURL,
 								"search":   score}).Info("buildAnswerMap")
-							// look for existing entry
+							// a record may be linked to several others, but each
+							// pair is counted once, whichever record lists it
+							if truth, _ := findAnswer(m, refURL, linkedURL); truth != nil {
+								continue
+							}
 							item := m[refURL]
 							if item == nil {
 								item = &groundTruth{}
-								item.linkedURLs = make([]string, 1)
-								item.numFound = make([]int, 1)
-								item.linkedURLs[0] = linkedURL
-								item.numFound[0] = 1
 								m[refURL] = item
-								numAnswers++
 							}
+							item.linkedURLs = append(item.linkedURLs, linkedURL)
+							item.numFound = append(item.numFound, 0)
+							numAnswers++
 						}
 					}
 				}
@@ -244,3 +229,20 @@ type groundTruth struct {
 	linkedURLs []string
 	numFound   []int
 }
+
+// findAnswer looks up the link between two records in the answer map, from
+// either record. The ground truth holding the link and the link's index are
+// returned, or nil if the records aren't linked.
+func findAnswer(m map[string]*groundTruth, refURL, linkedURL string) (*groundTruth, int) {
+	if truth := m[refURL]; truth != nil {
+		if idx := indexOf(truth.linkedURLs, linkedURL); idx >= 0 {
+			return truth, idx
+		}
+	}
+	if truth := m[linkedURL]; truth != nil {
+		if idx := indexOf(truth.linkedURLs, refURL); idx >= 0 {
+			return truth, idx
+		}
+	}
+	return nil, -1
+}
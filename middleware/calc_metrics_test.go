@@ -0,0 +1,73 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	. "gopkg.in/check.v1"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+// cluster is an answer key for three records that are all the same person,
+// with one pair listed from each of its records.
+func cluster() *fhir_models.Bundle {
+	recSet := &ptm_models.RecordSet{ResourceType: "Patient"}
+	return ptm_models.NewAnswerKey(recSet, []ptm_models.RecordPair{
+		{Source: "urn:uuid:a", Target: "urn:uuid:b"},
+		{Source: "urn:uuid:a", Target: "urn:uuid:c"},
+		{Source: "urn:uuid:b", Target: "urn:uuid:c"},
+		{Source: "urn:uuid:c", Target: "urn:uuid:a"},
+	})
+}
+
+func (s *ServerSuite) TestBuildAnswerMap(c *C) {
+	m, numAnswers := buildAnswerMap(cluster())
+	c.Assert(numAnswers, Equals, 3)
+	c.Assert(m["urn:uuid:a"].linkedURLs, DeepEquals, []string{"urn:uuid:b", "urn:uuid:c"})
+	c.Assert(m["urn:uuid:b"].linkedURLs, DeepEquals, []string{"urn:uuid:c"})
+	c.Assert(m["urn:uuid:c"], IsNil)
+
+	truth, idx := findAnswer(m, "urn:uuid:c", "urn:uuid:a")
+	c.Assert(truth, Equals, m["urn:uuid:a"])
+	c.Assert(idx, Equals, 1)
+	truth, _ = findAnswer(m, "urn:uuid:a", "urn:uuid:d")
+	c.Assert(truth, IsNil)
+}
+
+func (s *ServerSuite) TestCalcMetricsCluster(c *C) {
+	recSet := &ptm_models.RecordSet{ResourceType: "Patient", AnswerKey: *cluster()}
+	_, err := ptm_models.PersistResource(database, "RecordSet", recSet)
+	c.Assert(err, IsNil)
+	run := &ptm_models.RecordMatchRun{MatchingMode: ptm_models.Deduplication, MasterRecordSetID: recSet.ID}
+	_, err = ptm_models.PersistResource(database, "RecordMatchRun", run)
+	c.Assert(err, IsNil)
+
+	// every pair in the cluster, one reported from the other record, and a
+	// pair that doesn't match
+	results := responseMessage("", "ok", nil,
+		link("urn:uuid:a", "urn:uuid:b"), link("urn:uuid:b", "urn:uuid:c"),
+		link("urn:uuid:c", "urn:uuid:a"), link("urn:uuid:a", "urn:uuid:d"))
+	c.Assert(calcMetrics(database, run, results), IsNil)
+
+	run = loadRun(c, run.ID)
+	c.Assert(run.Metrics.MatchCount, Equals, 4)
+	c.Assert(run.Metrics.TruePositiveCount, Equals, 3)
+	c.Assert(run.Metrics.FalsePositiveCount, Equals, 1)
+	c.Assert(run.Metrics.Recall, Equals, float32(1))
+	c.Assert(run.Metrics.Precision, Equals, float32(0.75))
+}
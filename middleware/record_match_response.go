@@ -16,6 +16,8 @@ limitations under the License.
 package middleware
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -53,6 +55,13 @@ func PostProcessRecordMatchResponse() gin.HandlerFunc {
 
 }
 
+// ProcessRecordMatchResponse processes a response message that the record
+// matching system returned other than by storing it as a Bundle, e.g., through
+// the $process-message operation.
+func ProcessRecordMatchResponse(db *mgo.Database, respMsg *fhir_models.Bundle) error {
+	return updateRecordMatchRun(db, respMsg)
+}
+
 func updateRecordMatchRun(db *mgo.Database, respMsg *fhir_models.Bundle) error {
 	// Verify this bundle represents a message
 	if respMsg.Type == "message" {
@@ -127,13 +136,29 @@ func updateRecordMatchRun(db *mgo.Database, respMsg *fhir_models.Bundle) error {
 				respID = bson.NewObjectId()
 			}
 
+			// late responses are accepted, but marked as late
+			late := recMatchRun.IsLate(now)
+			kind, code, issues := ptm_models.ClassifyResponse(respMsg)
+			// a part carries results, even if it has no links, e.g. a final
+			// part that only marks the end of the response
+			var part *ptm_models.ResponsePart
+			if rp, ok := ptm_models.ResponsePartOf(respMsg); ok && kind != ptm_models.ResponseError {
+				part = &rp
+				kind = ptm_models.ResponseResults
+			}
+
 			// Add the record match response to the record run data
 			err = c.UpdateId(recMatchRun.ID,
-				bson.M{"$push": bson.M{"responses": bson.M{
-					"_id":        respID,
-					"meta":       bson.M{"lastUpdatedOn": now, "createdOn": now},
-					"receivedOn": now,
-					"message":    respMsg,
+				bson.M{"$push": bson.M{"responses": ptm_models.RecordMatchResponse{
+					ID:         respID,
+					Meta:       &ptm_models.Meta{LastUpdatedOn: now, CreatedOn: now},
+					ReceivedOn: now,
+					Late:       late,
+					Kind:       kind,
+					Code:       code,
+					Issues:     issues,
+					Part:       part,
+					Message:    respMsg,
 				}}})
 
 			if err != nil {
@@ -143,19 +168,30 @@ func updateRecordMatchRun(db *mgo.Database, respMsg *fhir_models.Bundle) error {
 				return err
 			}
 
-			// Add an entry to the record match run status and update lastUpdatedOn
-			err = c.UpdateId(recMatchRun.ID,
-				bson.M{
-					"$currentDate": bson.M{"meta.lastUpdatedOn": bson.M{"$type": "timestamp"}},
-					"$push": bson.M{
-						"status": bson.M{
-							"message":   "Response Received [" + respMsg.Id + "]",
-							"createdOn": now}}})
+			// responses to a cancelled run are kept, but not scored
+			if recMatchRun.State == ptm_models.RunCancelled {
+				return ptm_models.AddRunStatus(db, recMatchRun.ID,
+					"Response Received for Cancelled Run; Not Scored ["+respMsg.Id+"]")
+			}
 
-			if err != nil {
-				logger.Log.WithFields(logrus.Fields{"msg": "Error updating response status in run object",
-					"rec match run ID": recMatchRun.ID,
-					"error":            err}).Warn("updateRecordMatchRun")
+			switch kind {
+			case ptm_models.ResponseAcknowledgement:
+				return transitionRun(db, recMatchRun.ID, ptm_models.RunAcknowledged,
+					"Acknowledgement Received ["+respMsg.Id+"]")
+			case ptm_models.ResponseError:
+				return handleErrorResponse(db, recMatchRun.ID, respMsg.Id, code, issues)
+			}
+
+			if part != nil {
+				return handleResponsePart(db, recMatchRun.ID, respMsg.Id, *part, late)
+			}
+
+			// Add an entry to the record match run status and complete the run
+			statusMsg := "Response Received [" + respMsg.Id + "]"
+			if late {
+				statusMsg = "Late " + statusMsg
+			}
+			if err = transitionRun(db, recMatchRun.ID, ptm_models.RunCompleted, statusMsg); err != nil {
 				return err
 			}
 			// Calculate metrics
@@ -164,3 +200,102 @@ func updateRecordMatchRun(db *mgo.Database, respMsg *fhir_models.Bundle) error {
 	}
 	return nil
 }
+
+// handleErrorResponse records an error reported by the record matcher. After
+// a transient error, the request is sent again, provided that it hasn't been
+// sent too many times already; otherwise, the run fails.
+func handleErrorResponse(db *mgo.Database, runID bson.ObjectId, respID, code string, issues []ptm_models.ResponseIssue) error {
+	statusMsg := "Error from Record Matcher [" + respID + "] " + code
+	if len(issues) > 0 {
+		details := make([]string, len(issues))
+		for i, issue := range issues {
+			details[i] = issue.String()
+		}
+		statusMsg += " (" + strings.Join(details, "; ") + ")"
+	}
+
+	if code == ptm_models.ResponseTransientError {
+		// queue the run first, so a prompt redelivery moves it on to sent; a
+		// run that can't be queued again, e.g., because it has finished, isn't
+		// retried
+		err := ptm_models.TransitionRun(db, runID, ptm_models.RunQueued, statusMsg+"; Request Queued for Retry", nil)
+		if _, ok := err.(*ptm_models.RunTransitionError); ok {
+			return ptm_models.AddRunStatus(db, runID, statusMsg+"; Request Not Retried")
+		} else if err != nil {
+			return err
+		}
+		retried, err := ptm_models.RetryDelivery(db, runID, ptm_models.OutboxRequest)
+		if retried {
+			return nil
+		}
+		if err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+		return transitionRun(db, runID, ptm_models.RunFailed, "Request Not Retried; No Delivery Attempts Left")
+	}
+	return transitionRun(db, runID, ptm_models.RunFailed, statusMsg)
+}
+
+// handleResponsePart records the receipt of one part of a multi-part
+// response. The run is completed, and its metrics calculated from the results
+// in every part, only once all of the parts are in.
+func handleResponsePart(db *mgo.Database, runID bson.ObjectId, respID string, part ptm_models.ResponsePart, late bool) error {
+	c := db.C(ptm_models.GetCollectionName("RecordMatchRun"))
+	recMatchRun := &ptm_models.RecordMatchRun{}
+	if err := c.FindId(runID).One(recMatchRun); err != nil {
+		return err
+	}
+	progress := recMatchRun.ResponsePartProgress()
+
+	statusMsg := fmt.Sprintf("Response Part %d Received [%s]", part.Part, respID)
+	if progress.PartCount > 0 {
+		statusMsg = fmt.Sprintf("Response Part %d of %d Received [%s]", part.Part, progress.PartCount, respID)
+	}
+	if late {
+		statusMsg = "Late " + statusMsg
+	}
+
+	// once complete, the progress is left alone, so the run is scored once
+	err := c.Update(
+		bson.M{"_id": runID, "responseProgress.complete": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"responseProgress": progress}})
+	if err == mgo.ErrNotFound {
+		return ptm_models.AddRunStatus(db, runID, statusMsg+"; Response Already Complete")
+	} else if err != nil {
+		return err
+	}
+
+	if !progress.Complete {
+		return transitionRun(db, runID, ptm_models.RunResponding, statusMsg)
+	}
+
+	if err = ptm_models.AddRunStatus(db, runID, statusMsg); err != nil {
+		return err
+	}
+	err = transitionRun(db, runID, ptm_models.RunCompleted,
+		fmt.Sprintf("Response Complete [%d parts]", progress.PartCount))
+	if err != nil {
+		return err
+	}
+	// score the parts together, rather than adding to any earlier metrics
+	recMatchRun.Metrics = ptm_models.RecordMatchRunMetrics{}
+	return calcMetrics(db, recMatchRun, recMatchRun.CombinedResults())
+}
+
+// transitionRun moves the run to the state. If the run can't move to the
+// state, the status message is still recorded.
+func transitionRun(db *mgo.Database, runID bson.ObjectId, state, statusMsg string) error {
+	err := ptm_models.TransitionRun(db, runID, state, statusMsg, nil)
+	if _, ok := err.(*ptm_models.RunTransitionError); ok {
+		logger.Log.WithFields(logrus.Fields{"msg": "Response received for run in the wrong state",
+			"rec match run ID": runID,
+			"error":            err}).Warn("updateRecordMatchRun")
+		err = ptm_models.AddRunStatus(db, runID, statusMsg)
+	}
+	if err != nil {
+		logger.Log.WithFields(logrus.Fields{"msg": "Error updating response status in run object",
+			"rec match run ID": runID,
+			"error":            err}).Warn("updateRecordMatchRun")
+	}
+	return err
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"net/http"
+	"testing"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	"github.com/mitre/ptmatch/logger"
+	ptm_models "github.com/mitre/ptmatch/models"
+)
+
+var (
+	mongoSession *mgo.Session
+	database     *mgo.Database
+)
+
+type ServerSuite struct {
+	DatabaseHost string
+	DatabaseName string
+}
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&ServerSuite{"localhost", "ptmatch-middleware-test"})
+
+// runs once
+func (s *ServerSuite) SetUpSuite(c *C) {
+	var err error
+
+	// Set up the database
+	if mongoSession, err = mgo.Dial(s.DatabaseHost); err != nil {
+		logger.Log.Error("Cannot connect to MongoDB. Is service running?")
+		panic(err)
+	}
+	c.Assert(mongoSession, NotNil)
+
+	database = mongoSession.DB(s.DatabaseName)
+	c.Assert(database, NotNil)
+}
+
+func (s *ServerSuite) TearDownTest(c *C) {
+	if database != nil {
+		database.C("recordMatchRuns").DropCollection()
+		database.C("recordSets").DropCollection()
+		database.C("outboxMessages").DropCollection()
+	}
+}
+
+func (s *ServerSuite) TearDownSuite(c *C) {
+	if database != nil {
+		database.DropDatabase()
+	}
+	if mongoSession != nil {
+		mongoSession.Close()
+	}
+}
+
+// newSentRun stores a run whose request has been sent to the record matcher.
+// The identifier of the request message is returned with the run.
+func newSentRun(c *C) (*ptm_models.RecordMatchRun, string) {
+	reqID := bson.NewObjectId().Hex()
+	msgHdr := &fhir_models.MessageHeader{}
+	msgHdr.Id = reqID
+	run := &ptm_models.RecordMatchRun{State: ptm_models.RunSent, Request: ptm_models.RecordMatchRequest{
+		Message: &fhir_models.Bundle{Type: "message", Entry: []fhir_models.BundleEntryComponent{{Resource: msgHdr}}}}}
+	_, err := ptm_models.PersistResource(database, "RecordMatchRun", run)
+	c.Assert(err, IsNil)
+	return run, reqID
+}
+
+// responseMessage returns a response to the request with the code, carrying
+// the extensions in its header and the entries after it.
+func responseMessage(reqID, code string, exts []fhir_models.Extension, entries ...fhir_models.BundleEntryComponent) *fhir_models.Bundle {
+	msgHdr := &fhir_models.MessageHeader{
+		Event: &fhir_models.Coding{System: "http://github.com/mitre/ptmatch/fhir/message-events",
+			Code: "record-match"},
+		Response: &fhir_models.MessageHeaderResponseComponent{Identifier: reqID, Code: code}}
+	msgHdr.Extension = exts
+	msg := &fhir_models.Bundle{Type: "message",
+		Entry: append([]fhir_models.BundleEntryComponent{{Resource: msgHdr}}, entries...)}
+	msg.Id = bson.NewObjectId().Hex()
+	return msg
+}
+
+// link is a result linking two records.
+func link(source, target string) fhir_models.BundleEntryComponent {
+	score := 0.9
+	return fhir_models.BundleEntryComponent{FullUrl: source,
+		Search: &fhir_models.BundleEntrySearchComponent{Score: &score},
+		Link:   []fhir_models.BundleLinkComponent{{Relation: "related", Url: target}}}
+}
+
+func loadRun(c *C, id bson.ObjectId) *ptm_models.RecordMatchRun {
+	obj, err := ptm_models.LoadResource(database, "RecordMatchRun", id)
+	c.Assert(err, IsNil)
+	return obj.(*ptm_models.RecordMatchRun)
+}
+
+func (s *ServerSuite) TestAcknowledgement(c *C) {
+	run, reqID := newSentRun(c)
+
+	c.Assert(updateRecordMatchRun(database, responseMessage(reqID, ptm_models.ResponseOK, nil)), IsNil)
+	run = loadRun(c, run.ID)
+	c.Assert(run.State, Equals, ptm_models.RunAcknowledged)
+	c.Assert(run.Responses, HasLen, 1)
+	c.Assert(run.Responses[0].Kind, Equals, ptm_models.ResponseAcknowledgement)
+}
+
+func (s *ServerSuite) TestFatalError(c *C) {
+	run, reqID := newSentRun(c)
+
+	outcome := &fhir_models.OperationOutcome{Issue: []fhir_models.OperationOutcomeIssueComponent{
+		{Severity: "error", Code: "not-found", Diagnostics: "Unable to read the master record set"}}}
+	msg := responseMessage(reqID, ptm_models.ResponseFatalError, nil, fhir_models.BundleEntryComponent{Resource: outcome})
+	c.Assert(updateRecordMatchRun(database, msg), IsNil)
+	run = loadRun(c, run.ID)
+	c.Assert(run.State, Equals, ptm_models.RunFailed)
+	c.Assert(run.Responses[0].Kind, Equals, ptm_models.ResponseError)
+	c.Assert(run.Responses[0].Issues, HasLen, 1)
+}
+
+func (s *ServerSuite) TestTransientError(c *C) {
+	run, reqID := newSentRun(c)
+	outboxMsg := ptm_models.NewOutboxMessage(run.ID, ptm_models.OutboxRequest, http.MethodPut,
+		"http://127.0.0.1:1/Bundle/1", "application/json+fhir", []byte("{}"), 2)
+	outboxMsg.RecordAttempt(ptm_models.DeliveryAttempt{StatusCode: http.StatusOK, Delivered: true},
+		ptm_models.OutboxInitialBackoff, ptm_models.OutboxMaxBackoff)
+	_, err := ptm_models.PersistResource(database, "OutboxMessage", outboxMsg)
+	c.Assert(err, IsNil)
+
+	// the request is sent again while attempts remain
+	c.Assert(updateRecordMatchRun(database, responseMessage(reqID, ptm_models.ResponseTransientError, nil)), IsNil)
+	run = loadRun(c, run.ID)
+	c.Assert(run.State, Equals, ptm_models.RunQueued)
+	obj, err := ptm_models.LoadResource(database, "OutboxMessage", outboxMsg.ID)
+	c.Assert(err, IsNil)
+	c.Assert(obj.(*ptm_models.OutboxMessage).Status, Equals, ptm_models.OutboxPending)
+
+	// once redelivered, a second transient error uses up the last attempt
+	_, err = database.C("outboxMessages").UpdateAll(bson.M{"_id": outboxMsg.ID}, bson.M{
+		"$set":  bson.M{"status": ptm_models.OutboxDelivered},
+		"$push": bson.M{"attempts": ptm_models.DeliveryAttempt{StatusCode: http.StatusOK, Delivered: true}}})
+	c.Assert(err, IsNil)
+	c.Assert(ptm_models.TransitionRun(database, run.ID, ptm_models.RunSent, "Request Sent", nil), IsNil)
+	c.Assert(updateRecordMatchRun(database, responseMessage(reqID, ptm_models.ResponseTransientError, nil)), IsNil)
+	run = loadRun(c, run.ID)
+	c.Assert(run.State, Equals, ptm_models.RunFailed)
+}
+
+func (s *ServerSuite) TestTransientErrorForCompletedRun(c *C) {
+	run, reqID := newSentRun(c)
+	outboxMsg := ptm_models.NewOutboxMessage(run.ID, ptm_models.OutboxRequest, http.MethodPut,
+		"http://127.0.0.1:1/Bundle/1", "application/json+fhir", []byte("{}"), 2)
+	outboxMsg.RecordAttempt(ptm_models.DeliveryAttempt{StatusCode: http.StatusOK, Delivered: true},
+		ptm_models.OutboxInitialBackoff, ptm_models.OutboxMaxBackoff)
+	_, err := ptm_models.PersistResource(database, "OutboxMessage", outboxMsg)
+	c.Assert(err, IsNil)
+	c.Assert(ptm_models.TransitionRun(database, run.ID, ptm_models.RunCompleted, "Response Received", nil), IsNil)
+
+	// a late transient error doesn't send the request of a finished run again
+	c.Assert(updateRecordMatchRun(database, responseMessage(reqID, ptm_models.ResponseTransientError, nil)), IsNil)
+	run = loadRun(c, run.ID)
+	c.Assert(run.State, Equals, ptm_models.RunCompleted)
+	obj, err := ptm_models.LoadResource(database, "OutboxMessage", outboxMsg.ID)
+	c.Assert(err, IsNil)
+	c.Assert(obj.(*ptm_models.OutboxMessage).Status, Equals, ptm_models.OutboxDelivered)
+}
+
+func (s *ServerSuite) TestTwoPartResponse(c *C) {
+	run, reqID := newSentRun(c)
+
+	one, two := int32(1), int32(2)
+	final := true
+	part1 := responseMessage(reqID, ptm_models.ResponseOK,
+		[]fhir_models.Extension{{Url: ptm_models.ResponsePartExtension, ValueInteger: &one}},
+		link("urn:uuid:a", "urn:uuid:b"), link("urn:uuid:c", "urn:uuid:d"))
+	c.Assert(updateRecordMatchRun(database, part1), IsNil)
+	run = loadRun(c, run.ID)
+	c.Assert(run.State, Equals, ptm_models.RunResponding)
+
+	// the final part has no links, only the terminator
+	part2 := responseMessage(reqID, ptm_models.ResponseOK, []fhir_models.Extension{
+		{Url: ptm_models.ResponsePartExtension, ValueInteger: &two},
+		{Url: ptm_models.ResponseFinalPartExtension, ValueBoolean: &final}})
+	c.Assert(updateRecordMatchRun(database, part2), IsNil)
+	run = loadRun(c, run.ID)
+	c.Assert(run.State, Equals, ptm_models.RunCompleted)
+	c.Assert(run.Responses[1].Kind, Equals, ptm_models.ResponseResults)
+	c.Assert(run.ResponseProgress.Complete, Equals, true)
+	c.Assert(run.ResponseProgress.PartCount, Equals, 2)
+	c.Assert(run.Metrics.MatchCount, Equals, 2)
+}
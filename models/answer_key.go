@@ -0,0 +1,242 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"gopkg.in/mgo.v2/bson"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+)
+
+// Codes used in the Composition resource that introduces an answer key
+const (
+	AnswerKeyCodeSystem      = "https://github.com/mitre/ptmatch"
+	AnswerKeyTypeCode        = "10001-1"
+	AnswerKeyMatchingRecCode = "10001-2"
+)
+
+// RecordPair is a pair of records, identified by URL, that are known to
+// refer to the same entity. Pairs are unordered; NewRecordPair puts the
+// URLs in a canonical order so equal pairs compare equal.
+type RecordPair struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// NewRecordPair returns the canonical RecordPair for the two record URLs.
+func NewRecordPair(a, b string) RecordPair {
+	if b < a {
+		a, b = b, a
+	}
+	return RecordPair{Source: a, Target: b}
+}
+
+// RecordPairSlice is needed as a holder for the functions to work with the
+// sort package
+type RecordPairSlice []RecordPair
+
+func (ps RecordPairSlice) Len() int      { return len(ps) }
+func (ps RecordPairSlice) Swap(i, j int) { ps[i], ps[j] = ps[j], ps[i] }
+func (ps RecordPairSlice) Less(i, j int) bool {
+	if ps[i].Source == ps[j].Source {
+		return ps[i].Target < ps[j].Target
+	}
+	return ps[i].Source < ps[j].Source
+}
+
+// ExternalLink describes a link from a record in a record set to a record
+// that is not a member of that record set.
+type ExternalLink struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Type   string `json:"type"`
+}
+
+// NewAnswerKey constructs an answer key for the given record set. The answer
+// key is a FHIR document whose Composition references the record set's search
+// expression and one link entry per matching pair of records.
+func NewAnswerKey(recSet *RecordSet, pairs []RecordPair) *fhir_models.Bundle {
+	answerKey := &fhir_models.Bundle{}
+	answerKey.Id = bson.NewObjectId().Hex()
+	answerKey.Type = "document"
+	answerKey.Entry = make([]fhir_models.BundleEntryComponent, 2, len(pairs)+2)
+
+	params := &fhir_models.Parameters{}
+	if recSet.Parameters != nil {
+		*params = *recSet.Parameters
+	}
+	params.Id = uuid.NewV4().String()
+
+	comp := &fhir_models.Composition{}
+	comp.Id = uuid.NewV4().String()
+	comp.Date = &fhir_models.FHIRDateTime{Time: time.Now(), Precision: fhir_models.Timestamp}
+	comp.Title = "Answer Key"
+	comp.Status = "final"
+	comp.Type = &fhir_models.CodeableConcept{
+		Coding: []fhir_models.Coding{fhir_models.Coding{
+			System: AnswerKeyCodeSystem, Code: AnswerKeyTypeCode, Display: "Answer Key"}}}
+	comp.Subject = &fhir_models.Reference{Reference: "urn:uuid:" + params.Id}
+	comp.Author = []fhir_models.Reference{fhir_models.Reference{Display: "Patient Matching Test Harness"}}
+	section := fhir_models.CompositionSectionComponent{
+		Title: "Matching Records",
+		Code: &fhir_models.CodeableConcept{
+			Coding: []fhir_models.Coding{fhir_models.Coding{
+				System: AnswerKeyCodeSystem, Code: AnswerKeyMatchingRecCode, Display: "Matching Record"}}}}
+
+	answerKey.Entry[0].FullUrl = "urn:uuid:" + comp.Id
+	answerKey.Entry[0].Resource = comp
+	answerKey.Entry[1].FullUrl = "urn:uuid:" + params.Id
+	answerKey.Entry[1].Resource = params
+
+	score := 1.0
+	for _, pair := range pairs {
+		entry := fhir_models.BundleEntryComponent{}
+		entry.FullUrl = pair.Source
+		entry.Link = []fhir_models.BundleLinkComponent{
+			fhir_models.BundleLinkComponent{Relation: "type", Url: "http://hl7.org/fhir/" + recSet.ResourceType},
+			fhir_models.BundleLinkComponent{Relation: "related", Url: pair.Target}}
+		entry.Search = &fhir_models.BundleEntrySearchComponent{Score: &score}
+		answerKey.Entry = append(answerKey.Entry, entry)
+		section.Entry = append(section.Entry, fhir_models.Reference{Reference: pair.Source})
+	}
+	comp.Section = []fhir_models.CompositionSectionComponent{section}
+
+	return answerKey
+}
+
+// AnswerKeyPairs returns the matching record pairs declared in an answer
+// key. Links with a score of zero declare non-matches and are not included.
+func AnswerKeyPairs(answerKey *fhir_models.Bundle) []RecordPair {
+	seen := make(map[RecordPair]bool)
+	var pairs []RecordPair
+	for _, entry := range answerKey.Entry {
+		if entry.Resource != nil || entry.FullUrl == "" || entry.Search == nil ||
+			entry.Search.Score == nil || *entry.Search.Score <= 0 {
+			continue
+		}
+		for _, link := range entry.Link {
+			if strings.EqualFold("related", link.Relation) {
+				pair := NewRecordPair(entry.FullUrl, link.Url)
+				if !seen[pair] {
+					seen[pair] = true
+					pairs = append(pairs, pair)
+				}
+			}
+		}
+	}
+	sort.Sort(RecordPairSlice(pairs))
+	return pairs
+}
+
+// PatientLinkPairs derives matching record pairs from the Patient.link
+// elements of the given record set members. Links of type seealso and
+// replaced-by are treated as undirected, so a link recorded on only one of
+// the two patients still produces the pair. Links to records outside the set
+// are returned separately.
+func PatientLinkPairs(baseURL string, members []fhir_models.BundleEntryComponent) ([]RecordPair, []ExternalLink) {
+	inSet := make(map[string]bool)
+	for _, member := range members {
+		inSet[member.FullUrl] = true
+	}
+
+	seen := make(map[RecordPair]bool)
+	var pairs []RecordPair
+	var external []ExternalLink
+	for _, member := range members {
+		patient, ok := member.Resource.(*fhir_models.Patient)
+		if !ok {
+			continue
+		}
+		for _, link := range patient.Link {
+			if link.Type != "seealso" && link.Type != "replaced-by" {
+				continue
+			}
+			if link.Other == nil || link.Other.Reference == "" ||
+				strings.HasPrefix(link.Other.Reference, "#") {
+				continue
+			}
+			target := link.Other.Reference
+			if !strings.Contains(target, "://") && !strings.HasPrefix(target, "urn:") {
+				target = strings.TrimRight(baseURL, "/") + "/" + strings.TrimLeft(target, "/")
+			}
+			if !inSet[target] {
+				external = append(external, ExternalLink{Source: member.FullUrl, Target: target, Type: link.Type})
+				continue
+			}
+			if target == member.FullUrl {
+				continue
+			}
+			pair := NewRecordPair(member.FullUrl, target)
+			if !seen[pair] {
+				seen[pair] = true
+				pairs = append(pairs, pair)
+			}
+		}
+	}
+	sort.Sort(RecordPairSlice(pairs))
+	return pairs, external
+}
+
+// RecordClusters groups the records in the given pairs into clusters of
+// records that refer to the same entity, following matches transitively.
+// Each cluster is sorted, and clusters are ordered by their first record.
+func RecordClusters(pairs []RecordPair) [][]string {
+	parent := make(map[string]string)
+	var find func(string) string
+	find = func(url string) string {
+		p, ok := parent[url]
+		if !ok {
+			parent[url] = url
+			return url
+		}
+		if p != url {
+			p = find(p)
+			parent[url] = p
+		}
+		return p
+	}
+	for _, pair := range pairs {
+		a, b := find(pair.Source), find(pair.Target)
+		if a != b {
+			parent[b] = a
+		}
+	}
+
+	groups := make(map[string][]string)
+	for url := range parent {
+		root := find(url)
+		groups[root] = append(groups[root], url)
+	}
+	clusters := make([][]string, 0, len(groups))
+	for _, cluster := range groups {
+		sort.Strings(cluster)
+		clusters = append(clusters, cluster)
+	}
+	sort.Sort(clusterSlice(clusters))
+	return clusters
+}
+
+type clusterSlice [][]string
+
+func (cs clusterSlice) Len() int           { return len(cs) }
+func (cs clusterSlice) Swap(i, j int)      { cs[i], cs[j] = cs[j], cs[i] }
+func (cs clusterSlice) Less(i, j int) bool { return cs[i][0] < cs[j][0] }
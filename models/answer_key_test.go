@@ -0,0 +1,71 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	fhir_models "github.com/intervention-engine/fhir/models"
+	. "gopkg.in/check.v1"
+)
+
+type AnswerKeySuite struct {
+}
+
+var _ = Suite(&AnswerKeySuite{})
+
+func patientEntry(url string, links ...fhir_models.PatientLinkComponent) fhir_models.BundleEntryComponent {
+	patient := &fhir_models.Patient{Link: links}
+	return fhir_models.BundleEntryComponent{FullUrl: url, Resource: patient}
+}
+
+func patientLink(linkType, ref string) fhir_models.PatientLinkComponent {
+	return fhir_models.PatientLinkComponent{Type: linkType, Other: &fhir_models.Reference{Reference: ref}}
+}
+
+func (s *AnswerKeySuite) TestPatientLinkPairs(c *C) {
+	base := "http://localhost:3001"
+	members := []fhir_models.BundleEntryComponent{
+		patientEntry(base+"/Patient/1", patientLink("seealso", "Patient/2")),
+		// the same link, recorded in the other direction
+		patientEntry(base+"/Patient/2", patientLink("seealso", base+"/Patient/1")),
+		patientEntry(base+"/Patient/3", patientLink("replaced-by", "Patient/4"),
+			patientLink("refer", "Patient/1")),
+		patientEntry(base+"/Patient/4", patientLink("seealso", "Patient/99")),
+	}
+
+	pairs, external := PatientLinkPairs(base, members)
+	c.Assert(pairs, DeepEquals, []RecordPair{
+		RecordPair{base + "/Patient/1", base + "/Patient/2"},
+		RecordPair{base + "/Patient/3", base + "/Patient/4"}})
+	c.Assert(external, DeepEquals, []ExternalLink{
+		ExternalLink{base + "/Patient/4", base + "/Patient/99", "seealso"}})
+}
+
+func (s *AnswerKeySuite) TestNewAnswerKey(c *C) {
+	recSet := &RecordSet{ResourceType: "Patient",
+		Parameters: &fhir_models.Parameters{Parameter: []fhir_models.ParametersParameterComponent{
+			fhir_models.ParametersParameterComponent{Name: "resourceUrl", ValueString: "http://localhost:3001/Patient"}}}}
+	pairs := []RecordPair{NewRecordPair("http://localhost:3001/Patient/b", "http://localhost:3001/Patient/a")}
+
+	answerKey := NewAnswerKey(recSet, pairs)
+	c.Assert(answerKey.Type, Equals, "document")
+	c.Assert(answerKey.Id, Not(Equals), "")
+	c.Assert(len(answerKey.Entry), Equals, 3)
+	_, ok := answerKey.Entry[0].Resource.(*fhir_models.Composition)
+	c.Assert(ok, Equals, true)
+	c.Assert(AnswerKeyPairs(answerKey), DeepEquals, pairs)
+	c.Assert(pairs[0].Source, Equals, "http://localhost:3001/Patient/a")
+}
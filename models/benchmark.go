@@ -0,0 +1,147 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"encoding/csv"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+)
+
+// Identifier systems for the record identifiers of well-known record linkage
+// benchmark datasets. Records imported from these datasets carry their
+// original identifier, so that results can be reported in terms of it.
+const (
+	FEBRLRecordIDSystem   = "urn:febrl:rec_id"
+	ONCEnterpriseIDSystem = "urn:onc:pmac:enterprise-id"
+)
+
+// How the record matching results of records that have no identifier in the
+// requested system are handled when the results are reported in terms of
+// dataset identifiers.
+const (
+	// the results can't be reported
+	UnmappedError = "error"
+	// results including the record are left out
+	UnmappedSkip = "skip"
+	// the logical id of the FHIR resource is used
+	UnmappedResourceID = "id"
+)
+
+// SubmissionPair is a pair of records, identified by their dataset
+// identifiers, reported as a match.
+type SubmissionPair struct {
+	ID1   string
+	ID2   string
+	Score float64
+}
+
+// IdentifierMap returns the value of the identifier in the given system of
+// each member that has one, keyed by the member's URL.
+func IdentifierMap(members []fhir_models.BundleEntryComponent, system string) map[string]string {
+	ids := make(map[string]string)
+	for _, member := range members {
+		r := reflect.ValueOf(member.Resource)
+		for r.Kind() == reflect.Ptr || r.Kind() == reflect.Interface {
+			r = r.Elem()
+		}
+		if r.Kind() != reflect.Struct {
+			continue
+		}
+		field := r.FieldByName("Identifier")
+		if !field.IsValid() {
+			continue
+		}
+		identifiers, _ := field.Interface().([]fhir_models.Identifier)
+		for _, identifier := range identifiers {
+			if identifier.System == system && identifier.Value != "" {
+				ids[member.FullUrl] = identifier.Value
+				break
+			}
+		}
+	}
+	return ids
+}
+
+// SubmissionPairs converts links between records to pairs of dataset
+// identifiers. A pair reported more than once (e.g., in both directions) is
+// included once, with its highest score. The URLs of records without an
+// identifier are returned; they are handled according to unmapped, which is
+// one of UnmappedError, UnmappedSkip or UnmappedResourceID.
+func SubmissionPairs(links []Link, ids map[string]string, unmapped string) ([]SubmissionPair, []string) {
+	missing := make(map[string]bool)
+	lookup := func(url string) (string, bool) {
+		if id, ok := ids[url]; ok {
+			return id, true
+		}
+		missing[url] = true
+		if unmapped == UnmappedResourceID {
+			return url[strings.LastIndex(url, "/")+1:], true
+		}
+		return "", false
+	}
+
+	scores := make(map[RecordPair]float64)
+	for _, link := range links {
+		source, sourceOk := lookup(link.Source)
+		target, targetOk := lookup(link.Target)
+		if !sourceOk || !targetOk || source == target {
+			continue
+		}
+		pair := NewRecordPair(source, target)
+		if score, ok := scores[pair]; !ok || link.Score > score {
+			scores[pair] = link.Score
+		}
+	}
+
+	keys := make([]RecordPair, 0, len(scores))
+	for pair := range scores {
+		keys = append(keys, pair)
+	}
+	sort.Sort(RecordPairSlice(keys))
+	pairs := make([]SubmissionPair, len(keys))
+	for i, pair := range keys {
+		pairs[i] = SubmissionPair{ID1: pair.Source, ID2: pair.Target, Score: scores[pair]}
+	}
+
+	missingURLs := make([]string, 0, len(missing))
+	for url := range missing {
+		missingURLs = append(missingURLs, url)
+	}
+	sort.Strings(missingURLs)
+	return pairs, missingURLs
+}
+
+// WriteONCSubmission writes the pairs in the pairwise CSV format of the ONC
+// Patient Matching Algorithm Challenge submissions: one row per matching pair
+// of enterprise IDs, followed by the match score.
+func WriteONCSubmission(w io.Writer, pairs []SubmissionPair, header bool) error {
+	cw := csv.NewWriter(w)
+	if header {
+		cw.Write([]string{"EnterpriseID1", "EnterpriseID2", "MATCH_SCORE"})
+	}
+	for _, pair := range pairs {
+		cw.Write([]string{pair.ID1, pair.ID2, strconv.FormatFloat(pair.Score, 'f', -1, 64)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
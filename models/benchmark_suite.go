@@ -0,0 +1,200 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BenchmarkSuite evaluates record matching systems against the same record
+// sets. Running the suite creates a RecordMatchRun for every combination of
+// record matching system and record set (deduplication) or pair of record
+// sets (query).
+type BenchmarkSuite struct {
+	ID          bson.ObjectId `bson:"_id,omitempty" json:"id,omitempty"`
+	Meta        *Meta         `bson:"meta,omitempty" json:"meta,omitempty"`
+	Name        string        `bson:"name,omitempty" json:"name,omitempty"`
+	Description string        `bson:"description,omitempty" json:"description,omitempty"`
+	// context with which the suite's runs are associated
+	RecordMatchContextID          bson.ObjectId   `bson:"recordMatchContextId,omitempty" json:"recordMatchContextId,omitempty"`
+	RecordMatchSystemInterfaceIDs []bson.ObjectId `bson:"recordMatchSystemInterfaceIds,omitempty" json:"recordMatchSystemInterfaceIds,omitempty"`
+	// deduplication or query
+	MatchingMode       string `bson:"matchingMode,omitempty" json:"matchingMode,omitempty"`
+	RecordResourceType string `bson:"recordResourceType,omitempty" json:"recordResourceType,omitempty"`
+	// master record sets, in deduplication mode
+	RecordSetIDs []bson.ObjectId `bson:"recordSetIds,omitempty" json:"recordSetIds,omitempty"`
+	// master and query record sets, in query mode
+	RecordSetPairs []BenchmarkRecordSetPair `bson:"recordSetPairs,omitempty" json:"recordSetPairs,omitempty"`
+	// seconds each record matcher has to respond; defaults to the response
+	// timeout of the system interface
+	ResponseTimeout int `bson:"responseTimeout,omitempty" json:"responseTimeout,omitempty"`
+	// each time the suite was run, most recent last
+	Executions []BenchmarkExecution `bson:"executions,omitempty" json:"executions,omitempty"`
+}
+
+// BenchmarkRecordSetPair is the data for a query-mode run.
+type BenchmarkRecordSetPair struct {
+	MasterRecordSetID bson.ObjectId `bson:"masterRecordSetId" json:"masterRecordSetId"`
+	QueryRecordSetID  bson.ObjectId `bson:"queryRecordSetId" json:"queryRecordSetId"`
+}
+
+// BenchmarkExecution records one run of a suite.
+type BenchmarkExecution struct {
+	ID                bson.ObjectId   `bson:"_id" json:"id"`
+	StartedOn         time.Time       `bson:"startedOn" json:"startedOn"`
+	RecordMatchRunIDs []bson.ObjectId `bson:"recordMatchRunIds" json:"recordMatchRunIds"`
+	// set if the execution stopped before all of its runs were dispatched
+	Error string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// BenchmarkResults shows the progress and results of one execution of a
+// suite. Cells holds a row for each record matching system and, in each row,
+// a column for each dataset.
+type BenchmarkResults struct {
+	BenchmarkSuiteID bson.ObjectId `json:"benchmarkSuiteId"`
+	ExecutionID      bson.ObjectId `json:"executionId"`
+	StartedOn        time.Time     `json:"startedOn"`
+	Error            string        `json:"error,omitempty"`
+	RunCount         int           `json:"runCount"`
+	FinishedCount    int           `json:"finishedCount"`
+	// every run has finished
+	Complete bool `json:"complete"`
+	// number of runs in each state
+	States   map[string]int           `json:"states"`
+	Systems  []BenchmarkSystem        `json:"systems"`
+	Datasets []BenchmarkRecordSetPair `json:"datasets"`
+	Cells    [][]BenchmarkResultCell  `json:"cells"`
+}
+
+// BenchmarkSystem identifies a row of the results.
+type BenchmarkSystem struct {
+	RecordMatchSystemInterfaceID bson.ObjectId `json:"recordMatchSystemInterfaceId"`
+	Name                         string        `json:"name,omitempty"`
+}
+
+// BenchmarkResultCell is the result of one system on one dataset.
+type BenchmarkResultCell struct {
+	RecordMatchRunID bson.ObjectId          `json:"recordMatchRunId,omitempty"`
+	State            string                 `json:"state,omitempty"`
+	Metrics          *RecordMatchRunMetrics `json:"metrics,omitempty"`
+}
+
+// Datasets returns the record sets processed by each run of the suite. In
+// deduplication mode, only the master record set of each is set.
+func (s *BenchmarkSuite) Datasets() []BenchmarkRecordSetPair {
+	if s.MatchingMode == Query {
+		return s.RecordSetPairs
+	}
+	datasets := make([]BenchmarkRecordSetPair, len(s.RecordSetIDs))
+	for i, id := range s.RecordSetIDs {
+		datasets[i].MasterRecordSetID = id
+	}
+	return datasets
+}
+
+// NewRuns returns a new run for each combination of record matching system
+// and dataset, ordered by system, then dataset.
+func (s *BenchmarkSuite) NewRuns(executionID bson.ObjectId) []*RecordMatchRun {
+	var runs []*RecordMatchRun
+	for _, sysIfaceID := range s.RecordMatchSystemInterfaceIDs {
+		for _, dataset := range s.Datasets() {
+			runs = append(runs, &RecordMatchRun{
+				Note:                         s.Name,
+				RecordMatchContextID:         s.RecordMatchContextID,
+				MatchingMode:                 s.MatchingMode,
+				RecordResourceType:           s.RecordResourceType,
+				RecordMatchSystemInterfaceID: sysIfaceID,
+				MasterRecordSetID:            dataset.MasterRecordSetID,
+				QueryRecordSetID:             dataset.QueryRecordSetID,
+				ResponseTimeout:              s.ResponseTimeout,
+				BenchmarkSuiteID:             s.ID,
+				BenchmarkExecutionID:         executionID,
+			})
+		}
+	}
+	return runs
+}
+
+// IsFinished reports whether a run in the state won't change state without
+// intervention.
+func IsFinished(state string) bool {
+	switch state {
+	case RunCompleted, RunFailed, RunTimedOut, RunCancelled:
+		return true
+	}
+	return false
+}
+
+// NewBenchmarkResults arranges the runs of an execution of the suite into a
+// results matrix. Names of the record matching systems are taken from the
+// map, if present.
+func NewBenchmarkResults(suite *BenchmarkSuite, execution *BenchmarkExecution,
+	runs []RecordMatchRun, names map[bson.ObjectId]string) *BenchmarkResults {
+	results := &BenchmarkResults{
+		BenchmarkSuiteID: suite.ID,
+		ExecutionID:      execution.ID,
+		StartedOn:        execution.StartedOn,
+		Error:            execution.Error,
+		States:           make(map[string]int),
+		Datasets:         suite.Datasets(),
+	}
+
+	rows := make(map[bson.ObjectId]int)
+	for i, id := range suite.RecordMatchSystemInterfaceIDs {
+		rows[id] = i
+		results.Systems = append(results.Systems, BenchmarkSystem{RecordMatchSystemInterfaceID: id, Name: names[id]})
+	}
+	cols := make(map[BenchmarkRecordSetPair]int)
+	for i, dataset := range results.Datasets {
+		cols[dataset] = i
+	}
+	results.Cells = make([][]BenchmarkResultCell, len(results.Systems))
+	for i := range results.Cells {
+		results.Cells[i] = make([]BenchmarkResultCell, len(results.Datasets))
+	}
+
+	for i := range runs {
+		run := &runs[i]
+		results.RunCount++
+		results.States[run.State]++
+		if IsFinished(run.State) {
+			results.FinishedCount++
+		}
+
+		row, ok := rows[run.RecordMatchSystemInterfaceID]
+		if !ok {
+			continue
+		}
+		dataset := BenchmarkRecordSetPair{MasterRecordSetID: run.MasterRecordSetID}
+		if suite.MatchingMode == Query {
+			dataset.QueryRecordSetID = run.QueryRecordSetID
+		}
+		col, ok := cols[dataset]
+		if !ok {
+			continue
+		}
+		cell := BenchmarkResultCell{RecordMatchRunID: run.ID, State: run.State}
+		if run.State == RunCompleted {
+			cell.Metrics = &run.Metrics
+		}
+		results.Cells[row][col] = cell
+	}
+	results.Complete = results.RunCount > 0 && results.FinishedCount == results.RunCount
+	return results
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"gopkg.in/mgo.v2/bson"
+
+	. "gopkg.in/check.v1"
+)
+
+type BenchmarkSuiteSuite struct {
+}
+
+var _ = Suite(&BenchmarkSuiteSuite{})
+
+func (s *BenchmarkSuiteSuite) TestNewRuns(c *C) {
+	suite := &BenchmarkSuite{ID: bson.NewObjectId(), MatchingMode: Query,
+		RecordMatchSystemInterfaceIDs: []bson.ObjectId{bson.NewObjectId(), bson.NewObjectId()},
+		RecordSetPairs: []BenchmarkRecordSetPair{
+			{MasterRecordSetID: bson.NewObjectId(), QueryRecordSetID: bson.NewObjectId()},
+			{MasterRecordSetID: bson.NewObjectId(), QueryRecordSetID: bson.NewObjectId()},
+			{MasterRecordSetID: bson.NewObjectId(), QueryRecordSetID: bson.NewObjectId()}}}
+	executionID := bson.NewObjectId()
+
+	runs := suite.NewRuns(executionID)
+	c.Assert(runs, HasLen, 6)
+	c.Assert(runs[4].RecordMatchSystemInterfaceID, Equals, suite.RecordMatchSystemInterfaceIDs[1])
+	c.Assert(runs[4].MasterRecordSetID, Equals, suite.RecordSetPairs[1].MasterRecordSetID)
+	c.Assert(runs[4].QueryRecordSetID, Equals, suite.RecordSetPairs[1].QueryRecordSetID)
+	c.Assert(runs[4].BenchmarkSuiteID, Equals, suite.ID)
+	c.Assert(runs[4].BenchmarkExecutionID, Equals, executionID)
+
+	// deduplication ignores record set pairs
+	suite.MatchingMode = Deduplication
+	suite.RecordSetIDs = []bson.ObjectId{bson.NewObjectId()}
+	runs = suite.NewRuns(executionID)
+	c.Assert(runs, HasLen, 2)
+	c.Assert(runs[1].MasterRecordSetID, Equals, suite.RecordSetIDs[0])
+	c.Assert(runs[1].QueryRecordSetID, Equals, bson.ObjectId(""))
+}
+
+func (s *BenchmarkSuiteSuite) TestNewBenchmarkResults(c *C) {
+	sys1, sys2 := bson.NewObjectId(), bson.NewObjectId()
+	set1, set2 := bson.NewObjectId(), bson.NewObjectId()
+	suite := &BenchmarkSuite{ID: bson.NewObjectId(), MatchingMode: Deduplication,
+		RecordMatchSystemInterfaceIDs: []bson.ObjectId{sys1, sys2},
+		RecordSetIDs:                  []bson.ObjectId{set1, set2}}
+	execution := &BenchmarkExecution{ID: bson.NewObjectId()}
+
+	runs := []RecordMatchRun{
+		{ID: bson.NewObjectId(), RecordMatchSystemInterfaceID: sys1, MasterRecordSetID: set1,
+			State: RunCompleted, Metrics: RecordMatchRunMetrics{F1: 0.8}},
+		{ID: bson.NewObjectId(), RecordMatchSystemInterfaceID: sys1, MasterRecordSetID: set2, State: RunSent},
+		{ID: bson.NewObjectId(), RecordMatchSystemInterfaceID: sys2, MasterRecordSetID: set1, State: RunFailed},
+		{ID: bson.NewObjectId(), RecordMatchSystemInterfaceID: sys2, MasterRecordSetID: set2,
+			State: RunCompleted, Metrics: RecordMatchRunMetrics{F1: 0.6}},
+	}
+	results := NewBenchmarkResults(suite, execution, runs, map[bson.ObjectId]string{sys1: "FRIL"})
+	c.Assert(results.RunCount, Equals, 4)
+	c.Assert(results.FinishedCount, Equals, 3)
+	c.Assert(results.Complete, Equals, false)
+	c.Assert(results.States[RunCompleted], Equals, 2)
+	c.Assert(results.Systems[0].Name, Equals, "FRIL")
+	c.Assert(results.Cells[0][0].Metrics.F1, Equals, float32(0.8))
+	c.Assert(results.Cells[0][1].State, Equals, RunSent)
+	c.Assert(results.Cells[0][1].Metrics, IsNil)
+	c.Assert(results.Cells[1][0].RecordMatchRunID, Equals, runs[2].ID)
+	c.Assert(results.Cells[1][1].Metrics.F1, Equals, float32(0.6))
+
+	runs[1].State = RunCompleted
+	results = NewBenchmarkResults(suite, execution, runs, nil)
+	c.Assert(results.Complete, Equals, true)
+
+	// an execution that stopped early reports its error and the runs it has
+	execution.Error = "Dispatched 1 of 4 runs: no reachable servers"
+	results = NewBenchmarkResults(suite, execution, runs[:1], nil)
+	c.Assert(results.Error, Equals, execution.Error)
+	c.Assert(results.RunCount, Equals, 1)
+	c.Assert(results.Cells[1][1].RecordMatchRunID, Equals, bson.ObjectId(""))
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"bytes"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	. "gopkg.in/check.v1"
+)
+
+type BenchmarkFormatSuite struct {
+}
+
+var _ = Suite(&BenchmarkFormatSuite{})
+
+func (s *BenchmarkFormatSuite) TestONCSubmission(c *C) {
+	base := "http://localhost:3001/Patient/"
+	members := []fhir_models.BundleEntryComponent{
+		fhir_models.BundleEntryComponent{FullUrl: base + "a", Resource: &fhir_models.Patient{
+			Identifier: []fhir_models.Identifier{fhir_models.Identifier{System: ONCEnterpriseIDSystem, Value: "15374247"}}}},
+		fhir_models.BundleEntryComponent{FullUrl: base + "b", Resource: &fhir_models.Patient{
+			Identifier: []fhir_models.Identifier{fhir_models.Identifier{System: "urn:other", Value: "x"},
+				fhir_models.Identifier{System: ONCEnterpriseIDSystem, Value: "15374246"}}}},
+		fhir_models.BundleEntryComponent{FullUrl: base + "c", Resource: &fhir_models.Patient{}},
+	}
+	ids := IdentifierMap(members, ONCEnterpriseIDSystem)
+	c.Assert(ids, DeepEquals, map[string]string{base + "a": "15374247", base + "b": "15374246"})
+
+	links := []Link{
+		Link{Source: base + "a", Target: base + "b", Match: "probable", Score: 0.8},
+		Link{Source: base + "b", Target: base + "a", Match: "certain", Score: 0.95},
+		Link{Source: base + "a", Target: base + "c", Match: "possible", Score: 0.5},
+	}
+
+	pairs, missing := SubmissionPairs(links, ids, UnmappedSkip)
+	c.Assert(pairs, DeepEquals, []SubmissionPair{SubmissionPair{"15374246", "15374247", 0.95}})
+	c.Assert(missing, DeepEquals, []string{base + "c"})
+
+	pairs, _ = SubmissionPairs(links, ids, UnmappedResourceID)
+	c.Assert(len(pairs), Equals, 2)
+	c.Assert(pairs[0], DeepEquals, SubmissionPair{"15374246", "15374247", 0.95})
+	c.Assert(pairs[1], DeepEquals, SubmissionPair{"15374247", "c", 0.5})
+
+	var buf bytes.Buffer
+	c.Assert(WriteONCSubmission(&buf, pairs[:1], true), IsNil)
+	c.Assert(buf.String(), Equals, "EnterpriseID1,EnterpriseID2,MATCH_SCORE\n15374246,15374247,0.95\n")
+}
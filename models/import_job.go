@@ -0,0 +1,60 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// States of an ImportJob.
+const (
+	ImportJobRunning   = "running"
+	ImportJobCompleted = "completed"
+	ImportJobFailed    = "failed"
+)
+
+// MaxImportJobErrors is the number of per-record errors kept on an ImportJob;
+// further errors are counted but not recorded.
+const MaxImportJobErrors = 1000
+
+// ImportJob tracks the background import of records into a record set.
+type ImportJob struct {
+	ID          bson.ObjectId `bson:"_id,omitempty" json:"id,omitempty"`
+	Meta        *Meta         `bson:"meta,omitempty" json:"meta,omitempty"`
+	RecordSetID bson.ObjectId `bson:"recordSetId,omitempty" json:"recordSetId,omitempty"`
+	Status      string        `bson:"status,omitempty" json:"status,omitempty"`
+	// number of records of the record set's resource type in the upload
+	Total int `bson:"total" json:"total"`
+	// number of records in the upload of other resource types
+	Skipped   int `bson:"skipped" json:"skipped"`
+	Processed int `bson:"processed" json:"processed"`
+	Created   int `bson:"created" json:"created"`
+	Failed    int `bson:"failed" json:"failed"`
+	// the first MaxImportJobErrors records that could not be imported
+	Errors      []ImportRecordError `bson:"errors,omitempty" json:"errors,omitempty"`
+	Message     string              `bson:"message,omitempty" json:"message,omitempty"`
+	CompletedOn *time.Time          `bson:"completedOn,omitempty" json:"completedOn,omitempty"`
+}
+
+// ImportRecordError describes a record that could not be imported.
+type ImportRecordError struct {
+	// where the record was found in the upload (e.g., file and line)
+	Source  string `bson:"source" json:"source"`
+	Message string `bson:"message" json:"message"`
+}
@@ -28,4 +28,6 @@ type Meta struct {
 	LastUpdatedOn time.Time            `bson:"lastUpdatedOn,omitempty" json:"lastUpdatedOn,omitempty"`
 	Security      []fhir_models.Coding `bson:"security,omitempty" json:"security,omitempty"`
 	Tag           []fhir_models.Coding `bson:"tag,omitempty" json:"tag,omitempty"`
+	// Lineage records how a resource derived from other resources was created
+	Lineage *Lineage `bson:"lineage,omitempty" json:"lineage,omitempty"`
 }
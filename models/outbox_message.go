@@ -0,0 +1,140 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// States of an OutboxMessage.
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	// delivery was abandoned after the maximum number of attempts
+	OutboxDead = "dead"
+	// delivery was stopped, e.g., because the run was cancelled
+	OutboxCancelled = "cancelled"
+)
+
+// Descriptions of outbox messages, as they appear in a run's status.
+const (
+	OutboxRequest      = "Request"
+	OutboxCancellation = "Cancellation"
+)
+
+// Defaults for the delivery of outbox messages.
+const (
+	OutboxMaxAttempts    = 6
+	OutboxInitialBackoff = 5 * time.Second
+	OutboxMaxBackoff     = 10 * time.Minute
+)
+
+// OutboxMessage is a message waiting to be delivered, or that was delivered,
+// to a record matching system. Messages are delivered by a background worker
+// so that a slow or unavailable record matcher doesn't hold up the client
+// that created the message, and failed deliveries are retried with
+// exponential backoff.
+type OutboxMessage struct {
+	ID               bson.ObjectId `bson:"_id,omitempty" json:"id,omitempty"`
+	Meta             *Meta         `bson:"meta,omitempty" json:"meta,omitempty"`
+	RecordMatchRunID bson.ObjectId `bson:"recordMatchRunId,omitempty" json:"recordMatchRunId,omitempty"`
+	// describes the message (e.g., Request or Cancellation) in the run's status
+	Description string `bson:"description,omitempty" json:"description,omitempty"`
+	// the HTTP method with which the message is delivered; PUT if not set
+	Method      string `bson:"method,omitempty" json:"method,omitempty"`
+	Endpoint    string `bson:"endpoint" json:"endpoint"`
+	ContentType string `bson:"contentType" json:"contentType"`
+	Body        string `bson:"body" json:"body"`
+	Status      string `bson:"status" json:"status"`
+	MaxAttempts int    `bson:"maxAttempts" json:"maxAttempts"`
+	// when the next delivery attempt is due; while an attempt is in progress,
+	// the time after which the attempt is presumed to have been abandoned
+	NextAttemptOn time.Time         `bson:"nextAttemptOn" json:"nextAttemptOn"`
+	Attempts      []DeliveryAttempt `bson:"attempts,omitempty" json:"attempts,omitempty"`
+}
+
+// DeliveryAttempt records the outcome of one attempt to deliver a message.
+type DeliveryAttempt struct {
+	AttemptedOn time.Time `bson:"attemptedOn" json:"attemptedOn"`
+	StatusCode  int       `bson:"statusCode,omitempty" json:"statusCode,omitempty"`
+	Error       string    `bson:"error,omitempty" json:"error,omitempty"`
+	Delivered   bool      `bson:"delivered" json:"delivered"`
+}
+
+// NewOutboxMessage returns a message for the run, due for delivery now.
+func NewOutboxMessage(runID bson.ObjectId, description, method, endpoint, contentType string, body []byte, maxAttempts int) *OutboxMessage {
+	return &OutboxMessage{RecordMatchRunID: runID, Description: description, Method: method, Endpoint: endpoint,
+		ContentType: contentType, Body: string(body), Status: OutboxPending, MaxAttempts: maxAttempts,
+		NextAttemptOn: time.Now().Round(time.Millisecond)}
+}
+
+// RecordAttempt adds the attempt to the message and updates the message's
+// status. After a failed attempt, the next attempt is scheduled after a delay
+// that starts at initialBackoff and doubles with each attempt, up to
+// maxBackoff, unless the maximum number of attempts has been made, in which
+// case the message is dead-lettered.
+func (m *OutboxMessage) RecordAttempt(attempt DeliveryAttempt, initialBackoff, maxBackoff time.Duration) {
+	m.Attempts = append(m.Attempts, attempt)
+	switch {
+	case attempt.Delivered:
+		m.Status = OutboxDelivered
+	case len(m.Attempts) >= m.MaxAttempts:
+		m.Status = OutboxDead
+	default:
+		m.NextAttemptOn = attempt.AttemptedOn.Add(m.Backoff(initialBackoff, maxBackoff))
+	}
+}
+
+// Backoff returns the delay before the next attempt to deliver the message,
+// which starts at initialBackoff and doubles with each attempt made, up to
+// maxBackoff.
+func (m *OutboxMessage) Backoff(initialBackoff, maxBackoff time.Duration) time.Duration {
+	backoff := initialBackoff
+	for i := 1; i < len(m.Attempts) && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// RetryDelivery schedules another delivery of the run's most recently
+// delivered message with the description, e.g., because the record matcher
+// reported a transient error. Attempts made so far count toward the message's
+// maximum, and false is returned if no attempts remain.
+func RetryDelivery(db *mgo.Database, runID bson.ObjectId, description string) (bool, error) {
+	c := db.C(GetCollectionName("OutboxMessage"))
+	msg := &OutboxMessage{}
+	err := c.Find(bson.M{"recordMatchRunId": runID, "description": description, "status": OutboxDelivered}).
+		Sort("-meta.createdOn").One(msg)
+	if err != nil {
+		return false, err
+	}
+	if len(msg.Attempts) >= msg.MaxAttempts {
+		return false, nil
+	}
+	now := time.Now().Round(time.Millisecond)
+	err = c.Update(bson.M{"_id": msg.ID, "status": OutboxDelivered}, bson.M{"$set": bson.M{
+		"status":             OutboxPending,
+		"nextAttemptOn":      now.Add(msg.Backoff(OutboxInitialBackoff, OutboxMaxBackoff)),
+		"meta.lastUpdatedOn": now}})
+	return err == nil, err
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type OutboxMessageSuite struct {
+}
+
+var _ = Suite(&OutboxMessageSuite{})
+
+func (s *OutboxMessageSuite) TestRecordAttempt(c *C) {
+	msg := &OutboxMessage{Status: OutboxPending, MaxAttempts: 4}
+	start := time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	msg.RecordAttempt(DeliveryAttempt{AttemptedOn: start, Error: "connection refused"}, time.Second, 3*time.Second)
+	c.Assert(msg.Status, Equals, OutboxPending)
+	c.Assert(msg.NextAttemptOn, Equals, start.Add(time.Second))
+
+	msg.RecordAttempt(DeliveryAttempt{AttemptedOn: start, StatusCode: 503}, time.Second, 3*time.Second)
+	c.Assert(msg.NextAttemptOn, Equals, start.Add(2*time.Second))
+
+	// the delay is capped
+	msg.RecordAttempt(DeliveryAttempt{AttemptedOn: start, StatusCode: 503}, time.Second, 3*time.Second)
+	c.Assert(msg.NextAttemptOn, Equals, start.Add(3*time.Second))
+
+	msg.RecordAttempt(DeliveryAttempt{AttemptedOn: start, StatusCode: 503}, time.Second, 3*time.Second)
+	c.Assert(msg.Status, Equals, OutboxDead)
+	c.Assert(len(msg.Attempts), Equals, 4)
+}
+
+func (s *OutboxMessageSuite) TestRecordDelivery(c *C) {
+	msg := &OutboxMessage{Status: OutboxPending, MaxAttempts: 1}
+	msg.RecordAttempt(DeliveryAttempt{AttemptedOn: time.Now(), StatusCode: 200, Delivered: true}, time.Second, time.Minute)
+	c.Assert(msg.Status, Equals, OutboxDelivered)
+}
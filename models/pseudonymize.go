@@ -0,0 +1,221 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"sort"
+	"strings"
+	"unicode"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+)
+
+// DerivePseudonymize is the operation recorded in the lineage of a record set
+// created by pseudonymizing another record set.
+const DerivePseudonymize = "pseudonymize"
+
+// Letters are replaced within these groups, so vowels stay vowels.
+const (
+	pseudonymConsonants = "bcdfghjklmnpqrstvwxyz"
+	pseudonymVowels     = "aeiou"
+	pseudonymDigits     = "0123456789"
+)
+
+// Pseudonymizer replaces the direct identifiers of patients with values
+// derived from a secret key. Each letter or digit of a value is replaced by
+// another, using a substitution derived from the key and the kind of value
+// (e.g., name or phone number), so a value keeps its length, format and
+// capitalization. The mapping is deterministic, so a value is replaced by the
+// same pseudonym wherever it occurs (ignoring case), and since the
+// substitution is one-to-one, the pseudonyms of two values are as many edits
+// apart as the values are. Matching difficulty is therefore preserved: exact
+// matches still match, and near misses, such as typos, are as near as they
+// were. Like any fixed substitution, the mapping can be partly recovered by
+// frequency analysis of a large enough set of pseudonyms, so a pseudonymized
+// set hides identities from a casual reader, not a determined one.
+type Pseudonymizer struct {
+	key []byte
+}
+
+// NewPseudonymizer returns a Pseudonymizer using the given secret key.
+func NewPseudonymizer(key string) *Pseudonymizer {
+	return &Pseudonymizer{key: []byte(key)}
+}
+
+// Patient returns a copy of the patient in which names, identifiers,
+// addresses and telecom values are pseudonymized. The resource id, meta,
+// narrative, extensions (e.g., Synthea's mother's maiden name and birth
+// place), photos, contacts, links, and references to the patient's providers
+// and managing organization are dropped since they may identify the patient
+// or the original records. Demographics that are needed for matching, such
+// as gender and birth date, are kept.
+func (p *Pseudonymizer) Patient(patient *fhir_models.Patient) *fhir_models.Patient {
+	pseudo := *patient
+	pseudo.Id = ""
+	pseudo.Meta = nil
+	pseudo.Text = nil
+	pseudo.Contained = nil
+	pseudo.Extension = nil
+	pseudo.ModifierExtension = nil
+	pseudo.Photo = nil
+	pseudo.Contact = nil
+	pseudo.Link = nil
+	pseudo.CareProvider = nil
+	pseudo.ManagingOrganization = nil
+
+	pseudo.Name = make([]fhir_models.HumanName, len(patient.Name))
+	for i, name := range patient.Name {
+		pseudo.Name[i] = fhir_models.HumanName{Use: name.Use, Period: name.Period,
+			Text:   p.Text("name", name.Text),
+			Family: p.textList("name", name.Family),
+			Given:  p.textList("name", name.Given),
+			Prefix: name.Prefix,
+			Suffix: name.Suffix}
+	}
+
+	pseudo.Identifier = make([]fhir_models.Identifier, len(patient.Identifier))
+	for i, identifier := range patient.Identifier {
+		pseudo.Identifier[i] = identifier
+		pseudo.Identifier[i].Value = p.Code("identifier|"+identifier.System, identifier.Value)
+		pseudo.Identifier[i].Assigner = nil
+	}
+
+	pseudo.Address = make([]fhir_models.Address, len(patient.Address))
+	for i, address := range patient.Address {
+		pseudo.Address[i] = address
+		pseudo.Address[i].Text = p.Text("address", address.Text)
+		pseudo.Address[i].Line = p.textList("address", address.Line)
+		pseudo.Address[i].City = p.Text("city", address.City)
+		pseudo.Address[i].District = p.Text("district", address.District)
+		pseudo.Address[i].PostalCode = p.Code("postalCode", address.PostalCode)
+	}
+
+	pseudo.Telecom = make([]fhir_models.ContactPoint, len(patient.Telecom))
+	for i, telecom := range patient.Telecom {
+		pseudo.Telecom[i] = telecom
+		pseudo.Telecom[i].Value = p.Code("telecom|"+telecom.System, telecom.Value)
+	}
+
+	return &pseudo
+}
+
+// Text pseudonymizes free text, such as a name or street address. Letters
+// and digits are replaced, and spaces and punctuation are kept.
+func (p *Pseudonymizer) Text(kind, text string) string {
+	return p.substitute(kind, text)
+}
+
+// Code pseudonymizes an identifier such as an MRN or phone number. Letters
+// and digits are replaced, and punctuation is kept, so the value keeps its
+// format. Since each character is replaced on its own, the same number
+// written with different punctuation (e.g., 555-0100 and 5550100) has the
+// same digits in its pseudonym.
+func (p *Pseudonymizer) Code(kind, value string) string {
+	return p.substitute(kind, value)
+}
+
+func (p *Pseudonymizer) textList(kind string, texts []string) []string {
+	if texts == nil {
+		return nil
+	}
+	result := make([]string, len(texts))
+	for i, text := range texts {
+		result[i] = p.Text(kind, text)
+	}
+	return result
+}
+
+// substitute replaces each letter and digit of the value using the
+// substitution for the kind of value, keeping the capitalization.
+func (p *Pseudonymizer) substitute(kind, value string) string {
+	consonants := p.permute(kind, pseudonymConsonants)
+	vowels := p.permute(kind, pseudonymVowels)
+	digits := p.permute(kind, pseudonymDigits)
+
+	result := []rune(value)
+	for i, r := range result {
+		lower := unicode.ToLower(r)
+		var c rune
+		if j := strings.IndexRune(pseudonymConsonants, lower); j >= 0 {
+			c = consonants[j]
+		} else if j = strings.IndexRune(pseudonymVowels, lower); j >= 0 {
+			c = vowels[j]
+		} else if j = strings.IndexRune(pseudonymDigits, lower); j >= 0 {
+			c = digits[j]
+		} else if unicode.IsLetter(r) {
+			// other letters, e.g., accented ones, are each replaced by a
+			// consonant chosen by the key
+			c = rune(pseudonymConsonants[int(p.keyStream(kind, string(lower), 1)[0])%len(pseudonymConsonants)])
+		} else {
+			continue
+		}
+		if unicode.IsUpper(r) {
+			c = unicode.ToUpper(c)
+		}
+		result[i] = c
+	}
+	return string(result)
+}
+
+// permute returns the characters of the alphabet shuffled according to the
+// key and the kind of value.
+func (p *Pseudonymizer) permute(kind, alphabet string) []rune {
+	permuted := []rune(alphabet)
+	stream := p.keyStream(kind, alphabet, 2*len(permuted))
+	for i := len(permuted) - 1; i > 0; i-- {
+		j := (int(stream[2*i])<<8 | int(stream[2*i+1])) % (i + 1)
+		permuted[i], permuted[j] = permuted[j], permuted[i]
+	}
+	return permuted
+}
+
+// keyStream returns n bytes derived from the key, the kind of value and the
+// value, by chaining HMAC-SHA256 blocks.
+func (p *Pseudonymizer) keyStream(kind, value string, n int) []byte {
+	var stream []byte
+	for block := byte(0); len(stream) < n; block++ {
+		mac := hmac.New(sha256.New, p.key)
+		mac.Write([]byte(kind))
+		mac.Write([]byte{0, block})
+		mac.Write([]byte(value))
+		stream = mac.Sum(stream)
+	}
+	return stream[:n]
+}
+
+// RewritePairs returns the pairs with their records replaced according to
+// the mapping of old to new record URLs. Pairs including a record without a
+// mapping are dropped.
+func RewritePairs(pairs []RecordPair, urls map[string]string) []RecordPair {
+	seen := make(map[RecordPair]bool)
+	var rewritten []RecordPair
+	for _, pair := range pairs {
+		source, sourceOk := urls[pair.Source]
+		target, targetOk := urls[pair.Target]
+		if !sourceOk || !targetOk {
+			continue
+		}
+		if pair = NewRecordPair(source, target); !seen[pair] {
+			seen[pair] = true
+			rewritten = append(rewritten, pair)
+		}
+	}
+	sort.Sort(RecordPairSlice(rewritten))
+	return rewritten
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"encoding/json"
+	"strings"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	. "gopkg.in/check.v1"
+)
+
+type PseudonymizeSuite struct {
+}
+
+var _ = Suite(&PseudonymizeSuite{})
+
+func (s *PseudonymizeSuite) TestPatient(c *C) {
+	p := NewPseudonymizer("0123456789abcdef")
+	patient := &fhir_models.Patient{Gender: "male",
+		Name:       []fhir_models.HumanName{fhir_models.HumanName{Family: []string{"O'Brien"}, Given: []string{"Sean"}}},
+		Identifier: []fhir_models.Identifier{fhir_models.Identifier{System: "urn:mrn", Value: "MRN-00123"}},
+		Telecom:    []fhir_models.ContactPoint{fhir_models.ContactPoint{System: "phone", Value: "(555) 010-0100"}},
+		Address:    []fhir_models.Address{fhir_models.Address{Line: []string{"12 Main St"}, State: "MA", PostalCode: "01730"}},
+		Link:       []fhir_models.PatientLinkComponent{patientLink("seealso", "Patient/2")}}
+	patient.Id = "1"
+
+	pseudo := p.Patient(patient)
+	c.Assert(pseudo.Id, Equals, "")
+	c.Assert(pseudo.Link, IsNil)
+	c.Assert(pseudo.Gender, Equals, "male")
+	c.Assert(patient.Name[0].Family[0], Equals, "O'Brien")
+
+	family := pseudo.Name[0].Family[0]
+	c.Assert(family, Not(Equals), "O'Brien")
+	c.Assert(len(family), Equals, len("O'Brien"))
+	c.Assert(family[1], Equals, byte('\''))
+	c.Assert(family[0] >= 'A' && family[0] <= 'Z', Equals, true)
+
+	mrn := pseudo.Identifier[0].Value
+	c.Assert(mrn, Matches, "[A-Z]{3}-[0-9]{5}")
+	c.Assert(mrn, Not(Equals), "MRN-00123")
+	c.Assert(pseudo.Telecom[0].Value, Matches, `\([0-9]{3}\) [0-9]{3}-[0-9]{4}`)
+	c.Assert(pseudo.Address[0].Line[0], Matches, `[0-9]{2} [A-Z][a-z]{3} [A-Z][a-z]`)
+	c.Assert(pseudo.Address[0].State, Equals, "MA")
+
+	// the mapping is deterministic, ignores case and punctuation, and depends
+	// on the key
+	c.Assert(p.Text("name", "SEAN"), Equals, strings.ToUpper(pseudo.Name[0].Given[0]))
+	digits := strings.NewReplacer("(", "", ")", "", " ", "", "-", "").Replace(pseudo.Telecom[0].Value)
+	c.Assert(p.Code("telecom|phone", "5550100100"), Equals, digits)
+	c.Assert(NewPseudonymizer("fedcba9876543210").Text("name", "Sean"), Not(Equals), pseudo.Name[0].Given[0])
+}
+
+func (s *PseudonymizeSuite) TestNearMisses(c *C) {
+	p := NewPseudonymizer("0123456789abcdef")
+	// a one-letter typo stays one edit away, as do other near misses
+	for _, pair := range [][3]string{
+		{"name", "Jonathan", "Jonathon"},
+		{"name", "Smith", "Smyth"},
+		{"name", "Catherine", "Katherine"},
+		{"name", "Sean", "Shaun"},
+		{"address", "12 Main St", "21 Main St"},
+		{"telecom|phone", "555-010-0100", "555-010-0101"},
+	} {
+		kind, a, b := pair[0], pair[1], pair[2]
+		pa, pb := p.Text(kind, a), p.Text(kind, b)
+		c.Assert(pa, Not(Equals), a)
+		c.Assert(editDistance(pa, pb), Equals, editDistance(a, b), Commentf("%s and %s", a, b))
+	}
+	// transposed and dropped letters stay as near as they were
+	c.Assert(editDistance(p.Text("name", "Michael"), p.Text("name", "Micheal")), Equals, 2)
+	c.Assert(editDistance(p.Text("name", "Robert"), p.Text("name", "Robret")), Equals, 2)
+	c.Assert(editDistance(p.Text("name", "Patricia"), p.Text("name", "Patrica")), Equals, 1)
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func (s *PseudonymizeSuite) TestSyntheaPatient(c *C) {
+	// shaped like a patient generated by Synthea
+	data := `{
+		"resourceType": "Patient",
+		"id": "6a0e7f7c-1e7b-4f4d-9d8c-4d1e4c0f3b21",
+		"extension": [
+			{"url": "http://hl7.org/fhir/StructureDefinition/us-core-race",
+			 "valueCodeableConcept": {"coding": [{"system": "http://hl7.org/fhir/v3/Race", "code": "2106-3", "display": "White"}]}},
+			{"url": "http://hl7.org/fhir/StructureDefinition/patient-mothersMaidenName", "valueString": "Kathleen Whitcombe"},
+			{"url": "http://hl7.org/fhir/StructureDefinition/birthPlace",
+			 "valueAddress": {"city": "Marblehead", "state": "MA", "country": "US"}}
+		],
+		"identifier": [{"system": "https://github.com/synthetichealth/synthea", "value": "6a0e7f7c-1e7b-4f4d-9d8c-4d1e4c0f3b21"}],
+		"name": [{"use": "official", "family": ["Okuneva"], "given": ["Alvaro"], "prefix": ["Mr."]}],
+		"telecom": [{"system": "phone", "value": "555-247-8815", "use": "home"}],
+		"gender": "male",
+		"birthDate": "1962-08-14",
+		"address": [{
+			"extension": [{"url": "http://hl7.org/fhir/StructureDefinition/geolocation", "extension": [
+				{"url": "latitude", "valueDecimal": 42.4798},
+				{"url": "longitude", "valueDecimal": -70.8815}]}],
+			"line": ["1087 Feeney Throughway"], "city": "Swampscott", "state": "MA", "postalCode": "01907"}],
+		"careProvider": [{"reference": "Practitioner/aa8b3f5e-0c35-4a4c-9b0d-3d2c4e8f2a10"}],
+		"managingOrganization": {"reference": "Organization/e4b7c2a1-5f6d-4c3b-8a9e-7d6c5b4a3f21"}
+	}`
+	patient := &fhir_models.Patient{}
+	c.Assert(json.Unmarshal([]byte(data), patient), IsNil)
+	c.Assert(patient.Extension, HasLen, 3)
+
+	pseudo := NewPseudonymizer("0123456789abcdef").Patient(patient)
+	b, err := json.Marshal(pseudo)
+	c.Assert(err, IsNil)
+	for _, cleartext := range []string{"Whitcombe", "Marblehead", "42.4798", "70.8815", "Okuneva", "Alvaro",
+		"Feeney", "Swampscott", "01907", "247-8815", "6a0e7f7c", "Practitioner/", "Organization/", "extension"} {
+		c.Assert(strings.Contains(string(b), cleartext), Equals, false, Commentf("%s was not removed", cleartext))
+	}
+	c.Assert(pseudo.Gender, Equals, "male")
+	c.Assert(pseudo.BirthDate, NotNil)
+}
+
+func (s *PseudonymizeSuite) TestRewritePairs(c *C) {
+	pairs := []RecordPair{NewRecordPair("a", "b"), NewRecordPair("b", "c")}
+	urls := map[string]string{"a": "z", "b": "y"}
+	c.Assert(RewritePairs(pairs, urls), DeepEquals, []RecordPair{NewRecordPair("y", "z")})
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package models
 
 import (
+	"strings"
 	"time"
 
 	"gopkg.in/mgo.v2/bson"
@@ -29,4 +30,101 @@ type RecordMatchResponse struct {
 	Meta       *Meta               `bson:"meta,omitempty" json:"meta,omitempty"`
 	Message    *fhir_models.Bundle `bson:"message,omitempty" json:"message,omitempty"`
 	ReceivedOn time.Time           `bson:"receivedOn,omitempty" json:"receivedOn,omitempty"`
+	// received after the run's response deadline
+	Late bool `bson:"late,omitempty" json:"late,omitempty"`
+	// acknowledgement, results or error
+	Kind string `bson:"kind,omitempty" json:"kind,omitempty"`
+	// the MessageHeader response code: ok, transient-error or fatal-error
+	Code string `bson:"code,omitempty" json:"code,omitempty"`
+	// details of an error, from an OperationOutcome in the message
+	Issues []ResponseIssue `bson:"issues,omitempty" json:"issues,omitempty"`
+	// the part of a multi-part response carried by the message
+	Part *ResponsePart `bson:"part,omitempty" json:"part,omitempty"`
+}
+
+// Kinds of record match response.
+const (
+	ResponseAcknowledgement = "acknowledgement"
+	ResponseResults         = "results"
+	ResponseError           = "error"
+)
+
+// Codes with which a record matcher responds to a request.
+const (
+	ResponseOK             = "ok"
+	ResponseTransientError = "transient-error"
+	ResponseFatalError     = "fatal-error"
+)
+
+// ResponseIssue is an issue reported by a record matcher in an
+// OperationOutcome.
+type ResponseIssue struct {
+	Severity    string `bson:"severity,omitempty" json:"severity,omitempty"`
+	Code        string `bson:"code,omitempty" json:"code,omitempty"`
+	Diagnostics string `bson:"diagnostics,omitempty" json:"diagnostics,omitempty"`
+}
+
+func (i ResponseIssue) String() string {
+	s := i.Severity
+	if i.Code != "" {
+		s += " " + i.Code
+	}
+	if i.Diagnostics != "" {
+		s += ": " + i.Diagnostics
+	}
+	return strings.TrimSpace(s)
+}
+
+// ClassifyResponse works out what a record match response message means:
+// whether it's an error, carries results, or just acknowledges the request.
+// The code is the MessageHeader response code; a message without one is
+// treated as ok. An acknowledgement is a bare MessageHeader, so an ok message
+// with any other entries carries results, even if it reports no matches.
+// Issues are taken from the OperationOutcome referenced by the
+// response details or, failing that, any OperationOutcome in the message.
+func ClassifyResponse(msg *fhir_models.Bundle) (kind, code string, issues []ResponseIssue) {
+	code = ResponseOK
+	var details string
+	if len(msg.Entry) > 0 {
+		if msgHdr, ok := msg.Entry[0].Resource.(*fhir_models.MessageHeader); ok && msgHdr.Response != nil {
+			if msgHdr.Response.Code != "" {
+				code = msgHdr.Response.Code
+			}
+			if msgHdr.Response.Details != nil {
+				details = msgHdr.Response.Details.Reference
+			}
+		}
+	}
+
+	hasResults := len(msg.Entry) > 1
+	var outcome *fhir_models.OperationOutcome
+	for i, entry := range msg.Entry {
+		if i == 0 {
+			continue
+		}
+		if oo, ok := entry.Resource.(*fhir_models.OperationOutcome); ok {
+			if outcome == nil || (details != "" && (entry.FullUrl == details || "OperationOutcome/"+oo.Id == details)) {
+				outcome = oo
+			}
+		}
+	}
+	if outcome != nil {
+		for _, issue := range outcome.Issue {
+			ri := ResponseIssue{Severity: issue.Severity, Code: issue.Code, Diagnostics: issue.Diagnostics}
+			if ri.Diagnostics == "" && issue.Details != nil {
+				ri.Diagnostics = issue.Details.Text
+			}
+			issues = append(issues, ri)
+		}
+	}
+
+	switch {
+	case code != ResponseOK:
+		kind = ResponseError
+	case hasResults:
+		kind = ResponseResults
+	default:
+		kind = ResponseAcknowledgement
+	}
+	return kind, code, issues
 }
@@ -0,0 +1,94 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"encoding/json"
+	"os"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type RecordMatchResponseSuite struct {
+}
+
+var _ = Suite(&RecordMatchResponseSuite{})
+
+func (s *RecordMatchResponseSuite) TestClassifyAcknowledgement(c *C) {
+	msg := &fhir_models.Bundle{}
+	LoadResourceFromFile("../fixtures/record-match-ack-01.json", msg)
+
+	kind, code, issues := ClassifyResponse(msg)
+	c.Assert(kind, Equals, ResponseAcknowledgement)
+	c.Assert(code, Equals, ResponseOK)
+	c.Assert(issues, HasLen, 0)
+}
+
+func (s *RecordMatchResponseSuite) TestClassifyResults(c *C) {
+	data, err := os.Open("../fixtures/record-match-run-responses.json")
+	util.CheckErr(err)
+	defer data.Close()
+	run := &RecordMatchRun{}
+	util.CheckErr(json.NewDecoder(data).Decode(run))
+
+	// the matcher acknowledged the request, then sent the results
+	kind, _, _ := ClassifyResponse(run.Responses[0].Message)
+	c.Assert(kind, Equals, ResponseAcknowledgement)
+
+	kind, code, issues := ClassifyResponse(run.Responses[1].Message)
+	c.Assert(kind, Equals, ResponseResults)
+	c.Assert(code, Equals, ResponseOK)
+	c.Assert(issues, HasLen, 1)
+	c.Assert(issues[0].Diagnostics, Equals, "Deduplication Complete")
+}
+
+func (s *RecordMatchResponseSuite) TestClassifyEmptyResults(c *C) {
+	// the matcher found no matches, so only reports that it's done
+	msgHdr := &fhir_models.MessageHeader{Response: &fhir_models.MessageHeaderResponseComponent{Code: ResponseOK},
+		Data: []fhir_models.Reference{{Reference: "urn:uuid:1"}}}
+	outcome := &fhir_models.OperationOutcome{Issue: []fhir_models.OperationOutcomeIssueComponent{
+		{Severity: "information", Code: "informational", Diagnostics: "Deduplication Complete"}}}
+	msg := &fhir_models.Bundle{Type: "message", Entry: []fhir_models.BundleEntryComponent{
+		{FullUrl: "urn:uuid:0", Resource: msgHdr},
+		{FullUrl: "urn:uuid:1", Resource: outcome}}}
+
+	kind, code, issues := ClassifyResponse(msg)
+	c.Assert(kind, Equals, ResponseResults)
+	c.Assert(code, Equals, ResponseOK)
+	c.Assert(issues, HasLen, 1)
+}
+
+func (s *RecordMatchResponseSuite) TestClassifyError(c *C) {
+	msgHdr := &fhir_models.MessageHeader{Response: &fhir_models.MessageHeaderResponseComponent{
+		Code: ResponseFatalError, Details: &fhir_models.Reference{Reference: "urn:uuid:2"}}}
+	other := &fhir_models.OperationOutcome{Issue: []fhir_models.OperationOutcomeIssueComponent{
+		{Severity: "warning", Code: "informational", Diagnostics: "Unrelated"}}}
+	outcome := &fhir_models.OperationOutcome{Issue: []fhir_models.OperationOutcomeIssueComponent{
+		{Severity: "error", Code: "not-found", Details: &fhir_models.CodeableConcept{Text: "Unable to read the master record set"}}}}
+	msg := &fhir_models.Bundle{Type: "message", Entry: []fhir_models.BundleEntryComponent{
+		{FullUrl: "urn:uuid:0", Resource: msgHdr},
+		{FullUrl: "urn:uuid:1", Resource: other},
+		{FullUrl: "urn:uuid:2", Resource: outcome}}}
+
+	kind, code, issues := ClassifyResponse(msg)
+	c.Assert(kind, Equals, ResponseError)
+	c.Assert(code, Equals, ResponseFatalError)
+	c.Assert(issues, HasLen, 1)
+	c.Assert(issues[0].String(), Equals, "error not-found: Unable to read the master record set")
+}
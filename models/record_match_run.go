@@ -32,6 +32,29 @@ type RecordMatchRun struct {
 	Responses            []RecordMatchResponse           `bson:"responses,omitempty" json:"responses,omitempty"`
 	Metrics              RecordMatchRunMetrics           `bson:"metrics,omitempty" json:"metrics,omitempty"`
 	Status               []RecordMatchRunStatusComponent `bson:"status,omitempty" json:"status,omitempty"`
+	// where the run is in its lifecycle (e.g., queued or completed)
+	State string `bson:"state,omitempty" json:"state,omitempty"`
+	// seconds the record matcher has to respond once the request is sent;
+	// defaults to the response timeout of the system interface
+	ResponseTimeout int `bson:"responseTimeout,omitempty" json:"responseTimeout,omitempty"`
+	// when the run times out if the record matcher hasn't responded
+	ResponseDeadline time.Time `bson:"responseDeadline,omitempty" json:"responseDeadline,omitempty"`
+	// when the run timed out
+	TimedOutOn *time.Time `bson:"timedOutOn,omitempty" json:"timedOutOn,omitempty"`
+	// the run timed out after some, but not all, responses were received
+	PartialResponse bool `bson:"partialResponse,omitempty" json:"partialResponse,omitempty"`
+	// the parts received of a multi-part response
+	ResponseProgress *ResponseProgress `bson:"responseProgress,omitempty" json:"responseProgress,omitempty"`
+	// when the run was cancelled
+	CancelledOn *time.Time `bson:"cancelledOn,omitempty" json:"cancelledOn,omitempty"`
+	// the run that this run repeats, and the runs that repeat this run
+	RerunOfID bson.ObjectId   `bson:"rerunOfId,omitempty" json:"rerunOfId,omitempty"`
+	RerunIDs  []bson.ObjectId `bson:"rerunIds,omitempty" json:"rerunIds,omitempty"`
+	// the benchmark suite, and the execution of the suite, that created the run
+	BenchmarkSuiteID     bson.ObjectId `bson:"benchmarkSuiteId,omitempty" json:"benchmarkSuiteId,omitempty"`
+	BenchmarkExecutionID bson.ObjectId `bson:"benchmarkExecutionId,omitempty" json:"benchmarkExecutionId,omitempty"`
+	// the schedule that created the run
+	RunScheduleID bson.ObjectId `bson:"runScheduleId,omitempty" json:"runScheduleId,omitempty"`
 	// ideally, deduplication or query
 	MatchingMode string `bson:"matchingMode,omitempty" json:"matchingMode,omitempty"`
 	// fhir resource type of the records being matched (e.g., Patient)
@@ -40,6 +63,65 @@ type RecordMatchRun struct {
 	RecordMatchSystemInterfaceID bson.ObjectId `bson:"recordMatchSystemInterfaceId,omitempty" json:"recordMatchSystemInterfaceId,omitempty"`
 	MasterRecordSetID            bson.ObjectId `bson:"masterRecordSetId,omitempty" json:"masterRecordSetId,omitempty"`
 	QueryRecordSetID             bson.ObjectId `bson:"queryRecordSetId,omitempty" json:"queryRecordSetId,omitempty"`
+	// snapshots of the record set members when the run was created
+	MasterRecordSetSnapshotID bson.ObjectId `bson:"masterRecordSetSnapshotId,omitempty" json:"masterRecordSetSnapshotId,omitempty"`
+	QueryRecordSetSnapshotID  bson.ObjectId `bson:"queryRecordSetSnapshotId,omitempty" json:"queryRecordSetSnapshotId,omitempty"`
+}
+
+// NewRerun returns a new run with the configuration of the run: its context,
+// record matcher, matching mode and record sets. The new run is linked to
+// the run.
+func (rmr *RecordMatchRun) NewRerun() *RecordMatchRun {
+	rerun := rmr.configuration()
+	rerun.RerunOfID = rmr.ID
+	return rerun
+}
+
+// configuration returns a new run with the configuration of the run.
+func (rmr *RecordMatchRun) configuration() *RecordMatchRun {
+	return &RecordMatchRun{
+		Note:                         rmr.Note,
+		RecordMatchContextID:         rmr.RecordMatchContextID,
+		MatchingMode:                 rmr.MatchingMode,
+		RecordResourceType:           rmr.RecordResourceType,
+		RecordMatchSystemInterfaceID: rmr.RecordMatchSystemInterfaceID,
+		MasterRecordSetID:            rmr.MasterRecordSetID,
+		QueryRecordSetID:             rmr.QueryRecordSetID,
+		ResponseTimeout:              rmr.ResponseTimeout,
+	}
+}
+
+// configurationFields are the fields of a stored run set by configuration.
+var configurationFields = []string{"note", "recordMatchContextId", "matchingMode", "recordResourceType",
+	"recordMatchSystemInterfaceId", "masterRecordSetId", "queryRecordSetId", "responseTimeout"}
+
+// ConfigurationUpdate returns an update that replaces the configuration of a
+// stored run with the configuration of the run. The rest of the stored run,
+// such as its state, request, responses and metrics, is left alone.
+func (rmr *RecordMatchRun) ConfigurationUpdate() (bson.M, error) {
+	b, err := bson.Marshal(rmr.configuration())
+	if err != nil {
+		return nil, err
+	}
+	set := bson.M{}
+	if err = bson.Unmarshal(b, set); err != nil {
+		return nil, err
+	}
+	unset := bson.M{}
+	for _, field := range configurationFields {
+		if _, ok := set[field]; !ok {
+			unset[field] = ""
+		}
+	}
+
+	update := bson.M{"$currentDate": bson.M{"meta.lastUpdatedOn": bson.M{"$type": "timestamp"}}}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update, nil
 }
 
 // RecordMatchRunMetrics contains statistics associated with the results reported
@@ -57,7 +139,9 @@ type RecordMatchRunMetrics struct {
 }
 
 type RecordMatchRunStatusComponent struct {
-	Message   string    `bson:"message" json:"message"`
+	Message string `bson:"message" json:"message"`
+	// the state the run entered, if the status records a state change
+	State     string    `bson:"state,omitempty" json:"state,omitempty"`
 	CreatedOn time.Time `bson:"createdOn,omitempty" json:"createdOn,omitempty"`
 }
 
@@ -0,0 +1,172 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// States in the lifecycle of a RecordMatchRun.
+const (
+	// configured, but the request hasn't been submitted
+	RunDraft = "draft"
+	// the request is waiting to be delivered to the record matcher
+	RunQueued = "queued"
+	// the request was delivered to the record matcher
+	RunSent = "sent"
+	// the record matcher has acknowledged the request
+	RunAcknowledged = "acknowledged"
+	// some, but not all, of the results have been received
+	RunResponding = "responding"
+	RunCompleted  = "completed"
+	RunFailed     = "failed"
+	// the record matcher didn't respond in time
+	RunTimedOut  = "timed-out"
+	RunCancelled = "cancelled"
+)
+
+// runTransitions lists the states that a run may move to from each state.
+var runTransitions = map[string][]string{
+	RunDraft: {RunQueued, RunCancelled},
+	// a record matcher may respond before the delivery of the request is recorded
+	RunQueued:       {RunQueued, RunSent, RunAcknowledged, RunResponding, RunCompleted, RunFailed, RunCancelled},
+	RunSent:         {RunQueued, RunAcknowledged, RunResponding, RunCompleted, RunFailed, RunTimedOut, RunCancelled},
+	RunAcknowledged: {RunQueued, RunAcknowledged, RunResponding, RunCompleted, RunFailed, RunTimedOut, RunCancelled},
+	RunResponding:   {RunQueued, RunResponding, RunCompleted, RunFailed, RunTimedOut, RunCancelled},
+	RunCompleted:    {RunCompleted},
+	RunFailed:       {RunQueued},
+	RunTimedOut:     {RunQueued, RunResponding, RunCompleted, RunCancelled},
+	RunCancelled:    {},
+}
+
+// IsRunState reports whether the state is one of the run lifecycle states.
+func IsRunState(state string) bool {
+	_, ok := runTransitions[state]
+	return ok
+}
+
+// CanTransitionRun reports whether a run may move from one state to another.
+// Runs created before states were introduced have no state and may move to
+// any state.
+func CanTransitionRun(from, to string) bool {
+	if !IsRunState(to) {
+		return false
+	}
+	if from == "" {
+		return true
+	}
+	for _, s := range runTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// runStatesBefore returns the states from which a run may move to the state,
+// in a form suitable for a query.
+func runStatesBefore(to string) []interface{} {
+	// runs without a state
+	states := []interface{}{nil, ""}
+	for from := range runTransitions {
+		if CanTransitionRun(from, to) {
+			states = append(states, from)
+		}
+	}
+	return states
+}
+
+// RunTransitionError is returned when a run can't move to a state from the
+// state that it's in.
+type RunTransitionError struct {
+	From string
+	To   string
+}
+
+func (e *RunTransitionError) Error() string {
+	return fmt.Sprintf("a record match run can't go from %s to %s", e.From, e.To)
+}
+
+// TransitionRun moves the run to the state and adds the message to the run's
+// status, provided that the run's current state allows it. The check and the
+// update are a single operation, so concurrent transitions can't both
+// succeed. Other fields of the run may be set at the same time. If the run is
+// not in a state that allows the transition, a *RunTransitionError is
+// returned.
+func TransitionRun(db *mgo.Database, id bson.ObjectId, to, message string, set bson.M) error {
+	if !IsRunState(to) {
+		return fmt.Errorf("%s is not a record match run state", to)
+	}
+	now := time.Now().Round(time.Millisecond)
+	fields := bson.M{"state": to, "meta.lastUpdatedOn": now}
+	for k, v := range set {
+		fields[k] = v
+	}
+	update := bson.M{
+		"$set": fields,
+		"$push": bson.M{"status": RecordMatchRunStatusComponent{
+			Message: message, State: to, CreatedOn: now}}}
+	if to == RunQueued {
+		// the request is sent again, and the run gets a new deadline when
+		// it is, so an earlier timeout no longer applies
+		update["$unset"] = bson.M{"responseDeadline": "", "timedOutOn": "", "partialResponse": ""}
+	}
+	c := db.C(GetCollectionName("RecordMatchRun"))
+	err := c.Update(bson.M{"_id": id, "state": bson.M{"$in": runStatesBefore(to)}}, update)
+	if err != mgo.ErrNotFound {
+		return err
+	}
+	// either the run doesn't exist or it's in the wrong state
+	run := &RecordMatchRun{}
+	if err = c.FindId(id).Select(bson.M{"state": 1}).One(run); err != nil {
+		return err
+	}
+	return &RunTransitionError{From: run.State, To: to}
+}
+
+// AddRunStatus adds the message to the run's status without changing its
+// state.
+func AddRunStatus(db *mgo.Database, id bson.ObjectId, message string) error {
+	now := time.Now().Round(time.Millisecond)
+	return db.C(GetCollectionName("RecordMatchRun")).UpdateId(id, bson.M{
+		"$set": bson.M{"meta.lastUpdatedOn": now},
+		"$push": bson.M{"status": RecordMatchRunStatusComponent{
+			Message: message, CreatedOn: now}}})
+}
+
+// ResponseDeadline returns when a run sent at the time times out, given the
+// run's response timeout in seconds. The zero time is returned if the run has
+// no timeout.
+func ResponseDeadline(sentOn time.Time, timeout int) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return sentOn.Add(time.Duration(timeout) * time.Second)
+}
+
+// IsLate reports whether a response received at the time is late, that is,
+// the run has timed out or its current response deadline has passed.
+func (rmr *RecordMatchRun) IsLate(receivedOn time.Time) bool {
+	if rmr.State == RunTimedOut {
+		return true
+	}
+	return !rmr.ResponseDeadline.IsZero() && receivedOn.After(rmr.ResponseDeadline)
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type RecordMatchRunStateSuite struct {
+}
+
+var _ = Suite(&RecordMatchRunStateSuite{})
+
+func (s *RecordMatchRunStateSuite) TestCanTransitionRun(c *C) {
+	c.Assert(CanTransitionRun(RunDraft, RunQueued), Equals, true)
+	c.Assert(CanTransitionRun(RunQueued, RunSent), Equals, true)
+	c.Assert(CanTransitionRun(RunSent, RunCompleted), Equals, true)
+	c.Assert(CanTransitionRun(RunFailed, RunQueued), Equals, true)
+	// a record matcher may respond before the delivery of the request is
+	// recorded
+	c.Assert(CanTransitionRun(RunQueued, RunCompleted), Equals, true)
+
+	c.Assert(CanTransitionRun(RunDraft, RunSent), Equals, false)
+	c.Assert(CanTransitionRun(RunCompleted, RunQueued), Equals, false)
+	c.Assert(CanTransitionRun(RunCancelled, RunCompleted), Equals, false)
+	c.Assert(CanTransitionRun(RunSent, "done"), Equals, false)
+
+	// runs from before states were tracked
+	c.Assert(CanTransitionRun("", RunCompleted), Equals, true)
+}
+
+func (s *RecordMatchRunStateSuite) TestRunStatesBefore(c *C) {
+	states := runStatesBefore(RunSent)
+	c.Assert(states, HasLen, 3)
+	c.Assert(states[2], Equals, RunQueued)
+}
+
+func (s *RecordMatchRunStateSuite) TestIsLate(c *C) {
+	sentOn := time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)
+	run := &RecordMatchRun{State: RunSent, ResponseDeadline: ResponseDeadline(sentOn, 60)}
+	c.Assert(run.ResponseDeadline, Equals, sentOn.Add(time.Minute))
+	c.Assert(run.IsLate(sentOn.Add(30*time.Second)), Equals, false)
+	c.Assert(run.IsLate(sentOn.Add(2*time.Minute)), Equals, true)
+
+	// without a timeout, a response is never late
+	run = &RecordMatchRun{State: RunSent, ResponseDeadline: ResponseDeadline(sentOn, 0)}
+	c.Assert(run.IsLate(sentOn.Add(24*time.Hour)), Equals, false)
+
+	run.State = RunTimedOut
+	c.Assert(run.IsLate(sentOn), Equals, true)
+
+	// after an earlier timeout, a response to the resent request is on time
+	// if it meets the new deadline
+	timedOutOn := sentOn.Add(-time.Hour)
+	run = &RecordMatchRun{State: RunSent, TimedOutOn: &timedOutOn, ResponseDeadline: ResponseDeadline(sentOn, 60)}
+	c.Assert(run.IsLate(sentOn.Add(30*time.Second)), Equals, false)
+}
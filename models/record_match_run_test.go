@@ -6,6 +6,7 @@ import (
 
 	"github.com/pebbe/util"
 	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
 )
 
 type RecordMatchRunSuite struct {
@@ -41,3 +42,34 @@ func (r *RecordMatchRunSuite) TestGetLinks(c *C) {
 	c.Assert(lastLink.Target, Equals, "http://localhost:3001/Patient/57335da265ddb433bd30f0ee")
 	c.Assert(lastLink.Match, Equals, "probable")
 }
+
+func (r *RecordMatchRunSuite) TestNewRerun(c *C) {
+	rerun := r.Run.NewRerun()
+	c.Assert(rerun.RerunOfID, Equals, r.Run.ID)
+	c.Assert(rerun.MatchingMode, Equals, r.Run.MatchingMode)
+	c.Assert(rerun.MasterRecordSetID, Equals, r.Run.MasterRecordSetID)
+	c.Assert(rerun.RecordMatchSystemInterfaceID, Equals, r.Run.RecordMatchSystemInterfaceID)
+	// nothing from the original run's execution is copied
+	c.Assert(rerun.Request.Message, IsNil)
+	c.Assert(rerun.Responses, HasLen, 0)
+	c.Assert(rerun.State, Equals, "")
+}
+
+func (r *RecordMatchRunSuite) TestConfigurationUpdate(c *C) {
+	config := &RecordMatchRun{Note: "again", MatchingMode: Deduplication,
+		MasterRecordSetID: bson.NewObjectId(), ResponseTimeout: 60,
+		State: RunCompleted, Responses: []RecordMatchResponse{{}}}
+	update, err := config.ConfigurationUpdate()
+	c.Assert(err, IsNil)
+
+	set := update["$set"].(bson.M)
+	c.Assert(set["note"], Equals, "again")
+	c.Assert(set["masterRecordSetId"], Equals, config.MasterRecordSetID)
+	c.Assert(set["responseTimeout"], Equals, 60)
+	// configuration fields that aren't given are removed
+	c.Assert(update["$unset"].(bson.M)["queryRecordSetId"], Equals, "")
+	// the lifecycle of the stored run is left alone
+	c.Assert(set["state"], IsNil)
+	c.Assert(set["responses"], IsNil)
+	c.Assert(update["$unset"].(bson.M)["responses"], IsNil)
+}
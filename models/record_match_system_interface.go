@@ -17,6 +17,25 @@ package models
 
 import "gopkg.in/mgo.v2/bson"
 
+// Ways of delivering messages to a record matcher.
+const (
+	// PUT the message to the Bundle endpoint of the FHIR server
+	DeliveryBundlePut = "bundle-put"
+	// POST the message to the $process-message operation of the FHIR server
+	DeliveryProcessMessage = "process-message"
+	// POST the message to the server endpoint, as is
+	DeliveryPost = "post"
+)
+
+// IsDeliveryMode reports whether mode is a known way of delivering messages.
+func IsDeliveryMode(mode string) bool {
+	switch mode {
+	case DeliveryBundlePut, DeliveryProcessMessage, DeliveryPost:
+		return true
+	}
+	return false
+}
+
 type RecordMatchSystemInterface struct {
 	ID   bson.ObjectId `bson:"_id,omitempty" json:"id,omitempty"`
 	Meta *Meta         `bson:"meta,omitempty" json:"meta,omitempty"`
@@ -30,4 +49,10 @@ type RecordMatchSystemInterface struct {
 	ServerEndpoint string `bson:"serverEndpoint,omitempty" json:"serverEndpoint,omitempty"`
 	// address to which record match direct response messages
 	ResponseEndpoint string `bson:"responseEndpoint,omitempty" json:"responseEndpoint,omitempty"`
+	// seconds the record match system has to respond to a request before the
+	// run times out; runs never time out if it's not set
+	ResponseTimeout int `bson:"responseTimeout,omitempty" json:"responseTimeout,omitempty"`
+	// how messages are delivered to the server endpoint, and how the record
+	// matcher returns responses; defaults to bundle-put
+	DeliveryMode string `bson:"deliveryMode,omitempty" json:"deliveryMode,omitempty"`
 }
@@ -16,17 +16,95 @@ limitations under the License.
 package models
 
 import (
+	"errors"
+	"net/url"
+	"strings"
+
 	"gopkg.in/mgo.v2/bson"
 
 	fhir_models "github.com/intervention-engine/fhir/models"
 )
 
+// RecordSetTagSystem is the system of the FHIR tag that associates a resource
+// with a record set.
+const RecordSetTagSystem = "http://mitre.org/ptmatch/recordSet"
+
 type RecordSet struct {
-	ID           bson.ObjectId      `bson:"_id,omitempty" json:"id,omitempty"`
-	Meta         *Meta              `bson:"meta,omitempty" json:"meta,omitempty"`
-	Name         string             `bson:"name,omitempty" json:"name,omitempty"`
-	Description  string             `bson:"description,omitempty" json:"description,omitempty"`
-	ResourceType string             `bson:"resourceType,omitempty" json:"resourceType,omitempty"`
-	AnswerKey    fhir_models.Bundle `bson:"answerKey,omitempty" json:"answerKey,omitempty"`
-	Parameters *fhir_models.Parameters `bson:"parameters,omitempty" json:"parameters,omitempty"`
+	ID           bson.ObjectId           `bson:"_id,omitempty" json:"id,omitempty"`
+	Meta         *Meta                   `bson:"meta,omitempty" json:"meta,omitempty"`
+	Name         string                  `bson:"name,omitempty" json:"name,omitempty"`
+	Description  string                  `bson:"description,omitempty" json:"description,omitempty"`
+	ResourceType string                  `bson:"resourceType,omitempty" json:"resourceType,omitempty"`
+	AnswerKey    fhir_models.Bundle      `bson:"answerKey,omitempty" json:"answerKey,omitempty"`
+	Parameters   *fhir_models.Parameters `bson:"parameters,omitempty" json:"parameters,omitempty"`
+}
+
+// NewTaggedRecordSet returns a record set whose search expression selects the
+// resources of the given type, on the given FHIR server, that are tagged with
+// the record set's tag.
+func NewTaggedRecordSet(fhirURL, name, resourceType string) *RecordSet {
+	recSet := &RecordSet{Name: name, ResourceType: resourceType}
+
+	resourceURL := strings.TrimRight(fhirURL, "/") + "/" + resourceType
+	urlPcc := fhir_models.ParametersParameterComponent{Name: "resourceUrl", ValueString: resourceURL}
+	tagPcc := fhir_models.ParametersParameterComponent{Name: "_tag", ValueString: RecordSetTagCode(name)}
+	recSet.Parameters = &fhir_models.Parameters{
+		Parameter: []fhir_models.ParametersParameterComponent{urlPcc, tagPcc}}
+	return recSet
+}
+
+// RecordSetTagCode returns the tag code used for the members of the record
+// set with the given name.
+func RecordSetTagCode(name string) string {
+	return strings.Replace(name, " ", "", -1)
+}
+
+// TagCoding returns the FHIR tag that identifies the members of the record set.
+func (rs *RecordSet) TagCoding() fhir_models.Coding {
+	return fhir_models.Coding{System: RecordSetTagSystem, Code: rs.Parameter("_tag")}
+}
+
+// Parameter returns the value of the named parameter in the record set's
+// search expression. An empty string is returned if the parameter is absent.
+func (rs *RecordSet) Parameter(name string) string {
+	if rs.Parameters == nil {
+		return ""
+	}
+	for _, p := range rs.Parameters.Parameter {
+		if p.Name == name {
+			return p.ValueString
+		}
+	}
+	return ""
+}
+
+// SearchURL returns the FHIR search URL represented by the record set's
+// parameters; resourceUrl is the base and the remaining parameters are
+// added as search parameters.
+func (rs *RecordSet) SearchURL() (string, error) {
+	resourceURL := rs.Parameter("resourceUrl")
+	if resourceURL == "" {
+		return "", errors.New("Record set has no resourceUrl parameter")
+	}
+
+	query := url.Values{}
+	for _, p := range rs.Parameters.Parameter {
+		if p.Name != "resourceUrl" && p.ValueString != "" {
+			query.Add(p.Name, p.ValueString)
+		}
+	}
+	if len(query) == 0 {
+		return resourceURL, nil
+	}
+	return resourceURL + "?" + query.Encode(), nil
+}
+
+// BaseURL returns the base URL of the FHIR server holding the records in
+// the set, i.e., the resourceUrl without the trailing resource type.
+func (rs *RecordSet) BaseURL() string {
+	base := strings.TrimRight(rs.Parameter("resourceUrl"), "/")
+	if idx := strings.LastIndex(base, "/"); idx >= 0 && base[idx+1:] == rs.ResourceType {
+		base = base[:idx]
+	}
+	return base
 }
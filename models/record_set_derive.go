@@ -0,0 +1,230 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"gopkg.in/mgo.v2/bson"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+)
+
+// Operations that derive a record set from one or more existing record sets.
+const (
+	DeriveSample       = "sample"
+	DeriveSplit        = "split"
+	DeriveUnion        = "union"
+	DeriveIntersection = "intersection"
+)
+
+// Lineage describes the operation and sources from which a record set was
+// derived, along with the settings needed to reproduce it.
+type Lineage struct {
+	Operation    string          `bson:"operation" json:"operation"`
+	Sources      []bson.ObjectId `bson:"sources" json:"sources"`
+	SampleSize   int             `bson:"sampleSize,omitempty" json:"sampleSize,omitempty"`
+	TestFraction float64         `bson:"testFraction,omitempty" json:"testFraction,omitempty"`
+	// train or test, for the record sets created by a split
+	Partition string `bson:"partition,omitempty" json:"partition,omitempty"`
+	Seed      int64  `bson:"seed,omitempty" json:"seed,omitempty"`
+}
+
+// NewDerivedRecordSet returns a tagged record set, on the same FHIR server and
+// of the same resource type as the source, whose meta records the lineage.
+// The answer key is built from the source pairs between the given members.
+func NewDerivedRecordSet(source *RecordSet, name string, lineage *Lineage, members []string, pairs []RecordPair) *RecordSet {
+	recSet := NewTaggedRecordSet(source.BaseURL(), name, source.ResourceType)
+	recSet.Meta = &Meta{Lineage: lineage}
+	recSet.AnswerKey = *NewAnswerKey(recSet, RestrictPairs(pairs, members))
+	return recSet
+}
+
+// MemberURLs returns the full URLs of the given record set members.
+func MemberURLs(members []fhir_models.BundleEntryComponent) []string {
+	urls := make([]string, 0, len(members))
+	for _, member := range members {
+		urls = append(urls, member.FullUrl)
+	}
+	return urls
+}
+
+// SampleRecords returns n of the given records, chosen at random. All of the
+// records are returned if there are no more than n.
+func SampleRecords(urls []string, n int, rnd *rand.Rand) []string {
+	if n >= len(urls) {
+		return sortedCopy(urls)
+	}
+	sample := make([]string, n)
+	for i, j := range rnd.Perm(len(urls))[:n] {
+		sample[i] = urls[j]
+	}
+	sort.Strings(sample)
+	return sample
+}
+
+// SplitRecords divides the records into a train and a test partition, with
+// as close to testFraction of the records in the test partition as the
+// clusters allow. Records that the pairs declare to be matches are kept in the
+// same partition, and the split is stratified by cluster size so that both
+// partitions have a similar mix of unique and duplicated records. Each stratum
+// gets its share of test clusters rounded down, and the clusters left over are
+// given out by largest remainder while they bring the test partition closer
+// to its target size.
+func SplitRecords(urls []string, pairs []RecordPair, testFraction float64, rnd *rand.Rand) (train, test []string) {
+	// group the clusters (including singletons) by size
+	strata := make(map[int][][]string)
+	clustered := make(map[string]bool)
+	for _, cluster := range RecordClusters(RestrictPairs(pairs, urls)) {
+		strata[len(cluster)] = append(strata[len(cluster)], cluster)
+		for _, url := range cluster {
+			clustered[url] = true
+		}
+	}
+	for _, url := range sortedCopy(urls) {
+		if !clustered[url] {
+			strata[1] = append(strata[1], []string{url})
+		}
+	}
+
+	sizes := make([]int, 0, len(strata))
+	for size := range strata {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+
+	// the number of test clusters in each stratum
+	numTest := make(map[int]int)
+	remainders := make(map[int]float64)
+	target := float64(len(urls)) * testFraction
+	numRecords := 0
+	for _, size := range sizes {
+		share := float64(len(strata[size])) * testFraction
+		numTest[size] = int(math.Floor(share))
+		remainders[size] = share - math.Floor(share)
+		numRecords += numTest[size] * size
+	}
+	// order the strata by remainder, largest first, then by size
+	byRemainder := make([]int, 0, len(sizes))
+	for _, size := range sizes {
+		i := len(byRemainder)
+		for i > 0 && remainders[byRemainder[i-1]] < remainders[size] {
+			i--
+		}
+		byRemainder = append(byRemainder[:i], append([]int{size}, byRemainder[i:]...)...)
+	}
+	for _, size := range byRemainder {
+		if remainders[size] > 0 && math.Abs(float64(numRecords+size)-target) < math.Abs(float64(numRecords)-target) {
+			numTest[size]++
+			numRecords += size
+		}
+	}
+
+	for _, size := range sizes {
+		clusters := strata[size]
+		for i, j := range rnd.Perm(len(clusters)) {
+			if i < numTest[size] {
+				test = append(test, clusters[j]...)
+			} else {
+				train = append(train, clusters[j]...)
+			}
+		}
+	}
+	sort.Strings(train)
+	sort.Strings(test)
+	return train, test
+}
+
+// UnionRecords returns the records that are in any of the given sets.
+func UnionRecords(sets ...[]string) []string {
+	seen := make(map[string]bool)
+	var union []string
+	for _, set := range sets {
+		for _, url := range set {
+			if !seen[url] {
+				seen[url] = true
+				union = append(union, url)
+			}
+		}
+	}
+	sort.Strings(union)
+	return union
+}
+
+// IntersectRecords returns the records that are in all of the given sets.
+func IntersectRecords(sets ...[]string) []string {
+	if len(sets) == 0 {
+		return nil
+	}
+	counts := make(map[string]int)
+	for _, set := range sets {
+		for _, url := range UnionRecords(set) {
+			counts[url]++
+		}
+	}
+	var intersection []string
+	for url, count := range counts {
+		if count == len(sets) {
+			intersection = append(intersection, url)
+		}
+	}
+	sort.Strings(intersection)
+	return intersection
+}
+
+// RestrictPairs returns the distinct pairs in which both records are among
+// the given records.
+func RestrictPairs(pairs []RecordPair, urls []string) []RecordPair {
+	inSet := make(map[string]bool)
+	for _, url := range urls {
+		inSet[url] = true
+	}
+	seen := make(map[RecordPair]bool)
+	var restricted []RecordPair
+	for _, pair := range pairs {
+		if inSet[pair.Source] && inSet[pair.Target] && !seen[pair] {
+			seen[pair] = true
+			restricted = append(restricted, pair)
+		}
+	}
+	sort.Sort(RecordPairSlice(restricted))
+	return restricted
+}
+
+// FilterMembers returns the members whose full URL is among the given records.
+func FilterMembers(members []fhir_models.BundleEntryComponent, urls []string) []fhir_models.BundleEntryComponent {
+	inSet := make(map[string]bool)
+	for _, url := range urls {
+		inSet[url] = true
+	}
+	var filtered []fhir_models.BundleEntryComponent
+	for _, member := range members {
+		if inSet[member.FullUrl] {
+			filtered = append(filtered, member)
+			delete(inSet, member.FullUrl)
+		}
+	}
+	return filtered
+}
+
+func sortedCopy(urls []string) []string {
+	sorted := append([]string{}, urls...)
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"fmt"
+	"math/rand"
+
+	. "gopkg.in/check.v1"
+)
+
+type RecordSetDeriveSuite struct {
+}
+
+var _ = Suite(&RecordSetDeriveSuite{})
+
+func (s *RecordSetDeriveSuite) TestSplitRecordsKeepsClusters(c *C) {
+	urls := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	pairs := []RecordPair{NewRecordPair("a", "b"), NewRecordPair("b", "c"),
+		NewRecordPair("d", "e"), NewRecordPair("f", "g"), NewRecordPair("x", "y")}
+
+	train, test := SplitRecords(urls, pairs, 0.5, rand.New(rand.NewSource(1)))
+	c.Assert(len(train)+len(test), Equals, len(urls))
+	c.Assert(UnionRecords(train, test), DeepEquals, urls)
+
+	inTest := make(map[string]bool)
+	for _, url := range test {
+		inTest[url] = true
+	}
+	for _, pair := range RestrictPairs(pairs, urls) {
+		c.Assert(inTest[pair.Source], Equals, inTest[pair.Target])
+	}
+	// one of the two clusters of two and two of the three singletons (h, i,
+	// j) are placed in the test partition; adding the cluster of three as well
+	// would overshoot the target of five records by more
+	c.Assert(len(test), Equals, 4)
+	c.Assert(len(RestrictPairs(pairs, test)), Equals, 1)
+}
+
+func (s *RecordSetDeriveSuite) TestSplitRecordsHonorsFraction(c *C) {
+	// 15 clusters of two and 15 singletons; rounding each stratum on its own
+	// would put 8 clusters and 8 singletons, 24 of 45 records, in the test
+	// partition, rather than the 22 or 23 records closest to half
+	var urls []string
+	var pairs []RecordPair
+	for i := 0; i < 15; i++ {
+		a, b, single := fmt.Sprintf("a%02d", i), fmt.Sprintf("b%02d", i), fmt.Sprintf("s%02d", i)
+		urls = append(urls, a, b, single)
+		pairs = append(pairs, NewRecordPair(a, b))
+	}
+
+	_, test := SplitRecords(urls, pairs, 0.5, rand.New(rand.NewSource(1)))
+	c.Assert(len(test), Equals, 22)
+}
+
+func (s *RecordSetDeriveSuite) TestSampleRecords(c *C) {
+	urls := []string{"d", "c", "b", "a"}
+	sample := SampleRecords(urls, 2, rand.New(rand.NewSource(1)))
+	c.Assert(len(sample), Equals, 2)
+	c.Assert(IntersectRecords(sample, urls), DeepEquals, sample)
+	c.Assert(SampleRecords(urls, 10, rand.New(rand.NewSource(1))), DeepEquals, []string{"a", "b", "c", "d"})
+}
+
+func (s *RecordSetDeriveSuite) TestCombineRecords(c *C) {
+	c.Assert(UnionRecords([]string{"b", "a"}, []string{"c", "b"}), DeepEquals, []string{"a", "b", "c"})
+	c.Assert(IntersectRecords([]string{"b", "a"}, []string{"c", "b"}), DeepEquals, []string{"b"})
+	c.Assert(RestrictPairs([]RecordPair{NewRecordPair("a", "b"), NewRecordPair("b", "c"), NewRecordPair("b", "a")},
+		[]string{"a", "b"}), DeepEquals, []RecordPair{NewRecordPair("a", "b")})
+}
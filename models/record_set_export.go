@@ -0,0 +1,144 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+)
+
+// Names of the files in a record set export archive.
+const (
+	ExportMembersFile      = "members.ndjson"
+	ExportAnswerKeyFile    = "answer-key.json"
+	ExportAnswerKeyCSVFile = "answer-key.csv"
+	ExportManifestFile     = "manifest.json"
+)
+
+// ExportManifest describes the content of a record set export archive.
+type ExportManifest struct {
+	RecordSetID  bson.ObjectId `json:"recordSetId"`
+	Name         string        `json:"name"`
+	Description  string        `json:"description,omitempty"`
+	ResourceType string        `json:"resourceType"`
+	SearchURL    string        `json:"searchUrl"`
+	ExportedOn   time.Time     `json:"exportedOn"`
+	RecordCount  int           `json:"recordCount"`
+	PairCount    int           `json:"pairCount"`
+	Files        []ExportFile  `json:"files"`
+}
+
+// ExportFile describes one file in a record set export archive.
+type ExportFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// WriteRecordSetExport writes a zip archive holding the members of the record
+// set as NDJSON, its answer key as a FHIR Bundle and as a CSV list of pairs,
+// and a manifest listing the counts and the checksum of each file.
+func WriteRecordSetExport(w io.Writer, recSet *RecordSet, members []fhir_models.BundleEntryComponent) (*ExportManifest, error) {
+	searchURL, _ := recSet.SearchURL()
+	pairs := AnswerKeyPairs(&recSet.AnswerKey)
+	manifest := &ExportManifest{RecordSetID: recSet.ID, Name: recSet.Name,
+		Description: recSet.Description, ResourceType: recSet.ResourceType, SearchURL: searchURL,
+		ExportedOn: time.Now().Round(time.Millisecond), RecordCount: len(members), PairCount: len(pairs)}
+
+	archive := zip.NewWriter(w)
+
+	err := writeExportFile(archive, manifest, ExportMembersFile, func(fw io.Writer) error {
+		enc := json.NewEncoder(fw)
+		for _, member := range members {
+			if err := enc.Encode(member.Resource); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeExportFile(archive, manifest, ExportAnswerKeyFile, func(fw io.Writer) error {
+		return json.NewEncoder(fw).Encode(&recSet.AnswerKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeExportFile(archive, manifest, ExportAnswerKeyCSVFile, func(fw io.Writer) error {
+		cw := csv.NewWriter(fw)
+		cw.Write([]string{"source", "target"})
+		for _, pair := range pairs {
+			cw.Write([]string{pair.Source, pair.Target})
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the manifest describes the other files, so it is written last
+	fw, err := archive.Create(ExportManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, archive.Close()
+}
+
+// writeExportFile adds a file to the archive and records its size and
+// checksum in the manifest.
+func writeExportFile(archive *zip.Writer, manifest *ExportManifest, name string, write func(io.Writer) error) error {
+	fw, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	counter := &countingWriter{}
+	if err = write(io.MultiWriter(fw, hash, counter)); err != nil {
+		return err
+	}
+	manifest.Files = append(manifest.Files, ExportFile{Name: name, Size: counter.n,
+		SHA256: hex.EncodeToString(hash.Sum(nil))})
+	return nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
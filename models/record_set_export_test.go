@@ -0,0 +1,72 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	. "gopkg.in/check.v1"
+)
+
+type RecordSetExportSuite struct {
+}
+
+var _ = Suite(&RecordSetExportSuite{})
+
+func (s *RecordSetExportSuite) TestWriteRecordSetExport(c *C) {
+	base := "http://localhost:3001/Patient/"
+	recSet := NewTaggedRecordSet("http://localhost:3001", "Export Test", "Patient")
+	recSet.AnswerKey = *NewAnswerKey(recSet, []RecordPair{NewRecordPair(base+"2", base+"1")})
+	members := []fhir_models.BundleEntryComponent{
+		fhir_models.BundleEntryComponent{FullUrl: base + "1", Resource: &fhir_models.Patient{Gender: "female"}},
+		fhir_models.BundleEntryComponent{FullUrl: base + "2", Resource: &fhir_models.Patient{Gender: "female"}},
+	}
+
+	var buf bytes.Buffer
+	manifest, err := WriteRecordSetExport(&buf, recSet, members)
+	c.Assert(err, IsNil)
+	c.Assert(manifest.RecordCount, Equals, 2)
+	c.Assert(manifest.PairCount, Equals, 1)
+	c.Assert(len(manifest.Files), Equals, 3)
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	c.Assert(err, IsNil)
+	contents := make(map[string][]byte)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		c.Assert(err, IsNil)
+		contents[f.Name], err = ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		r.Close()
+	}
+	c.Assert(len(contents), Equals, 4)
+	c.Assert(contents[ExportManifestFile], NotNil)
+
+	for _, file := range manifest.Files {
+		sum := sha256.Sum256(contents[file.Name])
+		c.Assert(file.SHA256, Equals, hex.EncodeToString(sum[:]))
+		c.Assert(file.Size, Equals, int64(len(contents[file.Name])))
+	}
+	c.Assert(strings.Count(string(contents[ExportMembersFile]), "\n"), Equals, 2)
+	c.Assert(string(contents[ExportAnswerKeyCSVFile]), Equals, "source,target\n"+base+"1,"+base+"2\n")
+}
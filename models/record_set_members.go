@@ -0,0 +1,251 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+
+	"github.com/Sirupsen/logrus"
+	fhir_models "github.com/intervention-engine/fhir/models"
+
+	ptm_http "github.com/mitre/ptmatch/http"
+	logger "github.com/mitre/ptmatch/logger"
+)
+
+// LoadRecordSetMembers executes the search expression of the given record set
+// and returns one bundle entry for each resource in the set. Paged search
+// results are followed until the last page is retrieved.
+func LoadRecordSetMembers(recSet *RecordSet) ([]fhir_models.BundleEntryComponent, error) {
+	searchURL, err := recSet.SearchURL()
+	if err != nil {
+		return nil, err
+	}
+
+	var members []fhir_models.BundleEntryComponent
+	for searchURL != "" {
+		logger.Log.WithFields(
+			logrus.Fields{"record set": recSet.ID, "url": searchURL}).Debug("LoadRecordSetMembers")
+
+		page, err := searchPage(searchURL)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range page.Entry {
+			// ignore included resources and operation outcomes
+			if entry.Search != nil && entry.Search.Mode != "" && entry.Search.Mode != "match" {
+				continue
+			}
+			if entry.FullUrl == "" {
+				entry.FullUrl = recSet.BaseURL() + "/" + recSet.ResourceType + "/" + ResourceID(entry.Resource)
+			}
+			members = append(members, entry)
+		}
+
+		searchURL = ""
+		for _, link := range page.Link {
+			if link.Relation == "next" {
+				searchURL = link.Url
+			}
+		}
+	}
+
+	return members, nil
+}
+
+// LoadSnapshotMembers returns one bundle entry for each member of the
+// snapshot, holding the resource as it was when the snapshot was taken. Each
+// member is read at the version recorded in the snapshot; a member without a
+// recorded version is read as it is now.
+func LoadSnapshotMembers(snapshot *RecordSetSnapshot) ([]fhir_models.BundleEntryComponent, error) {
+	members := make([]fhir_models.BundleEntryComponent, 0, len(snapshot.Members))
+	for _, member := range snapshot.Members {
+		url := member.URL
+		if member.VersionID != "" {
+			url += "/_history/" + member.VersionID
+		}
+		resource, err := readResource(url)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, fhir_models.BundleEntryComponent{FullUrl: member.URL, Resource: resource})
+	}
+	return members, nil
+}
+
+func readResource(url string) (interface{}, error) {
+	resp, err := ptm_http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Unable to read record set member [" + resp.Status + "]: " + url)
+	}
+
+	var m map[string]interface{}
+	if err = json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, err
+	}
+	resource := fhir_models.MapToResource(m, true)
+	if resource == nil {
+		return nil, errors.New("Unknown resource type of record set member: " + url)
+	}
+	return resource, nil
+}
+
+func searchPage(searchURL string) (*fhir_models.Bundle, error) {
+	resp, err := ptm_http.Get(searchURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Unable to search record set [" + resp.Status + "]: " + searchURL)
+	}
+
+	page := &fhir_models.Bundle{}
+	if err = json.NewDecoder(resp.Body).Decode(page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// TagRecordSetMembers adds the given tag to each of the members, updating the
+// resources in place on the FHIR server so that they can be found with the
+// search expression of another record set. Members that already carry the
+// tag are left untouched. If a member can't be tagged, the tag is removed
+// from the members tagged so far.
+func TagRecordSetMembers(tag fhir_models.Coding, members []fhir_models.BundleEntryComponent) error {
+	var tagged []fhir_models.BundleEntryComponent
+	for _, member := range members {
+		resource, err := resourceMap(member.Resource)
+		if err == nil && TagResource(resource, tag) {
+			err = putResource(member.FullUrl, resource)
+			tagged = append(tagged, member)
+		}
+		if err != nil {
+			if uerr := UntagRecordSetMembers(tag, tagged); uerr != nil {
+				logger.Log.WithFields(
+					logrus.Fields{"method": "TagRecordSetMembers", "err": uerr}).Warn("Unable to remove tag after failure")
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// UntagRecordSetMembers removes the given tag from each of the members,
+// updating the resources in place on the FHIR server. It undoes
+// TagRecordSetMembers, given the same members.
+func UntagRecordSetMembers(tag fhir_models.Coding, members []fhir_models.BundleEntryComponent) error {
+	for _, member := range members {
+		resource, err := resourceMap(member.Resource)
+		if err != nil {
+			return err
+		}
+		UntagResource(resource, tag)
+		if err = putResource(member.FullUrl, resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveRecordSetMembers removes the members of a tagged record set from the
+// FHIR server. Members that also carry the tag of another record set (e.g.,
+// one derived from this record set) are kept, and only the record set's tag
+// is removed from them.
+func RemoveRecordSetMembers(recSet *RecordSet, members []fhir_models.BundleEntryComponent) (deleted, untagged int, err error) {
+	tag := recSet.TagCoding()
+	for _, member := range members {
+		resource, err := resourceMap(member.Resource)
+		if err != nil {
+			return deleted, untagged, err
+		}
+
+		UntagResource(resource, tag)
+		if HasRecordSetTag(resource) {
+			if err = putResource(member.FullUrl, resource); err != nil {
+				return deleted, untagged, err
+			}
+			untagged++
+			continue
+		}
+
+		resp, err := ptm_http.Delete(member.FullUrl)
+		if err != nil {
+			return deleted, untagged, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+			return deleted, untagged, errors.New("Unable to delete record set member [" + resp.Status + "]: " + member.FullUrl)
+		}
+		deleted++
+	}
+	return deleted, untagged, nil
+}
+
+// resourceMap converts a FHIR resource to its generic JSON representation.
+func resourceMap(resource interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+// putResource updates the resource at the given URL on the FHIR server.
+func putResource(url string, resource map[string]interface{}) error {
+	b, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	resp, err := ptm_http.Put(url, "application/json+fhir", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return errors.New("Unable to update record set member [" + resp.Status + "]: " + url)
+	}
+	return nil
+}
+
+// ResourceID returns the logical identifier of the given FHIR resource.
+func ResourceID(resource interface{}) string {
+	r := reflect.ValueOf(resource)
+	for r.Kind() == reflect.Ptr || r.Kind() == reflect.Interface {
+		r = r.Elem()
+	}
+	if r.Kind() != reflect.Struct {
+		return ""
+	}
+	id := r.FieldByName("Id")
+	if !id.IsValid() || id.Kind() != reflect.String {
+		return ""
+	}
+	return id.String()
+}
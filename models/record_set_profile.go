@@ -0,0 +1,195 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"encoding/json"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+)
+
+// RecordSetProfile summarizes the content of a record set, to help judge
+// how difficult the set is to match and whether two sets are comparable.
+type RecordSetProfile struct {
+	RecordSetID  bson.ObjectId `json:"recordSetId,omitempty"`
+	ResourceType string        `json:"resourceType,omitempty"`
+	RecordCount  int           `json:"recordCount"`
+	// fraction of records in which each element (or sub-element) is present
+	Completeness map[string]float64 `json:"completeness"`
+	// the following distributions are only reported for Patient records
+	Gender     map[string]int    `json:"gender,omitempty"`
+	BirthYear  map[int]int       `json:"birthYear,omitempty"`
+	NameLength map[int]int       `json:"nameLength,omitempty"`
+	AnswerKey  *AnswerKeyProfile `json:"answerKey,omitempty"`
+}
+
+// AnswerKeyProfile summarizes the matches declared in a record set's answer key.
+type AnswerKeyProfile struct {
+	PairCount int `json:"pairCount"`
+	// number of records that match at least one other record
+	DuplicateRecordCount int `json:"duplicateRecordCount"`
+	// fraction of the records that match at least one other record
+	DuplicateRate float64 `json:"duplicateRate"`
+	ClusterCount  int     `json:"clusterCount"`
+	// number of clusters of each size; records without matches are
+	// clusters of size one
+	ClusterSizes map[int]int `json:"clusterSizes"`
+	// number of records referenced by the answer key that are not members
+	// of the record set
+	UnknownRecordCount int `json:"unknownRecordCount"`
+}
+
+// NewRecordSetProfile computes the profile of a record set from its members.
+func NewRecordSetProfile(recSet *RecordSet, members []fhir_models.BundleEntryComponent) *RecordSetProfile {
+	profile := &RecordSetProfile{RecordSetID: recSet.ID, ResourceType: recSet.ResourceType,
+		RecordCount: len(members), Completeness: make(map[string]float64)}
+
+	counts := make(map[string]int)
+	for _, member := range members {
+		for element := range presentElements(member.Resource) {
+			counts[element]++
+		}
+
+		patient, ok := member.Resource.(*fhir_models.Patient)
+		if !ok {
+			continue
+		}
+		if profile.Gender == nil {
+			profile.Gender = make(map[string]int)
+			profile.BirthYear = make(map[int]int)
+			profile.NameLength = make(map[int]int)
+		}
+		gender := patient.Gender
+		if gender == "" {
+			gender = "missing"
+		}
+		profile.Gender[gender]++
+		if patient.BirthDate != nil {
+			profile.BirthYear[patient.BirthDate.Time.Year()]++
+		}
+		if len(patient.Name) > 0 {
+			name := append(append([]string{}, patient.Name[0].Given...), patient.Name[0].Family...)
+			profile.NameLength[len(strings.Join(name, " "))]++
+		}
+	}
+	for element, count := range counts {
+		profile.Completeness[element] = float64(count) / float64(len(members))
+	}
+
+	if len(recSet.AnswerKey.Entry) > 0 {
+		profile.AnswerKey = newAnswerKeyProfile(&recSet.AnswerKey, members)
+	}
+	return profile
+}
+
+func newAnswerKeyProfile(answerKey *fhir_models.Bundle, members []fhir_models.BundleEntryComponent) *AnswerKeyProfile {
+	pairs := AnswerKeyPairs(answerKey)
+	akProfile := &AnswerKeyProfile{PairCount: len(pairs), ClusterSizes: make(map[int]int)}
+
+	inSet := make(map[string]bool)
+	for _, member := range members {
+		inSet[member.FullUrl] = true
+	}
+
+	matched := make(map[string]bool)
+	for _, cluster := range RecordClusters(pairs) {
+		size := 0
+		for _, url := range cluster {
+			if inSet[url] {
+				size++
+				matched[url] = true
+			} else {
+				akProfile.UnknownRecordCount++
+			}
+		}
+		if size > 1 {
+			akProfile.DuplicateRecordCount += size
+		}
+		if size > 0 {
+			akProfile.ClusterSizes[size]++
+		}
+	}
+	// records not mentioned in the answer key are singletons
+	for url := range inSet {
+		if !matched[url] {
+			akProfile.ClusterSizes[1]++
+		}
+	}
+	for _, count := range akProfile.ClusterSizes {
+		akProfile.ClusterCount += count
+	}
+	if len(members) > 0 {
+		akProfile.DuplicateRate = float64(akProfile.DuplicateRecordCount) / float64(len(members))
+	}
+	return akProfile
+}
+
+// presentElements returns the names of the top-level elements of a resource,
+// and the sub-elements of complex elements (e.g., name.family), that have a
+// value. Elements that describe the resource rather than the record, such as
+// id and meta, are not included.
+func presentElements(resource interface{}) map[string]bool {
+	present := make(map[string]bool)
+	b, _ := json.Marshal(resource)
+	var m map[string]interface{}
+	if json.Unmarshal(b, &m) != nil {
+		return present
+	}
+	for name, value := range m {
+		switch name {
+		case "resourceType", "id", "meta", "text", "contained":
+			continue
+		}
+		if isEmptyValue(value) {
+			continue
+		}
+		present[name] = true
+
+		// record the sub-elements of complex (or lists of complex) values
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, v := range values {
+			if sub, ok := v.(map[string]interface{}); ok {
+				for subName, subValue := range sub {
+					if !isEmptyValue(subValue) {
+						present[name+"."+subName] = true
+					}
+				}
+			}
+		}
+	}
+	return present
+}
+
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
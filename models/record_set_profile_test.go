@@ -0,0 +1,70 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"time"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	. "gopkg.in/check.v1"
+)
+
+type RecordSetProfileSuite struct {
+}
+
+var _ = Suite(&RecordSetProfileSuite{})
+
+func (s *RecordSetProfileSuite) TestNewRecordSetProfile(c *C) {
+	base := "http://localhost:3001/Patient/"
+	birthDate := &fhir_models.FHIRDateTime{Time: time.Date(1980, 5, 1, 0, 0, 0, 0, time.UTC), Precision: fhir_models.Date}
+	members := []fhir_models.BundleEntryComponent{
+		fhir_models.BundleEntryComponent{FullUrl: base + "1", Resource: &fhir_models.Patient{
+			Gender: "female", BirthDate: birthDate,
+			Name: []fhir_models.HumanName{fhir_models.HumanName{Given: []string{"Ann"}, Family: []string{"Lee"}}}}},
+		fhir_models.BundleEntryComponent{FullUrl: base + "2", Resource: &fhir_models.Patient{
+			Gender: "female", BirthDate: birthDate,
+			Name: []fhir_models.HumanName{fhir_models.HumanName{Family: []string{"Lee"}}}}},
+		fhir_models.BundleEntryComponent{FullUrl: base + "3", Resource: &fhir_models.Patient{}},
+	}
+	recSet := &RecordSet{ResourceType: "Patient",
+		Parameters: &fhir_models.Parameters{Parameter: []fhir_models.ParametersParameterComponent{
+			fhir_models.ParametersParameterComponent{Name: "resourceUrl", ValueString: "http://localhost:3001/Patient"}}}}
+	recSet.AnswerKey = *NewAnswerKey(recSet, []RecordPair{
+		NewRecordPair(base+"1", base+"2"),
+		NewRecordPair(base+"2", base+"99")})
+
+	profile := NewRecordSetProfile(recSet, members)
+	c.Assert(profile.RecordCount, Equals, 3)
+	c.Assert(profile.Completeness["name"], Equals, 2.0/3)
+	c.Assert(profile.Completeness["name.given"], Equals, 1.0/3)
+	c.Assert(profile.Gender, DeepEquals, map[string]int{"female": 2, "missing": 1})
+	c.Assert(profile.BirthYear, DeepEquals, map[int]int{1980: 2})
+	c.Assert(profile.NameLength, DeepEquals, map[int]int{7: 1, 3: 1})
+
+	ak := profile.AnswerKey
+	c.Assert(ak.PairCount, Equals, 2)
+	c.Assert(ak.DuplicateRecordCount, Equals, 2)
+	c.Assert(ak.ClusterSizes, DeepEquals, map[int]int{2: 1, 1: 1})
+	c.Assert(ak.ClusterCount, Equals, 2)
+	c.Assert(ak.UnknownRecordCount, Equals, 1)
+}
+
+func (s *RecordSetProfileSuite) TestRecordClusters(c *C) {
+	clusters := RecordClusters([]RecordPair{
+		NewRecordPair("c", "d"), NewRecordPair("a", "b"), NewRecordPair("d", "e")})
+	c.Assert(clusters, DeepEquals, [][]string{{"a", "b"}, {"c", "d", "e"}})
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"gopkg.in/mgo.v2/bson"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+)
+
+// RecordSetSnapshot records the members of a record set, as resolved from its
+// search expression at a point in time, so that the records seen by a
+// record matching system can be identified later.
+type RecordSetSnapshot struct {
+	ID          bson.ObjectId `bson:"_id,omitempty" json:"id,omitempty"`
+	Meta        *Meta         `bson:"meta,omitempty" json:"meta,omitempty"`
+	RecordSetID bson.ObjectId `bson:"recordSetId,omitempty" json:"recordSetId,omitempty"`
+	// the search URL executed to resolve the members
+	SearchURL string `bson:"searchUrl,omitempty" json:"searchUrl,omitempty"`
+	Count     int    `bson:"count" json:"count"`
+	// hash over the URL and content hash of every member
+	Hash    string                    `bson:"hash,omitempty" json:"hash,omitempty"`
+	Members []RecordSetSnapshotMember `bson:"members,omitempty" json:"members,omitempty"`
+}
+
+// RecordSetSnapshotMember identifies one record in a snapshot.
+type RecordSetSnapshotMember struct {
+	URL       string `bson:"url" json:"url"`
+	VersionID string `bson:"versionId,omitempty" json:"versionId,omitempty"`
+	// hash of the resource content, excluding its meta element
+	Hash string `bson:"hash" json:"hash"`
+}
+
+// RecordSetSnapshotDiff describes how the members of a record set differ
+// between two snapshots.
+type RecordSetSnapshotDiff struct {
+	From      bson.ObjectId `json:"from,omitempty"`
+	To        bson.ObjectId `json:"to,omitempty"`
+	Identical bool          `json:"identical"`
+	Added     []string      `json:"added,omitempty"`
+	Removed   []string      `json:"removed,omitempty"`
+	Changed   []string      `json:"changed,omitempty"`
+}
+
+// NewRecordSetSnapshot creates a snapshot of the given members of a record set.
+func NewRecordSetSnapshot(recSet *RecordSet, members []fhir_models.BundleEntryComponent) *RecordSetSnapshot {
+	snapshot := &RecordSetSnapshot{RecordSetID: recSet.ID, Count: len(members)}
+	snapshot.SearchURL, _ = recSet.SearchURL()
+	snapshot.Members = make([]RecordSetSnapshotMember, len(members))
+	for i, entry := range members {
+		hash, versionID := ContentHash(entry.Resource)
+		snapshot.Members[i] = RecordSetSnapshotMember{URL: entry.FullUrl, VersionID: versionID, Hash: hash}
+	}
+	sort.Sort(snapshotMemberSlice(snapshot.Members))
+
+	h := sha256.New()
+	for _, m := range snapshot.Members {
+		h.Write([]byte(m.URL + "\x00" + m.Hash + "\n"))
+	}
+	snapshot.Hash = hex.EncodeToString(h.Sum(nil))
+	return snapshot
+}
+
+// ContentHash returns a hash of the content of a FHIR resource, along with
+// the resource's version identifier. The meta element is excluded from the
+// hash, since the server changes it on every update.
+func ContentHash(resource interface{}) (hash, versionID string) {
+	b, _ := json.Marshal(resource)
+	var m map[string]interface{}
+	if json.Unmarshal(b, &m) == nil {
+		if meta, ok := m["meta"].(map[string]interface{}); ok {
+			versionID, _ = meta["versionId"].(string)
+		}
+		delete(m, "meta")
+		// maps are marshaled with sorted keys, giving a canonical form
+		b, _ = json.Marshal(m)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16]), versionID
+}
+
+// DiffSnapshots reports the records added, removed and changed between two
+// snapshots.
+func DiffSnapshots(from, to *RecordSetSnapshot) *RecordSetSnapshotDiff {
+	diff := &RecordSetSnapshotDiff{From: from.ID, To: to.ID}
+
+	before := make(map[string]string)
+	for _, m := range from.Members {
+		before[m.URL] = m.Hash
+	}
+	for _, m := range to.Members {
+		hash, ok := before[m.URL]
+		if !ok {
+			diff.Added = append(diff.Added, m.URL)
+		} else if hash != m.Hash {
+			diff.Changed = append(diff.Changed, m.URL)
+		}
+		delete(before, m.URL)
+	}
+	for url := range before {
+		diff.Removed = append(diff.Removed, url)
+	}
+	sort.Strings(diff.Removed)
+
+	diff.Identical = len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0
+	return diff
+}
+
+type snapshotMemberSlice []RecordSetSnapshotMember
+
+func (s snapshotMemberSlice) Len() int           { return len(s) }
+func (s snapshotMemberSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s snapshotMemberSlice) Less(i, j int) bool { return s[i].URL < s[j].URL }
@@ -0,0 +1,104 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	. "gopkg.in/check.v1"
+)
+
+type RecordSetSnapshotSuite struct {
+}
+
+var _ = Suite(&RecordSetSnapshotSuite{})
+
+func snapshotOf(patients map[string]string) *RecordSetSnapshot {
+	var members []fhir_models.BundleEntryComponent
+	for url, gender := range patients {
+		patient := &fhir_models.Patient{Gender: gender}
+		members = append(members, fhir_models.BundleEntryComponent{FullUrl: url, Resource: patient})
+	}
+	return NewRecordSetSnapshot(&RecordSet{ResourceType: "Patient"}, members)
+}
+
+func (s *RecordSetSnapshotSuite) TestContentHashIgnoresMeta(c *C) {
+	patient := &fhir_models.Patient{Gender: "female"}
+	hash, _ := ContentHash(patient)
+
+	patient.Meta = &fhir_models.Meta{VersionId: "2"}
+	hash2, versionID := ContentHash(patient)
+	c.Assert(hash2, Equals, hash)
+	c.Assert(versionID, Equals, "2")
+
+	patient.Gender = "male"
+	hash3, _ := ContentHash(patient)
+	c.Assert(hash3, Not(Equals), hash)
+}
+
+func (s *RecordSetSnapshotSuite) TestDiffSnapshots(c *C) {
+	from := snapshotOf(map[string]string{"http://a/Patient/1": "male",
+		"http://a/Patient/2": "female", "http://a/Patient/3": "male"})
+	to := snapshotOf(map[string]string{"http://a/Patient/1": "male",
+		"http://a/Patient/2": "male", "http://a/Patient/4": "female"})
+	c.Assert(from.Count, Equals, 3)
+	c.Assert(from.Hash, Not(Equals), to.Hash)
+
+	diff := DiffSnapshots(from, to)
+	c.Assert(diff.Identical, Equals, false)
+	c.Assert(diff.Added, DeepEquals, []string{"http://a/Patient/4"})
+	c.Assert(diff.Removed, DeepEquals, []string{"http://a/Patient/3"})
+	c.Assert(diff.Changed, DeepEquals, []string{"http://a/Patient/2"})
+
+	same := snapshotOf(map[string]string{"http://a/Patient/1": "male",
+		"http://a/Patient/2": "female", "http://a/Patient/3": "male"})
+	c.Assert(same.Hash, Equals, from.Hash)
+	c.Assert(DiffSnapshots(from, same).Identical, Equals, true)
+}
+
+func (s *RecordSetSnapshotSuite) TestLoadSnapshotMembers(c *C) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		switch r.URL.Path {
+		case "/Patient/1/_history/2":
+			w.Write([]byte(`{"resourceType": "Patient", "id": "1", "meta": {"versionId": "2"},
+				"identifier": [{"system": "urn:test", "value": "A"}]}`))
+		case "/Patient/2":
+			w.Write([]byte(`{"resourceType": "Patient", "id": "2",
+				"identifier": [{"system": "urn:test", "value": "B"}]}`))
+		default:
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer server.Close()
+
+	snapshot := &RecordSetSnapshot{Members: []RecordSetSnapshotMember{
+		{URL: server.URL + "/Patient/1", VersionID: "2"}, {URL: server.URL + "/Patient/2"}}}
+	members, err := LoadSnapshotMembers(snapshot)
+	c.Assert(err, IsNil)
+	// members are read at the version in the snapshot, if it was recorded
+	c.Assert(requested, DeepEquals, []string{"/Patient/1/_history/2", "/Patient/2"})
+	c.Assert(IdentifierMap(members, "urn:test"), DeepEquals, map[string]string{
+		server.URL + "/Patient/1": "A", server.URL + "/Patient/2": "B"})
+
+	snapshot.Members = append(snapshot.Members, RecordSetSnapshotMember{URL: server.URL + "/Patient/3", VersionID: "1"})
+	_, err = LoadSnapshotMembers(snapshot)
+	c.Assert(err, NotNil)
+}
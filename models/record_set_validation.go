@@ -0,0 +1,105 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+)
+
+// RecordSetValidation reports the outcome of a dry run of a record set's
+// search expression against the FHIR server.
+type RecordSetValidation struct {
+	RecordSetID bson.ObjectId `json:"recordSetId,omitempty"`
+	SearchURL   string        `json:"searchUrl,omitempty"`
+	MemberCount int           `json:"memberCount"`
+	// number of members of each resource type
+	ResourceTypes map[string]int `json:"resourceTypes"`
+	Valid         bool           `json:"valid"`
+	Problems      []string       `json:"problems,omitempty"`
+}
+
+// ValidateRecordSet executes the search expression of the record set and
+// checks that it selects records of a single resource type, matching the
+// record set's declared type. The members are returned so that callers can
+// use them without repeating the search; they are nil if the search failed.
+func ValidateRecordSet(recSet *RecordSet) (*RecordSetValidation, []fhir_models.BundleEntryComponent) {
+	members, err := LoadRecordSetMembers(recSet)
+	if err != nil {
+		validation := &RecordSetValidation{RecordSetID: recSet.ID, ResourceTypes: map[string]int{},
+			Problems: []string{"Unable to execute the search expression: " + err.Error()}}
+		validation.SearchURL, _ = recSet.SearchURL()
+		return validation, nil
+	}
+	return NewRecordSetValidation(recSet, members), members
+}
+
+// NewRecordSetValidation checks the members found by a record set's search
+// expression.
+func NewRecordSetValidation(recSet *RecordSet, members []fhir_models.BundleEntryComponent) *RecordSetValidation {
+	validation := &RecordSetValidation{RecordSetID: recSet.ID, MemberCount: len(members),
+		ResourceTypes: make(map[string]int)}
+	validation.SearchURL, _ = recSet.SearchURL()
+
+	for _, member := range members {
+		validation.ResourceTypes[ResourceTypeName(member.Resource)]++
+	}
+
+	if len(members) == 0 {
+		validation.Problems = append(validation.Problems, "The search expression does not match any records")
+	}
+	if len(validation.ResourceTypes) > 1 {
+		types := make([]string, 0, len(validation.ResourceTypes))
+		for t := range validation.ResourceTypes {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		validation.Problems = append(validation.Problems,
+			"The search expression matches records of more than one type: "+strings.Join(types, ", "))
+	} else if recSet.ResourceType != "" {
+		for t := range validation.ResourceTypes {
+			if t != recSet.ResourceType {
+				validation.Problems = append(validation.Problems,
+					"The search expression matches "+t+" records, but the record set holds "+recSet.ResourceType+" records")
+			}
+		}
+	}
+
+	validation.Valid = len(validation.Problems) == 0
+	return validation
+}
+
+// ResourceTypeName returns the FHIR resource type of the given resource.
+func ResourceTypeName(resource interface{}) string {
+	if m, ok := resource.(map[string]interface{}); ok {
+		rt, _ := m["resourceType"].(string)
+		return rt
+	}
+	t := reflect.TypeOf(resource)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	return t.Name()
+}
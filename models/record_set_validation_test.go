@@ -0,0 +1,55 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	fhir_models "github.com/intervention-engine/fhir/models"
+	. "gopkg.in/check.v1"
+)
+
+type RecordSetValidationSuite struct {
+}
+
+var _ = Suite(&RecordSetValidationSuite{})
+
+func (s *RecordSetValidationSuite) TestNewRecordSetValidation(c *C) {
+	recSet := NewTaggedRecordSet("http://localhost:3001", "Validation Test", "Patient")
+
+	validation := NewRecordSetValidation(recSet, nil)
+	c.Assert(validation.Valid, Equals, false)
+	c.Assert(validation.SearchURL, Equals, "http://localhost:3001/Patient?_tag=ValidationTest")
+	c.Assert(len(validation.Problems), Equals, 1)
+
+	members := []fhir_models.BundleEntryComponent{
+		fhir_models.BundleEntryComponent{Resource: &fhir_models.Patient{}},
+		fhir_models.BundleEntryComponent{Resource: &fhir_models.Patient{}},
+	}
+	validation = NewRecordSetValidation(recSet, members)
+	c.Assert(validation.Valid, Equals, true)
+	c.Assert(validation.MemberCount, Equals, 2)
+	c.Assert(validation.ResourceTypes, DeepEquals, map[string]int{"Patient": 2})
+
+	members = append(members, fhir_models.BundleEntryComponent{Resource: &fhir_models.Observation{}})
+	validation = NewRecordSetValidation(recSet, members)
+	c.Assert(validation.Valid, Equals, false)
+	c.Assert(validation.Problems, DeepEquals,
+		[]string{"The search expression matches records of more than one type: Observation, Patient"})
+
+	recSet.ResourceType = "Observation"
+	validation = NewRecordSetValidation(recSet, members[:1])
+	c.Assert(validation.Valid, Equals, false)
+}
@@ -54,6 +54,12 @@ func NewSliceForResourceName(name string, len int, cap int) interface{} {
 func StructForResourceName(name string) interface{} {
 	logger.Log.WithFields(logrus.Fields{"name": name}).Debug("StructForResourceName")
 	switch name {
+	case "BenchmarkSuite":
+		return BenchmarkSuite{}
+	case "ImportJob":
+		return ImportJob{}
+	case "OutboxMessage":
+		return OutboxMessage{}
 	case "RecordMatchContext":
 		return RecordMatchContext{}
 	case "RecordMatchRequest":
@@ -66,6 +72,10 @@ func StructForResourceName(name string) interface{} {
 		return RecordMatchSystemInterface{}
 	case "RecordSet":
 		return RecordSet{}
+	case "RecordSetSnapshot":
+		return RecordSetSnapshot{}
+	case "RunSchedule":
+		return RunSchedule{}
 
 	default:
 		logger.Log.Warn("StructForResourceName() No match for name ", name)
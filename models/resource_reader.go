@@ -0,0 +1,210 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+)
+
+// ResourceFunc is called for each resource found by the resource readers. The
+// source identifies where the resource was read from (e.g., file name and
+// bundle entry or line number).
+type ResourceFunc func(source string, resource map[string]interface{}) error
+
+// ReadResources calls fn for every resource of the given type held in data,
+// which may be FHIR JSON (a single resource or a Bundle), FHIR bulk data
+// NDJSON with one resource per line, or a zip archive of *.json and *.ndjson
+// files. The format is determined from the content. Resources of other types
+// are skipped and counted.
+func ReadResources(source string, data []byte, resourceType string, fn ResourceFunc) (int, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return ReadZipResources(source, data, resourceType, fn)
+	}
+	// a document that isn't a single JSON object is read as NDJSON
+	var resource map[string]interface{}
+	if err := json.Unmarshal(data, &resource); err == nil {
+		return VisitResource(source, resource, resourceType, fn)
+	}
+	return ReadNDJSONResources(source, bytes.NewReader(data), resourceType, fn)
+}
+
+// ReadJSONResources calls fn for every resource of the given type in a FHIR
+// JSON document holding a single resource or a Bundle.
+func ReadJSONResources(source string, data []byte, resourceType string, fn ResourceFunc) (int, error) {
+	var resource map[string]interface{}
+	if err := json.Unmarshal(data, &resource); err != nil {
+		return 0, fmt.Errorf("Couldn't parse the JSON file %s: %s", source, err.Error())
+	}
+	return VisitResource(source, resource, resourceType, fn)
+}
+
+// ReadNDJSONResources calls fn for every resource of the given type in FHIR
+// bulk data NDJSON, with one resource per line.
+func ReadNDJSONResources(source string, r io.Reader, resourceType string, fn ResourceFunc) (int, error) {
+	skipped := 0
+	scanner := bufio.NewScanner(r)
+	// bulk data lines can be much longer than the default token size
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var resource map[string]interface{}
+		if err := json.Unmarshal(line, &resource); err != nil {
+			return skipped, fmt.Errorf("Couldn't parse line %d of %s: %s", lineNum, source, err.Error())
+		}
+		n, err := VisitResource(fmt.Sprintf("%s:%d", source, lineNum), resource, resourceType, fn)
+		skipped += n
+		if err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, scanner.Err()
+}
+
+// ReadZipResources calls fn for every resource of the given type in the
+// *.json and *.ndjson files of a zip archive. Other files are ignored.
+func ReadZipResources(source string, data []byte, resourceType string, fn ResourceFunc) (int, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return 0, fmt.Errorf("Couldn't read the zip file %s: %s", source, err.Error())
+	}
+
+	skipped := 0
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		isJSON, isNDJSON := strings.HasSuffix(f.Name, ".json"), strings.HasSuffix(f.Name, ".ndjson")
+		if !isJSON && !isNDJSON {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return skipped, fmt.Errorf("Couldn't read %s from %s: %s", f.Name, source, err.Error())
+		}
+		fileSource := source + "/" + f.Name
+		var n int
+		if isNDJSON {
+			n, err = ReadNDJSONResources(fileSource, rc, resourceType, fn)
+		} else {
+			var content []byte
+			if content, err = ioutil.ReadAll(rc); err == nil {
+				n, err = ReadJSONResources(fileSource, content, resourceType, fn)
+			}
+		}
+		rc.Close()
+		skipped += n
+		if err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+// VisitResource calls fn for the resource, if it is of the requested type,
+// or for each matching resource held in the entries of a Bundle.
+func VisitResource(source string, resource map[string]interface{}, resourceType string, fn ResourceFunc) (int, error) {
+	rt, _ := resource["resourceType"].(string)
+	if rt == resourceType {
+		return 0, fn(source, resource)
+	}
+	if rt != "Bundle" {
+		return 1, nil
+	}
+
+	skipped := 0
+	entries, _ := resource["entry"].([]interface{})
+	for i, e := range entries {
+		entry, _ := e.(map[string]interface{})
+		child, ok := entry["resource"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		n, err := VisitResource(fmt.Sprintf("%s#entry[%d]", source, i), child, resourceType, fn)
+		skipped += n
+		if err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+// TagResource adds the tag to the meta element of the resource, unless the
+// resource already has it. It reports whether the tag was added.
+func TagResource(resource map[string]interface{}, tag fhir_models.Coding) bool {
+	meta, ok := resource["meta"].(map[string]interface{})
+	if !ok {
+		meta = make(map[string]interface{})
+		resource["meta"] = meta
+	}
+	tags, _ := meta["tag"].([]interface{})
+	for _, t := range tags {
+		if m, ok := t.(map[string]interface{}); ok && m["system"] == tag.System && m["code"] == tag.Code {
+			return false
+		}
+	}
+	meta["tag"] = append(tags, map[string]interface{}{"system": tag.System, "code": tag.Code})
+	return true
+}
+
+// UntagResource removes the tag from the meta element of the resource. It
+// reports whether the resource had the tag.
+func UntagResource(resource map[string]interface{}, tag fhir_models.Coding) bool {
+	meta, _ := resource["meta"].(map[string]interface{})
+	tags, _ := meta["tag"].([]interface{})
+	var kept []interface{}
+	for _, t := range tags {
+		if m, ok := t.(map[string]interface{}); ok && m["system"] == tag.System && m["code"] == tag.Code {
+			continue
+		}
+		kept = append(kept, t)
+	}
+	if len(kept) == len(tags) {
+		return false
+	}
+	if len(kept) == 0 {
+		delete(meta, "tag")
+	} else {
+		meta["tag"] = kept
+	}
+	return true
+}
+
+// HasRecordSetTag reports whether the resource is tagged as a member of any
+// record set.
+func HasRecordSetTag(resource map[string]interface{}) bool {
+	meta, _ := resource["meta"].(map[string]interface{})
+	tags, _ := meta["tag"].([]interface{})
+	for _, t := range tags {
+		if m, ok := t.(map[string]interface{}); ok && m["system"] == RecordSetTagSystem {
+			return true
+		}
+	}
+	return false
+}
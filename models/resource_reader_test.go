@@ -0,0 +1,98 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+	. "gopkg.in/check.v1"
+)
+
+type ResourceReaderSuite struct {
+}
+
+var _ = Suite(&ResourceReaderSuite{})
+
+const readerBundle = `{"resourceType": "Bundle", "type": "collection", "entry": [
+	{"resource": {"resourceType": "Patient", "id": "1"}},
+	{"resource": {"resourceType": "Observation", "id": "2"}}]}`
+
+const readerNDJSON = `{"resourceType": "Patient", "id": "3"}
+
+{"resourceType": "Patient", "id": "4"}
+`
+
+func readSources(c *C, data []byte) ([]string, int) {
+	var sources []string
+	skipped, err := ReadResources("upload", data, "Patient", func(source string, resource map[string]interface{}) error {
+		sources = append(sources, source)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	return sources, skipped
+}
+
+func (s *ResourceReaderSuite) TestReadResources(c *C) {
+	sources, skipped := readSources(c, []byte(readerBundle))
+	c.Assert(sources, DeepEquals, []string{"upload#entry[0]"})
+	c.Assert(skipped, Equals, 1)
+
+	sources, skipped = readSources(c, []byte(readerNDJSON))
+	c.Assert(sources, DeepEquals, []string{"upload:1", "upload:3"})
+	c.Assert(skipped, Equals, 0)
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range map[string]string{"a/bundle.json": readerBundle,
+		"b.ndjson": readerNDJSON, "README": "ignored"} {
+		w, err := archive.Create(name)
+		c.Assert(err, IsNil)
+		w.Write([]byte(content))
+	}
+	c.Assert(archive.Close(), IsNil)
+	sources, skipped = readSources(c, buf.Bytes())
+	c.Assert(len(sources), Equals, 3)
+	c.Assert(skipped, Equals, 1)
+
+	_, err := ReadResources("upload", []byte("{not json"), "Patient",
+		func(string, map[string]interface{}) error { return nil })
+	c.Assert(err, NotNil)
+}
+
+func (s *ResourceReaderSuite) TestTagResource(c *C) {
+	tag := fhir_models.Coding{System: RecordSetTagSystem, Code: "Test"}
+	resource := map[string]interface{}{"resourceType": "Patient"}
+	c.Assert(TagResource(resource, tag), Equals, true)
+	c.Assert(TagResource(resource, tag), Equals, false)
+	c.Assert(len(resource["meta"].(map[string]interface{})["tag"].([]interface{})), Equals, 1)
+}
+
+func (s *ResourceReaderSuite) TestUntagResource(c *C) {
+	tag := fhir_models.Coding{System: RecordSetTagSystem, Code: "Test"}
+	other := fhir_models.Coding{System: RecordSetTagSystem, Code: "Other"}
+	resource := map[string]interface{}{"resourceType": "Patient"}
+	TagResource(resource, tag)
+	TagResource(resource, other)
+
+	c.Assert(UntagResource(resource, tag), Equals, true)
+	c.Assert(UntagResource(resource, tag), Equals, false)
+	c.Assert(HasRecordSetTag(resource), Equals, true)
+	c.Assert(UntagResource(resource, other), Equals, true)
+	c.Assert(HasRecordSetTag(resource), Equals, false)
+}
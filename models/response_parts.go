@@ -0,0 +1,154 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"sort"
+
+	fhir_models "github.com/intervention-engine/fhir/models"
+)
+
+// Extensions of a response MessageHeader with which a record matcher splits
+// its results across several messages. Each part is numbered from 1 with
+// ResponsePartExtension. The matcher either gives the number of parts with
+// ResponsePartCountExtension or marks the last part with
+// ResponseFinalPartExtension.
+const (
+	ResponsePartExtension      = "http://github.com/mitre/ptmatch/fhir/extensions/response-part"
+	ResponsePartCountExtension = "http://github.com/mitre/ptmatch/fhir/extensions/response-part-count"
+	ResponseFinalPartExtension = "http://github.com/mitre/ptmatch/fhir/extensions/response-final-part"
+)
+
+// ResponsePart describes where a response message falls in a multi-part
+// response.
+type ResponsePart struct {
+	// numbered from 1
+	Part int `bson:"part,omitempty" json:"part,omitempty"`
+	// the number of parts, if known
+	Count int `bson:"count,omitempty" json:"count,omitempty"`
+	// the last part
+	Final bool `bson:"final,omitempty" json:"final,omitempty"`
+}
+
+// ResponsePartOf returns the part of a multi-part response that the message
+// carries. False is returned if the message isn't part of a multi-part
+// response.
+func ResponsePartOf(msg *fhir_models.Bundle) (ResponsePart, bool) {
+	var rp ResponsePart
+	if len(msg.Entry) == 0 {
+		return rp, false
+	}
+	msgHdr, ok := msg.Entry[0].Resource.(*fhir_models.MessageHeader)
+	if !ok {
+		return rp, false
+	}
+	for _, ext := range msgHdr.Extension {
+		switch {
+		case ext.Url == ResponsePartExtension && ext.ValueInteger != nil:
+			rp.Part = int(*ext.ValueInteger)
+		case ext.Url == ResponsePartCountExtension && ext.ValueInteger != nil:
+			rp.Count = int(*ext.ValueInteger)
+		case ext.Url == ResponseFinalPartExtension && ext.ValueBoolean != nil:
+			rp.Final = *ext.ValueBoolean
+		}
+	}
+	if rp.Part <= 0 {
+		return ResponsePart{}, false
+	}
+	if rp.Count > 0 && rp.Part == rp.Count {
+		rp.Final = true
+	}
+	return rp, true
+}
+
+// ResponseProgress tracks the parts of a multi-part response received by a
+// run.
+type ResponseProgress struct {
+	PartsReceived []int `bson:"partsReceived,omitempty" json:"partsReceived,omitempty"`
+	// the number of parts, once known from a part count or the final part
+	PartCount int `bson:"partCount,omitempty" json:"partCount,omitempty"`
+	// every part has been received
+	Complete bool `bson:"complete,omitempty" json:"complete,omitempty"`
+}
+
+// Add records the receipt of a part.
+func (p *ResponseProgress) Add(rp ResponsePart) {
+	found := false
+	for _, part := range p.PartsReceived {
+		found = found || part == rp.Part
+	}
+	if !found {
+		p.PartsReceived = append(p.PartsReceived, rp.Part)
+		sort.Ints(p.PartsReceived)
+	}
+	if rp.Count > 0 {
+		p.PartCount = rp.Count
+	} else if rp.Final && p.PartCount == 0 {
+		p.PartCount = rp.Part
+	}
+
+	p.Complete = p.PartCount > 0 && len(p.PartsReceived) >= p.PartCount &&
+		p.PartsReceived[p.PartCount-1] == p.PartCount
+}
+
+// ResponsePartProgress returns the progress of the run's multi-part response, or
+// nil if the record matcher hasn't sent any results in parts.
+func (rmr *RecordMatchRun) ResponsePartProgress() *ResponseProgress {
+	var progress *ResponseProgress
+	for _, resp := range rmr.Responses {
+		if resp.Kind != ResponseError && resp.Part != nil {
+			if progress == nil {
+				progress = &ResponseProgress{}
+			}
+			progress.Add(*resp.Part)
+		}
+	}
+	return progress
+}
+
+// CombinedResults returns a message holding the results from every part of
+// the run's multi-part response. If a part was received more than once, the
+// latest copy is used. The message takes its id and header from the last
+// response.
+func (rmr *RecordMatchRun) CombinedResults() *fhir_models.Bundle {
+	parts := make(map[int]*fhir_models.Bundle)
+	var last *fhir_models.Bundle
+	for _, resp := range rmr.Responses {
+		if resp.Kind != ResponseError && resp.Part != nil && resp.Message != nil {
+			parts[resp.Part.Part] = resp.Message
+			last = resp.Message
+		}
+	}
+	if last == nil {
+		return nil
+	}
+
+	nums := make([]int, 0, len(parts))
+	for num := range parts {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+
+	combined := &fhir_models.Bundle{}
+	combined.Id = last.Id
+	combined.Type = last.Type
+	combined.Entry = append(combined.Entry, last.Entry[0])
+	for _, num := range nums {
+		combined.Entry = append(combined.Entry, parts[num].Entry[1:]...)
+	}
+	return combined
+}
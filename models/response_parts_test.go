@@ -0,0 +1,124 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	fhir_models "github.com/intervention-engine/fhir/models"
+	. "gopkg.in/check.v1"
+)
+
+type ResponsePartSuite struct {
+}
+
+var _ = Suite(&ResponsePartSuite{})
+
+func partMessage(id string, exts ...fhir_models.Extension) *fhir_models.Bundle {
+	msgHdr := &fhir_models.MessageHeader{}
+	msgHdr.Extension = exts
+	msg := &fhir_models.Bundle{Type: "message", Entry: []fhir_models.BundleEntryComponent{
+		{Resource: msgHdr},
+		{FullUrl: "urn:uuid:" + id},
+	}}
+	msg.Id = id
+	return msg
+}
+
+func partExt(url string, value int32) fhir_models.Extension {
+	return fhir_models.Extension{Url: url, ValueInteger: &value}
+}
+
+func finalExt() fhir_models.Extension {
+	final := true
+	return fhir_models.Extension{Url: ResponseFinalPartExtension, ValueBoolean: &final}
+}
+
+func (s *ResponsePartSuite) TestResponsePartOf(c *C) {
+	_, ok := ResponsePartOf(partMessage("a"))
+	c.Assert(ok, Equals, false)
+
+	rp, ok := ResponsePartOf(partMessage("a",
+		partExt(ResponsePartExtension, 2), partExt(ResponsePartCountExtension, 3)))
+	c.Assert(ok, Equals, true)
+	c.Assert(rp, DeepEquals, ResponsePart{Part: 2, Count: 3})
+
+	rp, ok = ResponsePartOf(partMessage("a",
+		partExt(ResponsePartExtension, 3), partExt(ResponsePartCountExtension, 3)))
+	c.Assert(ok, Equals, true)
+	c.Assert(rp.Final, Equals, true)
+
+	rp, ok = ResponsePartOf(partMessage("a", partExt(ResponsePartExtension, 4), finalExt()))
+	c.Assert(ok, Equals, true)
+	c.Assert(rp, DeepEquals, ResponsePart{Part: 4, Final: true})
+}
+
+func (s *ResponsePartSuite) TestResponseProgress(c *C) {
+	p := &ResponseProgress{}
+	p.Add(ResponsePart{Part: 2})
+	p.Add(ResponsePart{Part: 3, Final: true})
+	c.Assert(p.PartCount, Equals, 3)
+	c.Assert(p.Complete, Equals, false)
+
+	// a part may be received more than once
+	p.Add(ResponsePart{Part: 2})
+	c.Assert(p.Complete, Equals, false)
+
+	p.Add(ResponsePart{Part: 1})
+	c.Assert(p.PartsReceived, DeepEquals, []int{1, 2, 3})
+	c.Assert(p.Complete, Equals, true)
+
+	p = &ResponseProgress{}
+	p.Add(ResponsePart{Part: 1, Count: 2})
+	c.Assert(p.Complete, Equals, false)
+	p.Add(ResponsePart{Part: 2, Count: 2})
+	c.Assert(p.Complete, Equals, true)
+}
+
+func (s *ResponsePartSuite) TestCombinedResults(c *C) {
+	run := &RecordMatchRun{}
+	c.Assert(run.ResponsePartProgress(), IsNil)
+	c.Assert(run.CombinedResults(), IsNil)
+
+	run.Responses = []RecordMatchResponse{
+		{Kind: ResponseAcknowledgement, Message: partMessage("ack")},
+		{Kind: ResponseResults, Part: &ResponsePart{Part: 2, Final: true}, Message: partMessage("b")},
+		{Kind: ResponseResults, Part: &ResponsePart{Part: 1}, Message: partMessage("a")},
+		{Kind: ResponseResults, Part: &ResponsePart{Part: 1}, Message: partMessage("a2")},
+	}
+	c.Assert(run.ResponsePartProgress().Complete, Equals, true)
+
+	combined := run.CombinedResults()
+	c.Assert(combined.Id, Equals, "a2")
+	c.Assert(combined.Entry, HasLen, 3)
+	c.Assert(combined.Entry[1].FullUrl, Equals, "urn:uuid:a2")
+	c.Assert(combined.Entry[2].FullUrl, Equals, "urn:uuid:b")
+}
+
+func (s *ResponsePartSuite) TestBareFinalPart(c *C) {
+	// the final part only marks the end of the response, so has no results
+	final := partMessage("b")
+	final.Entry = final.Entry[:1]
+	run := &RecordMatchRun{Responses: []RecordMatchResponse{
+		{Kind: ResponseResults, Part: &ResponsePart{Part: 1}, Message: partMessage("a")},
+		{Kind: ResponseAcknowledgement, Part: &ResponsePart{Part: 2, Final: true}, Message: final},
+	}}
+	c.Assert(run.ResponsePartProgress().Complete, Equals, true)
+
+	combined := run.CombinedResults()
+	c.Assert(combined.Id, Equals, "b")
+	c.Assert(combined.Entry, HasLen, 2)
+	c.Assert(combined.Entry[1].FullUrl, Equals, "urn:uuid:a")
+}
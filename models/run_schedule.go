@@ -0,0 +1,84 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MaxRunScheduleHistory is the number of scheduled runs remembered by a
+// RunSchedule.
+const MaxRunScheduleHistory = 100
+
+// RunSchedule creates a RecordMatchRun from a template on a cron-style
+// schedule, e.g., for nightly regression runs of a record matcher.
+type RunSchedule struct {
+	ID          bson.ObjectId `bson:"_id,omitempty" json:"id,omitempty"`
+	Meta        *Meta         `bson:"meta,omitempty" json:"meta,omitempty"`
+	Name        string        `bson:"name,omitempty" json:"name,omitempty"`
+	Description string        `bson:"description,omitempty" json:"description,omitempty"`
+	// cron expression, e.g., "0 2 * * *" for 2 AM every day; see Schedule
+	Schedule string `bson:"schedule" json:"schedule"`
+	// IANA time zone in which the schedule is interpreted; defaults to UTC
+	TimeZone string `bson:"timeZone,omitempty" json:"timeZone,omitempty"`
+	// no runs are created while paused
+	Paused bool `bson:"paused,omitempty" json:"paused,omitempty"`
+	// the configuration of the runs: context, record matcher, matching mode,
+	// record sets and response timeout
+	Template RecordMatchRun `bson:"template" json:"template"`
+	// when the next run is due
+	NextRunOn time.Time `bson:"nextRunOn,omitempty" json:"nextRunOn,omitempty"`
+	// the most recent scheduled runs, most recent last
+	History []ScheduledRun `bson:"history,omitempty" json:"history,omitempty"`
+}
+
+// ScheduledRun records a run created by a schedule, or why it couldn't be.
+type ScheduledRun struct {
+	ScheduledFor     time.Time     `bson:"scheduledFor" json:"scheduledFor"`
+	CreatedOn        time.Time     `bson:"createdOn" json:"createdOn"`
+	RecordMatchRunID bson.ObjectId `bson:"recordMatchRunId,omitempty" json:"recordMatchRunId,omitempty"`
+	Error            string        `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// NextRun returns when the schedule is next due after the time. The zero
+// time is returned if the schedule never matches.
+func (rs *RunSchedule) NextRun(after time.Time) (time.Time, error) {
+	sched, err := ParseSchedule(rs.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc := time.UTC
+	if rs.TimeZone != "" {
+		if loc, err = time.LoadLocation(rs.TimeZone); err != nil {
+			return time.Time{}, err
+		}
+	}
+	next := sched.Next(after.In(loc))
+	if next.IsZero() {
+		return next, nil
+	}
+	return next.UTC(), nil
+}
+
+// NewRun returns a new run with the configuration of the template.
+func (rs *RunSchedule) NewRun() *RecordMatchRun {
+	run := rs.Template.configuration()
+	run.RunScheduleID = rs.ID
+	return run
+}
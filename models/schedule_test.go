@@ -0,0 +1,87 @@
+/*
+Copyright 2016 The MITRE Corporation. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ScheduleSuite struct {
+}
+
+var _ = Suite(&ScheduleSuite{})
+
+func (s *ScheduleSuite) TestNext(c *C) {
+	// a Wednesday
+	t := time.Date(2016, 6, 1, 12, 30, 45, 0, time.UTC)
+
+	sched, err := ParseSchedule("@daily")
+	c.Assert(err, IsNil)
+	c.Assert(sched.Next(t), Equals, time.Date(2016, 6, 2, 0, 0, 0, 0, time.UTC))
+
+	sched, err = ParseSchedule("*/15 * * * *")
+	c.Assert(err, IsNil)
+	c.Assert(sched.Next(t), Equals, time.Date(2016, 6, 1, 12, 45, 0, 0, time.UTC))
+
+	// weekdays at 2:30
+	sched, err = ParseSchedule("30 2 * * 1-5")
+	c.Assert(err, IsNil)
+	c.Assert(sched.Next(t), Equals, time.Date(2016, 6, 2, 2, 30, 0, 0, time.UTC))
+	c.Assert(sched.Next(time.Date(2016, 6, 3, 3, 0, 0, 0, time.UTC)), Equals, time.Date(2016, 6, 6, 2, 30, 0, 0, time.UTC))
+
+	// Sundays, as 7
+	sched, err = ParseSchedule("0 0 * * 7")
+	c.Assert(err, IsNil)
+	c.Assert(sched.Next(t), Equals, time.Date(2016, 6, 5, 0, 0, 0, 0, time.UTC))
+
+	// the 15th or Mondays
+	sched, err = ParseSchedule("0 6 15 * mon")
+	c.Assert(err, NotNil)
+	sched, err = ParseSchedule("0 6 15 * 1")
+	c.Assert(err, IsNil)
+	c.Assert(sched.Next(t), Equals, time.Date(2016, 6, 6, 6, 0, 0, 0, time.UTC))
+	c.Assert(sched.Next(time.Date(2016, 6, 14, 7, 0, 0, 0, time.UTC)), Equals, time.Date(2016, 6, 15, 6, 0, 0, 0, time.UTC))
+
+	sched, err = ParseSchedule("0 0 29 2 *")
+	c.Assert(err, IsNil)
+	c.Assert(sched.Next(t), Equals, time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC))
+
+	sched, err = ParseSchedule("0 0 30 2 *")
+	c.Assert(err, IsNil)
+	c.Assert(sched.Next(t).IsZero(), Equals, true)
+}
+
+func (s *ScheduleSuite) TestParseScheduleErrors(c *C) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *"} {
+		_, err := ParseSchedule(spec)
+		c.Assert(err, NotNil, Commentf(spec))
+	}
+}
+
+func (s *ScheduleSuite) TestRunScheduleNextRun(c *C) {
+	rs := &RunSchedule{Schedule: "0 2 * * *", TimeZone: "America/New_York"}
+	// 2 AM in New York is 6 AM UTC in June
+	next, err := rs.NextRun(time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC))
+	c.Assert(err, IsNil)
+	c.Assert(next, Equals, time.Date(2016, 6, 2, 6, 0, 0, 0, time.UTC))
+
+	rs.TimeZone = "Nowhere/Special"
+	_, err = rs.NextRun(time.Now())
+	c.Assert(err, NotNil)
+}
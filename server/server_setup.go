@@ -23,6 +23,7 @@ import (
 
 	rc "github.com/mitre/ptmatch/controllers"
 	logger "github.com/mitre/ptmatch/logger"
+	ptm_models "github.com/mitre/ptmatch/models"
 	"gopkg.in/mgo.v2"
 
 	fhir_svr "github.com/intervention-engine/fhir/server"
@@ -39,6 +40,16 @@ func Setup(e *gin.Engine) {
 	registerRoutes(e)
 }
 
+// StartWorkers starts the background workers, such as the delivery of
+// messages to record matchers, after cleaning up work that was interrupted
+// when the server last stopped.
+func StartWorkers() {
+	rc.FailInterruptedImportJobs(Database)
+	rc.StartOutboxDispatcher(Database)
+	rc.StartRunTimeoutSweeper(Database)
+	rc.StartRunScheduler(Database)
+}
+
 func registerMiddleware(e *gin.Engine) {
 	//------------------------
 	// Third-party middleware
@@ -61,8 +72,7 @@ func registerRoutes(e *gin.Engine) {
 	controller := rc.ResourceController{}
 	controller.DatabaseProvider = Database
 
-	resourceNames := []string{"RecordMatchContext",
-		"RecordMatchSystemInterface", "RecordSet"}
+	resourceNames := []string{"BenchmarkSuite", "RecordMatchContext"}
 
 	for _, name := range resourceNames {
 		e.GET("/"+name+"/:id", controller.GetResource)
@@ -72,15 +82,63 @@ func registerRoutes(e *gin.Engine) {
 		e.GET("/"+name, controller.GetResources)
 	}
 
+	e.GET("/RecordMatchSystemInterface/:id", controller.GetResource)
+	e.POST("/RecordMatchSystemInterface", rc.CreateRecordMatchSystemInterfaceHandler(Database))
+	e.PUT("/RecordMatchSystemInterface/:id", rc.UpdateRecordMatchSystemInterfaceHandler(Database))
+	e.DELETE("/RecordMatchSystemInterface/:id", controller.DeleteResource)
+	e.GET("/RecordMatchSystemInterface", controller.GetResources)
+
+	e.GET("/RecordSet/:id", controller.GetResource)
+	e.POST("/RecordSet", rc.CreateRecordSetHandler(Database))
+	e.PUT("/RecordSet/:id", controller.UpdateResource)
+	e.DELETE("/RecordSet/:id", rc.DeleteRecordSetHandler(Database))
+	e.GET("/RecordSet", controller.GetResources)
+
 	e.POST("/AnswerKey", controller.SetAnswerKey)
+	e.POST("/$process-message", rc.ProcessMessageHandler(Database))
+	e.POST("/RecordSet/:id/$answer-key-from-links", rc.CreateAnswerKeyFromLinksHandler(Database))
+	e.GET("/RecordSet/:id/$validate", rc.ValidateRecordSetHandler(Database))
+	e.GET("/RecordSet/:id/$profile", rc.GetRecordSetProfileHandler(Database))
+	e.GET("/RecordSet/:id/$export", rc.ExportRecordSetHandler(Database))
+	e.POST("/RecordSet/:id/$sample", rc.SampleRecordSetHandler(Database))
+	e.POST("/RecordSet/:id/$split", rc.SplitRecordSetHandler(Database))
+	e.POST("/RecordSet/:id/$union", rc.CombineRecordSetsHandler(Database, ptm_models.DeriveUnion))
+	e.POST("/RecordSet/:id/$intersection", rc.CombineRecordSetsHandler(Database, ptm_models.DeriveIntersection))
+	e.POST("/RecordSet/:id/$pseudonymize", rc.PseudonymizeRecordSetHandler(Database))
+	e.POST("/RecordSet/:id/$import", rc.ImportRecordSetHandler(Database))
+
+	e.POST("/BenchmarkSuite/:id/$run", rc.RunBenchmarkSuiteHandler(Database))
+	e.GET("/BenchmarkSuite/:id/$results", rc.GetBenchmarkResultsHandler(Database))
+
+	e.GET("/RunSchedule", controller.GetResources)
+	e.GET("/RunSchedule/:id", controller.GetResource)
+	e.POST("/RunSchedule", rc.CreateRunScheduleHandler(Database))
+	e.PUT("/RunSchedule/:id", rc.UpdateRunScheduleHandler(Database))
+	e.DELETE("/RunSchedule/:id", controller.DeleteResource)
+
+	e.GET("/ImportJob", controller.GetResources)
+	e.GET("/ImportJob/:id", controller.GetResource)
 
 	name := "RecordMatchRun"
 	e.GET("/"+name, controller.GetResources)
 	e.GET("/"+name+"/:id", controller.GetResource)
 	e.POST("/"+name, rc.CreateRecordMatchRunHandler(Database))
-	e.PUT("/"+name+"/:id", controller.UpdateResource)
+	e.PUT("/"+name+"/:id", rc.UpdateRecordMatchRunHandler(Database))
 	e.DELETE("/"+name+"/:id", controller.DeleteResource)
 
+	e.POST("/"+name+"/:id/$submit", rc.SubmitRecordMatchRunHandler(Database))
+	e.GET("/"+name+"/:id/$snapshot-diff", rc.GetRecordSetSnapshotDiffHandler(Database))
+	e.GET("/"+name+"/:id/$onc-submission", rc.GetONCSubmissionHandler(Database))
+	e.POST("/"+name+"/:id/$resend", rc.ResendRecordMatchRequestHandler(Database))
+	e.POST("/"+name+"/:id/$cancel", rc.CancelRecordMatchRunHandler(Database))
+	e.POST("/"+name+"/:id/$rerun", rc.RerunRecordMatchRunHandler(Database))
+
+	e.GET("/OutboxMessage", controller.GetResources)
+	e.GET("/OutboxMessage/:id", controller.GetResource)
+
+	e.GET("/RecordSetSnapshot", controller.GetResources)
+	e.GET("/RecordSetSnapshot/:id", controller.GetResource)
+
 	e.GET("/RecordMatchRunMetrics", rc.GetRecordMatchRunMetricsHandler(Database))
 	e.GET("/RecordMatchRunLinks/:id", rc.GetRecordMatchRunLinksHandler(Database))
 
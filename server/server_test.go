@@ -24,6 +24,7 @@ import (
 	"net/http/httptest"
 	"regexp"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -34,6 +35,7 @@ import (
 	"gopkg.in/mgo.v2"
 
 	fhir_svr "github.com/intervention-engine/fhir/server"
+	rc "github.com/mitre/ptmatch/controllers"
 	logger "github.com/mitre/ptmatch/logger"
 	"github.com/mitre/ptmatch/middleware"
 	ptm_models "github.com/mitre/ptmatch/models"
@@ -303,7 +305,9 @@ func (s *ServerSuite) TestPostRecordSet(c *C) {
 
 	e := s.Server.Engine
 
-	code, body := request("POST", "/RecordSet",
+	// the fixture's search expression refers to an unreachable server, so
+	// the dry run of the search expression is skipped
+	code, body := request("POST", "/RecordSet?validate=false",
 		bytes.NewReader(buf), "application/json", e)
 	c.Assert(code, Equals, http.StatusCreated)
 	c.Assert(body, NotNil)
@@ -336,6 +340,30 @@ func (s *ServerSuite) TestPostRecordSet(c *C) {
 	c.Assert(code, Equals, http.StatusNotFound)
 }
 
+func (s *ServerSuite) TestDeleteReferencedRecordSet(c *C) {
+	e := s.Server.Engine
+	recSet := ptm_models.InsertResourceFromFile(Database(), "RecordSet", "../fixtures/record-set-01.json").(*ptm_models.RecordSet)
+	run := &ptm_models.RecordMatchRun{MasterRecordSetID: recSet.ID, QueryRecordSetID: recSet.ID}
+	_, err := ptm_models.PersistResource(Database(), "RecordMatchRun", run)
+	c.Assert(err, IsNil)
+
+	path := "/RecordSet/" + recSet.ID.Hex()
+	code, body := request("DELETE", path, nil, "", e)
+	c.Assert(code, Equals, http.StatusConflict)
+	c.Assert(strings.Contains(body, run.ID.Hex()), Equals, true)
+
+	code, body = request("DELETE", path+"?cascade=true&deleteRuns=true", nil, "", e)
+	c.Assert(code, Equals, http.StatusOK)
+	result := rc.DeleteRecordSetResult{}
+	c.Assert(json.Unmarshal([]byte(body), &result), IsNil)
+	c.Assert(result.RecordMatchRunsDeleted, Equals, 1)
+
+	code, _ = request("GET", path, nil, "", e)
+	c.Assert(code, Equals, http.StatusNotFound)
+	code, _ = request("GET", "/RecordMatchRun/"+run.ID.Hex(), nil, "", e)
+	c.Assert(code, Equals, http.StatusNotFound)
+}
+
 func request(method, path string, body io.Reader, ct string, e *gin.Engine) (int, string) {
 	r, _ := http.NewRequest(method, path, body)
 	if body != nil && ct != "" {